@@ -0,0 +1,252 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const redactedValue = "REDACTED"
+
+var (
+	ErrMissingAuditRecord  = errors.New("missing audit record")
+	ErrUnknownOperation    = errors.New("unknown operation")
+	ErrRedactedAuditRecord = errors.New("audit record has redacted values and cannot be resent")
+)
+
+// AuditRecord describes one call made by the client. Request and Response are
+// JSON snapshots taken when the call finishes, with personal and payment data
+// in the request replaced by REDACTED.
+type AuditRecord struct {
+	Operation  Operation       `json:"operation"`
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Latency    time.Duration   `json:"latency_ns"`
+	// CorrelationID is the ID sent with the call and RequestID the one the
+	// API answered with.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+	// Redacted tells whether values of Request were replaced by REDACTED.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// AuditSink receives a record of every call. Errors returned by Write are
+// logged and never fail the call.
+type AuditSink interface {
+	Write(record *AuditRecord) error
+}
+
+func (c *Client) audit(operation Operation, req *http.Request, requestBody interface{}, response interface{}, startedAt time.Time, err error) {
+	if c.auditSink == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.errorf("audit %s: %v", operation, r)
+		}
+	}()
+
+	finishedAt := c.clock.Now()
+	record := &AuditRecord{
+		Operation:  operation,
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Latency:    finishedAt.Sub(startedAt),
+	}
+
+	info := callInfoFrom(req.Context())
+	record.CorrelationID = info.correlationID
+	record.RequestID = info.getRequestID()
+
+	redactor := &redactor{}
+	record.Request, _ = json.Marshal(redactor.requestBody(requestBody))
+	record.Redacted = redactor.redacted
+
+	if err == nil {
+		record.StatusCode = http.StatusOK
+		if response != nil {
+			record.Response, _ = json.Marshal(response)
+		}
+	} else {
+		record.Error = err.Error()
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			record.StatusCode = apiErr.StatusCode
+			record.RequestID = apiErr.RequestID
+		}
+	}
+
+	if err := c.auditSink.Write(record); err != nil {
+		c.logger.errorf("audit %s: %v", operation, err)
+	}
+}
+
+// Resend sends the request of an audit record again, to the endpoint of its
+// operation and with its query string, and returns the raw response body.
+// Records with redacted values fail with ErrRedactedAuditRecord, since the
+// API would assess the REDACTED placeholders instead of the original values.
+func (c *Client) Resend(ctx context.Context, record *AuditRecord) (ret json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(record.Operation, r)
+			ret = nil
+		}
+	}()
+
+	if record == nil {
+		return nil, ErrMissingAuditRecord
+	}
+
+	if record.Redacted {
+		return nil, ErrRedactedAuditRecord
+	}
+
+	var endpoint string
+	switch record.Operation {
+	case OperationRegisterSignup, OperationRegisterWebSignup:
+		endpoint = c.endpoints.Signups
+	case OperationRegisterPayment, OperationRegisterLogin, OperationRegisterWebLogin:
+		endpoint = c.endpoints.Transactions
+	case OperationRegisterFeedback:
+		endpoint = c.endpoints.Feedback
+	default:
+		return nil, fmt.Errorf("%q: %w", record.Operation, ErrUnknownOperation)
+	}
+
+	recordURL, err := url.Parse(record.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var query url.Values
+	if recordURL.RawQuery != "" {
+		query = recordURL.Query()
+	}
+
+	var response json.RawMessage
+	if err := c.post(ctx, record.Operation, endpoint, query, record.Request, &response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func closeAuditSink(sink AuditSink) error {
+	if closer, ok := sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// redactor replaces personal and payment data by REDACTED, noting whether it
+// replaced any.
+type redactor struct {
+	redacted bool
+}
+
+func (r *redactor) requestBody(requestBody interface{}) interface{} {
+	switch body := requestBody.(type) {
+	case postTransactionRequestBody:
+		body.PersonID = r.personID(body.PersonID)
+		body.DebtorAccount = r.bankAccount(body.DebtorAccount)
+		body.CreditorAccount = r.bankAccount(body.CreditorAccount)
+		body.PaymentMethods = r.paymentMethods(body.PaymentMethods)
+		return body
+	case postAssessmentRequestBody:
+		body.PersonID = r.personID(body.PersonID)
+		body.DebtorAccount = r.bankAccount(body.DebtorAccount)
+		body.CreditorAccount = r.bankAccount(body.CreditorAccount)
+		return body
+	case postFeedbackRequestBody:
+		body.PersonID = r.personID(body.PersonID)
+		return body
+	default:
+		return requestBody
+	}
+}
+
+func (r *redactor) value(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	r.redacted = true
+	return redactedValue
+}
+
+func (r *redactor) personID(personID *PersonID) *PersonID {
+	if personID == nil {
+		return nil
+	}
+
+	return &PersonID{Type: personID.Type, Value: r.value(personID.Value)}
+}
+
+func (r *redactor) bankAccount(account *BankAccountInfo) *BankAccountInfo {
+	if account == nil {
+		return nil
+	}
+
+	redacted := *account
+	redacted.HolderTaxID = r.personID(account.HolderTaxID)
+	redacted.AccountNumber = r.value(account.AccountNumber)
+	redacted.AccountCheckDigit = r.value(account.AccountCheckDigit)
+
+	if account.PixKeys != nil {
+		redacted.PixKeys = make([]*PixKey, len(account.PixKeys))
+		for i, key := range account.PixKeys {
+			if key != nil {
+				redacted.PixKeys[i] = &PixKey{Type: key.Type, Value: r.value(key.Value)}
+			}
+		}
+	}
+
+	return &redacted
+}
+
+func (r *redactor) paymentMethods(methods []*PaymentMethod) []*PaymentMethod {
+	if methods == nil {
+		return nil
+	}
+
+	redacted := make([]*PaymentMethod, len(methods))
+	for i, method := range methods {
+		if method == nil {
+			continue
+		}
+
+		m := *method
+		m.CreditCard = r.card(method.CreditCard)
+		m.DebitCard = r.card(method.DebitCard)
+		redacted[i] = &m
+	}
+
+	return redacted
+}
+
+func (r *redactor) card(card *CardInfo) *CardInfo {
+	if card == nil {
+		return nil
+	}
+
+	return &CardInfo{
+		Bin:            r.value(card.Bin),
+		LastFourDigits: r.value(card.LastFourDigits),
+		ExpiryYear:     r.value(card.ExpiryYear),
+		ExpiryMonth:    r.value(card.ExpiryMonth),
+	}
+}
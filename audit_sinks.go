@@ -0,0 +1,251 @@
+package incognia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultAuditBufferSize = 1024
+
+var (
+	ErrAuditBufferFull  = errors.New("audit buffer is full")
+	ErrAuditSinkClosed  = errors.New("audit sink is closed")
+	ErrMissingAuditFile = errors.New("missing audit file path")
+)
+
+// JSONLinesFileSink appends one JSON record per line to a file. When
+// MaxBytes is set, the file is rotated to path.1, path.2 and so on before it
+// grows past it, keeping at most MaxBackups old files.
+type JSONLinesFileSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func NewJSONLinesFileSink(path string, maxBytes int64, maxBackups int) (*JSONLinesFileSink, error) {
+	if path == "" {
+		return nil, ErrMissingAuditFile
+	}
+
+	s := &JSONLinesFileSink{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *JSONLinesFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *JSONLinesFileSink) Write(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return ErrAuditSinkClosed
+	}
+
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	return err
+}
+
+func (s *JSONLinesFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", s.path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *JSONLinesFileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// AsyncAuditSink hands records to another sink from a background goroutine.
+// Write never blocks: records are dropped when the buffer is full.
+type AsyncAuditSink struct {
+	// dropped and failed are updated atomically, so they come first to be
+	// 64-bit aligned on 32-bit platforms.
+	dropped int64
+	failed  int64
+
+	sink    AuditSink
+	records chan *AuditRecord
+	done    chan struct{}
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func NewAsyncAuditSink(sink AuditSink, bufferSize int) *AsyncAuditSink {
+	if bufferSize <= 0 {
+		bufferSize = defaultAuditBufferSize
+	}
+
+	s := &AsyncAuditSink{
+		sink:    sink,
+		records: make(chan *AuditRecord, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// asyncAuditSink wraps sink in an AsyncAuditSink unless it is one already. The
+// wrapper it creates is returned too, since only that one is the client's to
+// stop.
+func asyncAuditSink(sink AuditSink) (AuditSink, *AsyncAuditSink) {
+	if sink == nil {
+		return nil, nil
+	}
+
+	if async, ok := sink.(*AsyncAuditSink); ok {
+		return async, nil
+	}
+
+	wrapper := NewAsyncAuditSink(sink, 0)
+	return wrapper, wrapper
+}
+
+func (s *AsyncAuditSink) run() {
+	defer close(s.done)
+
+	for record := range s.records {
+		if err := s.write(record); err != nil {
+			atomic.AddInt64(&s.failed, 1)
+		}
+	}
+}
+
+func (s *AsyncAuditSink) write(record *AuditRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(record.Operation, r)
+		}
+	}()
+
+	return s.sink.Write(record)
+}
+
+func (s *AsyncAuditSink) Write(record *AuditRecord) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return ErrAuditSinkClosed
+	}
+
+	select {
+	case s.records <- record:
+		return nil
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		return ErrAuditBufferFull
+	}
+}
+
+// Dropped returns how many records were discarded because the buffer was full.
+func (s *AsyncAuditSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Failed returns how many records the wrapped sink failed to write.
+func (s *AsyncAuditSink) Failed() int64 {
+	return atomic.LoadInt64(&s.failed)
+}
+
+// Close writes the buffered records and then closes the wrapped sink, when it
+// implements io.Closer.
+func (s *AsyncAuditSink) Close() error {
+	if !s.stop() {
+		return nil
+	}
+
+	return closeAuditSink(s.sink)
+}
+
+// stop writes the buffered records and stops the background goroutine,
+// leaving the wrapped sink open. It reports whether the sink was running.
+func (s *AsyncAuditSink) stop() bool {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		<-s.done
+		return false
+	}
+	s.closed = true
+	close(s.records)
+	s.mutex.Unlock()
+
+	<-s.done
+
+	return true
+}
@@ -0,0 +1,272 @@
+package incognia
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type recordingAuditSink struct {
+	mutex   sync.Mutex
+	records []*AuditRecord
+	block   chan struct{}
+	err     error
+	panics  bool
+	closed  bool
+}
+
+func (s *recordingAuditSink) Write(record *AuditRecord) error {
+	if s.block != nil {
+		<-s.block
+	}
+	if s.panics {
+		panic("sink panic")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func (s *recordingAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingAuditSink) recorded() []*AuditRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*AuditRecord(nil), s.records...)
+}
+
+type AuditTestSuite struct {
+	suite.Suite
+
+	tokenServer       *httptest.Server
+	transactionServer *httptest.Server
+	statusCode        int
+}
+
+func (suite *AuditTestSuite) SetupTest() {
+	suite.statusCode = http.StatusOK
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.transactionServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if suite.statusCode != http.StatusOK {
+			w.WriteHeader(suite.statusCode)
+			return
+		}
+		res, _ := json.Marshal(TransactionAssessment{ID: "assessment-id", RiskAssessment: LowRisk})
+		w.Write(res)
+	}))
+}
+
+func (suite *AuditTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.transactionServer.Close()
+}
+
+func (suite *AuditTestSuite) newClient(sink AuditSink) *Client {
+	client, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, AuditSink: sink})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.transactionServer.URL
+	return client
+}
+
+func (suite *AuditTestSuite) TestRecordIsRedacted() {
+	sink := &recordingAuditSink{}
+	async := NewAsyncAuditSink(sink, 10)
+	client := suite.newClient(async)
+
+	_, err := client.RegisterPayment(&Payment{
+		AccountID: "account-id",
+		Eval:      &shouldEval,
+		PersonID:  &PersonID{Type: "cpf", Value: "12345678901"},
+		Methods: []*PaymentMethod{{
+			Type:       CreditCard,
+			CreditCard: &CardInfo{Bin: "123456", LastFourDigits: "1234"},
+		}},
+		DebtorAccount: &BankAccountInfo{
+			AccountNumber: "98765",
+			PixKeys:       []*PixKey{{Type: "email", Value: "someone@example.com"}},
+		},
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(async.Close())
+
+	records := sink.recorded()
+	suite.Require().Len(records, 1)
+	record := records[0]
+	suite.Equal(OperationRegisterPayment, record.Operation)
+	suite.Equal("POST", record.Method)
+	suite.Equal(suite.transactionServer.URL+"?eval=true", record.URL)
+	suite.Equal(http.StatusOK, record.StatusCode)
+	suite.Empty(record.Error)
+	suite.True(record.Latency >= 0)
+	suite.False(record.FinishedAt.Before(record.StartedAt))
+	suite.True(sink.closed)
+
+	var request postTransactionRequestBody
+	suite.Require().NoError(json.Unmarshal(record.Request, &request))
+	suite.Equal("account-id", request.AccountID)
+	suite.Equal(redactedValue, request.PersonID.Value)
+	suite.Equal(redactedValue, request.PaymentMethods[0].CreditCard.Bin)
+	suite.Equal(redactedValue, request.PaymentMethods[0].CreditCard.LastFourDigits)
+	suite.Equal(redactedValue, request.DebtorAccount.AccountNumber)
+	suite.Equal(redactedValue, request.DebtorAccount.PixKeys[0].Value)
+	suite.True(record.Redacted)
+
+	_, err = client.Resend(context.Background(), record)
+	suite.Equal(ErrRedactedAuditRecord, err)
+
+	var response TransactionAssessment
+	suite.Require().NoError(json.Unmarshal(record.Response, &response))
+	suite.Equal("assessment-id", response.ID)
+}
+
+func (suite *AuditTestSuite) TestCallerValuesAreNotRedacted() {
+	sink := &recordingAuditSink{}
+	client := suite.newClient(sink)
+
+	personID := &PersonID{Type: "cpf", Value: "12345678901"}
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id", PersonID: personID})
+	suite.NoError(err)
+	suite.Equal("12345678901", personID.Value)
+}
+
+func (suite *AuditTestSuite) TestErrorsAreRecorded() {
+	suite.statusCode = http.StatusBadRequest
+	sink := &recordingAuditSink{}
+	async := NewAsyncAuditSink(sink, 10)
+	client := suite.newClient(async)
+
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Error(err)
+	suite.Require().NoError(async.Close())
+
+	records := sink.recorded()
+	suite.Require().Len(records, 1)
+	suite.Equal(OperationRegisterLogin, records[0].Operation)
+	suite.Equal(http.StatusBadRequest, records[0].StatusCode)
+	suite.Equal(err.Error(), records[0].Error)
+	suite.Empty(records[0].Response)
+}
+
+func (suite *AuditTestSuite) TestFailingSinkDoesNotFailCall() {
+	sink := &recordingAuditSink{panics: true}
+	async := NewAsyncAuditSink(sink, 10)
+	client := suite.newClient(async)
+
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.NoError(err)
+	suite.Require().NoError(async.Close())
+	suite.Equal(int64(1), async.Failed())
+}
+
+func (suite *AuditTestSuite) TestAsyncSinkDropsWhenFull() {
+	sink := &recordingAuditSink{block: make(chan struct{})}
+	async := NewAsyncAuditSink(sink, 1)
+
+	// The first record is taken by the writer goroutine, which then blocks.
+	suite.NoError(async.Write(&AuditRecord{Operation: "first"}))
+	suite.Eventually(func() bool { return len(async.records) == 0 }, time.Second, time.Millisecond)
+	suite.NoError(async.Write(&AuditRecord{Operation: "second"}))
+	suite.Equal(ErrAuditBufferFull, async.Write(&AuditRecord{Operation: "third"}))
+	suite.Equal(int64(1), async.Dropped())
+
+	close(sink.block)
+	suite.NoError(async.Close())
+	suite.Len(sink.recorded(), 2)
+	suite.Equal(ErrAuditSinkClosed, async.Write(&AuditRecord{}))
+}
+
+func (suite *AuditTestSuite) TestResendRecord() {
+	sink := &recordingAuditSink{}
+	async := NewAsyncAuditSink(sink, 10)
+	client := suite.newClient(async)
+
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	record := &AuditRecord{}
+	suite.Eventually(func() bool { return len(sink.recorded()) == 1 }, time.Second, time.Millisecond)
+	*record = *sink.recorded()[0]
+	suite.False(record.Redacted, "logins without personal data have nothing to redact")
+	record.URL = "https://example.com/elsewhere?eval=false"
+
+	response, err := client.Resend(context.Background(), record)
+	suite.Require().NoError(err)
+	suite.Require().NoError(async.Close())
+
+	var assessment TransactionAssessment
+	suite.NoError(json.Unmarshal(response, &assessment))
+	suite.Equal("assessment-id", assessment.ID)
+
+	records := sink.recorded()
+	suite.Require().Len(records, 2)
+	suite.Equal(suite.transactionServer.URL+"?eval=false", records[1].URL)
+	suite.JSONEq(string(records[0].Request), string(records[1].Request))
+
+	_, err = client.Resend(context.Background(), &AuditRecord{Operation: "unknown"})
+	suite.True(errors.Is(err, ErrUnknownOperation))
+	_, err = client.Resend(context.Background(), nil)
+	suite.Equal(ErrMissingAuditRecord, err)
+}
+
+func (suite *AuditTestSuite) TestJSONLinesFileSinkRotates() {
+	dir, err := ioutil.TempDir("", "audit")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	sink, err := NewJSONLinesFileSink(path, 200, 2)
+	suite.Require().NoError(err)
+
+	for i := 0; i < 10; i++ {
+		suite.Require().NoError(sink.Write(&AuditRecord{Operation: OperationRegisterLogin, Method: "POST"}))
+	}
+	suite.Require().NoError(sink.Close())
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		suite.Require().NoError(err)
+		suite.True(info.Size() <= 200)
+	}
+	_, err = os.Stat(path + ".3")
+	suite.True(os.IsNotExist(err))
+
+	file, err := os.Open(path)
+	suite.Require().NoError(err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	suite.Require().True(scanner.Scan())
+	var record AuditRecord
+	suite.NoError(json.Unmarshal(scanner.Bytes(), &record))
+	suite.Equal(OperationRegisterLogin, record.Operation)
+
+	suite.Equal(ErrAuditSinkClosed, sink.Write(&AuditRecord{}))
+}
+
+func (suite *AuditTestSuite) TestJSONLinesFileSinkRequiresPath() {
+	_, err := NewJSONLinesFileSink("", 0, 0)
+	suite.True(errors.Is(err, ErrMissingAuditFile))
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
@@ -0,0 +1,181 @@
+package incognia
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"repo.incognia.com/go/incognia/internal/ratelimit"
+)
+
+const defaultBatchConcurrency = 4
+
+var (
+	ErrInvalidBatchItem = errors.New("batch item must hold exactly one request")
+)
+
+// BatchItem holds exactly one request to assess.
+type BatchItem struct {
+	Signup    *Signup
+	WebSignup *WebSignup
+	Payment   *Payment
+	Login     *Login
+	WebLogin  *WebLogin
+}
+
+// BatchResult holds the assessment of the item at Index, or the error that
+// prevented it. SignupAssessment is set for signups and
+// TransactionAssessment for payments and logins.
+type BatchResult struct {
+	Index                 int
+	SignupAssessment      *SignupAssessment
+	TransactionAssessment *TransactionAssessment
+	Err                   error
+}
+
+type BatchProgress struct {
+	Total     int
+	Completed int
+	Failed    int
+}
+
+type BatchOptions struct {
+	// Concurrency is how many items are in flight at once. Defaults to 4.
+	Concurrency int
+	// RateLimit is the maximum items per second, on top of the client rate
+	// limit. Zero means no limit.
+	RateLimit      float64
+	RateLimitBurst int
+	// OnProgress is called after each item completes, one call at a time.
+	// Panics in it are recovered and reported like those of calls, through
+	// the log and OnPanic, and leave the results unchanged.
+	OnProgress func(progress BatchProgress, result BatchResult)
+}
+
+// AssessBatch assesses every item and returns one result per item, in the
+// order of items. A failed item is reported in its result and does not stop
+// the others. When ctx is done, items not yet completed fail with ctx.Err(),
+// which is also returned.
+func (c *Client) AssessBatch(ctx context.Context, items []BatchItem, opts *BatchOptions) ([]BatchResult, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	limiter := ratelimit.New(opts.RateLimit, opts.RateLimitBurst)
+	results := make([]BatchResult, len(items))
+	progress := BatchProgress{Total: len(items)}
+	var progressMutex sync.Mutex
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result := BatchResult{Index: index}
+				if err := limiter.Wait(ctx); err != nil {
+					result.Err = err
+				} else {
+					result = c.assessBatchItem(ctx, index, items[index])
+				}
+				results[index] = result
+
+				progressMutex.Lock()
+				progress.Completed++
+				if result.Err != nil {
+					progress.Failed++
+				}
+				if opts.OnProgress != nil {
+					c.reportBatchProgress(opts.OnProgress, progress, result, items[index].operation())
+				}
+				progressMutex.Unlock()
+			}
+		}()
+	}
+
+	next := 0
+dispatch:
+	for ; next < len(items); next++ {
+		select {
+		case indexes <- next:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for index := next; index < len(items); index++ {
+		results[index] = BatchResult{Index: index, Err: ctx.Err()}
+	}
+
+	return results, ctx.Err()
+}
+
+func (c *Client) reportBatchProgress(onProgress func(BatchProgress, BatchResult), progress BatchProgress, result BatchResult, operation Operation) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicError(operation, r)
+		}
+	}()
+
+	onProgress(progress, result)
+}
+
+func (item BatchItem) operation() Operation {
+	switch {
+	case item.Signup != nil:
+		return OperationRegisterSignup
+	case item.WebSignup != nil:
+		return OperationRegisterWebSignup
+	case item.Payment != nil:
+		return OperationRegisterPayment
+	case item.Login != nil:
+		return OperationRegisterLogin
+	default:
+		return OperationRegisterWebLogin
+	}
+}
+
+func (c *Client) assessBatchItem(ctx context.Context, index int, item BatchItem) (result BatchResult) {
+	result.Index = index
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = BatchResult{Index: index, Err: c.panicError(item.operation(), r)}
+		}
+	}()
+
+	requests := 0
+	for _, set := range []bool{item.Signup != nil, item.WebSignup != nil, item.Payment != nil, item.Login != nil, item.WebLogin != nil} {
+		if set {
+			requests++
+		}
+	}
+
+	if requests != 1 {
+		result.Err = ErrInvalidBatchItem
+		return result
+	}
+
+	switch {
+	case item.Signup != nil:
+		result.SignupAssessment, result.Err = c.registerSignup(ctx, item.Signup)
+	case item.WebSignup != nil:
+		result.SignupAssessment, result.Err = c.registerWebSignup(ctx, item.WebSignup)
+	case item.Payment != nil:
+		result.TransactionAssessment, result.Err = c.registerPayment(ctx, item.Payment)
+	case item.Login != nil:
+		result.TransactionAssessment, result.Err = c.registerLogin(ctx, item.Login)
+	case item.WebLogin != nil:
+		result.TransactionAssessment, result.Err = c.registerWebLogin(ctx, item.WebLogin)
+	}
+
+	return result
+}
@@ -0,0 +1,182 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchTestSuite struct {
+	suite.Suite
+
+	client      *Client
+	tokenServer *httptest.Server
+	server      *httptest.Server
+
+	inFlight    int32
+	maxInFlight int32
+	block       chan struct{}
+}
+
+func (suite *BatchTestSuite) SetupTest() {
+	suite.inFlight, suite.maxInFlight = 0, 0
+	suite.block = nil
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&suite.inFlight, 1)
+		defer atomic.AddInt32(&suite.inFlight, -1)
+		for {
+			highest := atomic.LoadInt32(&suite.maxInFlight)
+			if current <= highest || atomic.CompareAndSwapInt32(&suite.maxInFlight, highest, current) {
+				break
+			}
+		}
+
+		if suite.block != nil {
+			select {
+			case <-suite.block:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["account_id"] == "failing-account" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		id, _ := body["account_id"].(string)
+		if id == "" {
+			id, _ = body["installation_id"].(string)
+		}
+		res, _ := json.Marshal(map[string]string{"id": id, "risk_assessment": string(LowRisk)})
+		w.Write(res)
+	}))
+
+	suite.client, _ = New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret})
+	suite.client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	suite.client.endpoints.Signups = suite.server.URL
+	suite.client.endpoints.Transactions = suite.server.URL
+}
+
+func (suite *BatchTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *BatchTestSuite) TestResultsAreOrderedWithPerItemErrors() {
+	items := []BatchItem{
+		{Signup: &Signup{InstallationID: "installation-0"}},
+		{Login: &Login{AccountID: "account-1"}},
+		{Login: &Login{AccountID: "failing-account"}},
+		{},
+		{Payment: &Payment{AccountID: "account-4"}},
+		{WebLogin: &WebLogin{AccountID: "account-5"}},
+		{WebSignup: &WebSignup{RequestToken: "request-token"}, Login: &Login{AccountID: "account-6"}},
+	}
+
+	var progressMutex sync.Mutex
+	var progress []BatchProgress
+	results, err := suite.client.AssessBatch(context.Background(), items, &BatchOptions{
+		Concurrency: 3,
+		RateLimit:   1000,
+		OnProgress: func(p BatchProgress, result BatchResult) {
+			progressMutex.Lock()
+			defer progressMutex.Unlock()
+			progress = append(progress, p)
+		},
+	})
+	suite.NoError(err)
+	suite.Require().Len(results, len(items))
+
+	for i, result := range results {
+		suite.Equal(i, result.Index)
+	}
+	suite.Equal("installation-0", results[0].SignupAssessment.ID)
+	suite.Equal("account-1", results[1].TransactionAssessment.ID)
+	suite.IsType(&APIError{}, results[2].Err)
+	suite.Nil(results[2].TransactionAssessment)
+	suite.Equal(ErrInvalidBatchItem, results[3].Err)
+	suite.Equal("account-4", results[4].TransactionAssessment.ID)
+	suite.Equal("account-5", results[5].TransactionAssessment.ID)
+	suite.Equal(ErrInvalidBatchItem, results[6].Err)
+
+	suite.Require().Len(progress, len(items))
+	suite.Equal(BatchProgress{Total: 7, Completed: 7, Failed: 3}, progress[len(progress)-1])
+	suite.True(atomic.LoadInt32(&suite.maxInFlight) <= 3)
+}
+
+func (suite *BatchTestSuite) TestConcurrencyIsBounded() {
+	items := make([]BatchItem, 20)
+	for i := range items {
+		items[i] = BatchItem{Login: &Login{AccountID: "account"}}
+	}
+
+	results, err := suite.client.AssessBatch(context.Background(), items, &BatchOptions{Concurrency: 2})
+	suite.NoError(err)
+	suite.Len(results, 20)
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.maxInFlight))
+}
+
+func (suite *BatchTestSuite) TestCancellationFailsRemainingItems() {
+	suite.block = make(chan struct{})
+	defer close(suite.block)
+
+	items := make([]BatchItem, 10)
+	for i := range items {
+		items[i] = BatchItem{Login: &Login{AccountID: "account"}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	results, err := suite.client.AssessBatch(ctx, items, &BatchOptions{Concurrency: 2})
+	suite.Equal(context.Canceled, err)
+	suite.Require().Len(results, 10)
+	for i, result := range results {
+		suite.Equal(i, result.Index)
+		suite.Error(result.Err)
+	}
+	suite.Equal(context.Canceled, results[9].Err)
+}
+
+func (suite *BatchTestSuite) TestProgressPanicsAreRecovered() {
+	var panics []*PanicError
+	suite.client.logger = newClientLogger(nil, LogLevelOff)
+	suite.client.onPanic = func(err *PanicError) { panics = append(panics, err) }
+
+	items := []BatchItem{{Login: &Login{AccountID: "account-0"}}, {Payment: &Payment{AccountID: "account-1"}}}
+	results, err := suite.client.AssessBatch(context.Background(), items, &BatchOptions{
+		Concurrency: 1,
+		OnProgress:  func(BatchProgress, BatchResult) { panic("progress panic") },
+	})
+	suite.NoError(err)
+	suite.Equal("account-0", results[0].TransactionAssessment.ID)
+	suite.Equal("account-1", results[1].TransactionAssessment.ID)
+
+	suite.Require().Len(panics, 2)
+	suite.Equal("progress panic", panics[0].Value)
+	suite.Equal(OperationRegisterLogin, panics[0].Operation)
+	suite.Equal(OperationRegisterPayment, panics[1].Operation)
+}
+
+func (suite *BatchTestSuite) TestEmptyBatch() {
+	results, err := suite.client.AssessBatch(context.Background(), nil, nil)
+	suite.NoError(err)
+	suite.Empty(results)
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
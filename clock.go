@@ -0,0 +1,25 @@
+package incognia
+
+import "time"
+
+// Clock tells the time to the client. It stamps and expires tokens and
+// measures latencies, so tests can control them, for instance with
+// incogniatest.Clock. Waits, such as retry backoffs and timeouts, still
+// take real time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+
+	return clock
+}
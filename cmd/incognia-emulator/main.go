@@ -0,0 +1,76 @@
+// Command incognia-emulator serves a local, rule-based stand-in for the
+// Incognia API.
+//
+// Usage:
+//
+//	incognia-emulator [-addr :8080] [-config rules.yaml]
+//
+// Point clients at it by setting BaseURL, or INCOGNIA_BASE_URL, to its
+// address.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"repo.incognia.com/go/incognia/emulator"
+)
+
+func main() {
+	flags := flag.NewFlagSet("incognia-emulator", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	configPath := flags.String("config", "", "YAML or JSON file with credentials and rules")
+	clientID := flags.String("client-id", "", "accepted client ID, overriding the config; any is accepted when empty")
+	clientSecret := flags.String("client-secret", "", "accepted client secret, overriding the config")
+	flags.Parse(os.Args[1:])
+
+	config := &emulator.Config{}
+	if *configPath != "" {
+		var err error
+		config, err = emulator.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "incognia-emulator: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *clientID != "" {
+		config.ClientID = *clientID
+		config.ClientSecret = *clientSecret
+	}
+
+	emu, err := emulator.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "incognia-emulator: %v\n", err)
+		os.Exit(1)
+	}
+
+	log.Printf("incognia-emulator listening on %s with %d rules", *addr, len(config.Rules))
+	if err := http.ListenAndServe(*addr, logRequests(emu)); err != nil {
+		fmt.Fprintf(os.Stderr, "incognia-emulator: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, recorder.statusCode, time.Since(start))
+	})
+}
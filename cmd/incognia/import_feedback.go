@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"repo.incognia.com/go/incognia/feedbackimport"
+)
+
+func runImportFeedback(args []string) error {
+	flags := flag.NewFlagSet("import-feedback", flag.ExitOnError)
+	configPath := flags.String("config", "", "client config file, instead of INCOGNIA_* variables")
+	mappingPath := flags.String("mapping", "", "YAML or JSON mapping from input columns to feedback fields")
+	inputPath := flags.String("input", "", "CSV or JSON lines file of feedbacks")
+	checkpointPath := flags.String("checkpoint", "", "file recording sent rows, to resume an interrupted import")
+	reportPath := flags.String("report", "-", "per-row report file, - for standard output")
+	format := flags.String("format", "csv", "report format, csv or json")
+	workers := flags.Int("workers", 1, "feedbacks in flight at once")
+	rate := flags.Float64("rate", 0, "maximum feedbacks per second, 0 for no limit")
+	flags.Parse(args)
+
+	if *mappingPath == "" || *inputPath == "" {
+		return fmt.Errorf("-mapping and -input are required")
+	}
+
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("-format: %q is not csv or json", *format)
+	}
+
+	mapping, err := feedbackimport.LoadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(*configPath)
+	if err != nil {
+		return err
+	}
+
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	var rows feedbackimport.Rows
+	switch strings.ToLower(filepath.Ext(*inputPath)) {
+	case ".csv":
+		if rows, err = feedbackimport.NewCSVRows(input); err != nil {
+			return err
+		}
+	case ".jsonl", ".ndjson":
+		rows = feedbackimport.NewJSONLinesRows(input)
+	default:
+		return fmt.Errorf("-input: unknown extension %q, expected .csv, .jsonl or .ndjson", filepath.Ext(*inputPath))
+	}
+
+	var fingerprint string
+	if *checkpointPath != "" {
+		if fingerprint, err = feedbackimport.FileFingerprint(*inputPath); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		stop()
+	}()
+
+	report, importErr := feedbackimport.Import(ctx, &feedbackimport.Config{
+		Client:           client,
+		Mapping:          mapping,
+		Workers:          *workers,
+		RateLimit:        *rate,
+		CheckpointPath:   *checkpointPath,
+		InputFingerprint: fingerprint,
+	}, rows)
+	if report == nil {
+		return importErr
+	}
+
+	if err := writeImportReport(*reportPath, *format, report); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "sent: %d, invalid: %d, failed: %d, skipped: %d\n", report.Sent, report.Invalid, report.Failed, report.Skipped)
+
+	return importErr
+}
+
+func writeImportReport(path, format string, report *feedbackimport.Report) (err error) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = file
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	return report.WriteCSV(w)
+}
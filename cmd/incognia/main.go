@@ -0,0 +1,86 @@
+// Command incognia runs bulk operations against the Incognia API.
+//
+// Usage:
+//
+//	incognia <command> [flags]
+//
+// The client is configured from INCOGNIA_* environment variables, or from the
+// file given with -config.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"repo.incognia.com/go/incognia"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{name: "replay", description: "re-send requests recorded in an audit log and report changes", run: runReplay},
+	{name: "import-feedback", description: "register feedbacks from a CSV or JSON lines file", run: runImportFeedback},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "incognia %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: incognia <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// newClient builds a client from the -config file when set, and from the
+// environment otherwise.
+func newClient(configPath string) (*incognia.Client, error) {
+	if configPath == "" {
+		return incognia.NewFromEnv()
+	}
+
+	config, err := incognia.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return incognia.New(config)
+}
+
+// optionalBool parses an empty flag as unset.
+func optionalBool(name, value string) (*bool, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "true":
+		v := true
+		return &v, nil
+	case "false":
+		v := false
+		return &v, nil
+	default:
+		return nil, fmt.Errorf("-%s: %q is not true or false", name, value)
+	}
+}
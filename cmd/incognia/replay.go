@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"repo.incognia.com/go/incognia"
+	"repo.incognia.com/go/incognia/replay"
+)
+
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := flags.String("config", "", "client config file, instead of INCOGNIA_* variables")
+	logPath := flags.String("log", "-", "audit log to replay, - for standard input")
+	reportPath := flags.String("report", "-", "report file, - for standard output")
+	format := flags.String("format", "text", "report format, text or json")
+	workers := flags.Int("workers", 1, "requests in flight at once")
+	rate := flags.Float64("rate", 0, "maximum requests per second, 0 for no limit")
+	policyID := flags.String("policy-id", "", "policy to evaluate every request with")
+	evalFlag := flags.String("eval", "", "override eval of payments and logins, true or false")
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("-format: %q is not text or json", *format)
+	}
+
+	eval, err := optionalBool("eval", *evalFlag)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(*configPath)
+	if err != nil {
+		return err
+	}
+
+	records, err := readRecords(*logPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		stop()
+	}()
+
+	report, replayErr := replay.Replay(ctx, &replay.Config{
+		Client:    client,
+		Workers:   *workers,
+		RateLimit: *rate,
+		PolicyID:  *policyID,
+		Eval:      eval,
+	}, records)
+	if report == nil {
+		return replayErr
+	}
+
+	if err := writeReport(*reportPath, *format, report); err != nil {
+		return err
+	}
+
+	return replayErr
+}
+
+func readRecords(path string) ([]*incognia.AuditRecord, error) {
+	if path == "-" {
+		return replay.ReadRecords(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return replay.ReadRecords(file)
+}
+
+func writeReport(path, format string, report *replay.Report) (err error) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = file
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	return report.WriteText(w)
+}
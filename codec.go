@@ -0,0 +1,102 @@
+package incognia
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var errTrailingData = errors.New("invalid character after top-level value")
+
+// Codec encodes request bodies and decodes responses, including the
+// Evidence and Signals maps. Codecs of most JSON libraries compatible with
+// encoding/json can be set as is. With DeduplicateRequests, Marshal must
+// encode equal values to equal bytes, sorting map keys as encoding/json does,
+// or identical calls are not deduplicated.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StandardCodec is the default Codec, backed by encoding/json. With
+// UseNumber, numbers in Evidence, Signals and other untyped values decode as
+// json.Number, so large integers keep their precision.
+type StandardCodec struct {
+	UseNumber bool
+}
+
+func (c StandardCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c StandardCodec) Unmarshal(data []byte, v interface{}) error {
+	if !c.UseNumber {
+		return json.Unmarshal(data, v)
+	}
+
+	return c.decode(bytes.NewReader(data), v)
+}
+
+// decode decodes the JSON value read from reader, which may only be followed
+// by whitespace, as json.Unmarshal requires. It returns io.EOF when reader has
+// no value.
+func (c StandardCodec) decode(reader io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(reader)
+	if c.UseNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	_, err := decoder.Token()
+	switch err {
+	case io.EOF:
+		return nil
+	case nil:
+		return errTrailingData
+	default:
+		return err
+	}
+}
+
+func codecOrStandard(codec Codec) Codec {
+	if codec == nil {
+		return StandardCodec{}
+	}
+
+	return codec
+}
+
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// setNumber sets a json.Number to an output of a numeric kind, or of type
+// json.Number, as the float64 decoded without UseNumber would be.
+func setNumber(number json.Number, out reflect.Value) error {
+	if out.Type() == jsonNumberType {
+		out.Set(reflect.ValueOf(number))
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.Float32, reflect.Float64:
+		value, err := number.Float64()
+		if err != nil {
+			return err
+		}
+		out.SetFloat(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := number.Int64()
+		if err != nil {
+			return err
+		}
+		out.SetInt(value)
+	default:
+		return fmt.Errorf("expecting outValue to be a pointer to %s", reflect.Float64.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package incognia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+const largeIntegerEvidence = `{"id":"id","risk_assessment":"low_risk","evidence":{"account_integrity":{"risk_window_remaining":9007199254740993},"distance":12.5},"signals":{"count":9007199254740993}}`
+
+type countingCodec struct {
+	StandardCodec
+
+	mutex      sync.Mutex
+	marshals   int
+	unmarshals int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.mutex.Lock()
+	c.marshals++
+	c.mutex.Unlock()
+	return c.StandardCodec.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.mutex.Lock()
+	c.unmarshals++
+	c.mutex.Unlock()
+	return c.StandardCodec.Unmarshal(data, v)
+}
+
+type CodecTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	server      *httptest.Server
+	bodies      []string
+}
+
+func (suite *CodecTestSuite) SetupTest() {
+	suite.bodies = nil
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		suite.bodies = append(suite.bodies, string(body))
+		w.Write([]byte(largeIntegerEvidence))
+	}))
+}
+
+func (suite *CodecTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *CodecTestSuite) newClient(codec Codec) *Client {
+	client, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, LogLevel: LogLevelOff, Codec: codec})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.server.URL
+	return client
+}
+
+func (suite *CodecTestSuite) TestDefaultCodecDecodesNumbersAsFloat64() {
+	assessment, err := suite.newClient(nil).RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	var distance float64
+	suite.NoError(assessment.Evidence.GetEvidence("distance", &distance))
+	suite.Equal(12.5, distance)
+
+	remaining, err := assessment.Evidence.GetEvidenceAsInt64("account_integrity.risk_window_remaining")
+	suite.NoError(err)
+	suite.NotEqual(int64(9007199254740993), remaining, "float64 cannot hold it")
+}
+
+func (suite *CodecTestSuite) TestUseNumberKeepsLargeIntegers() {
+	assessment, err := suite.newClient(StandardCodec{UseNumber: true}).RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	remaining, err := assessment.Evidence.GetEvidenceAsInt64("account_integrity.risk_window_remaining")
+	suite.NoError(err)
+	suite.Equal(int64(9007199254740993), remaining)
+
+	count, err := assessment.Signals.GetSignalAsInt64("count")
+	suite.NoError(err)
+	suite.Equal(int64(9007199254740993), count)
+
+	var number json.Number
+	suite.NoError(assessment.Evidence.GetEvidence("distance", &number))
+	suite.Equal(json.Number("12.5"), number)
+
+	var distance float64
+	suite.NoError(assessment.Evidence.GetEvidence("distance", &distance))
+	suite.Equal(12.5, distance)
+
+	distanceAsInt64, err := assessment.Evidence.GetEvidenceAsInt64("distance")
+	suite.NoError(err)
+	suite.Equal(int64(125), distanceAsInt64)
+
+	var name string
+	suite.EqualError(assessment.Evidence.GetEvidence("distance", &name), "expecting outValue to be a pointer to float64")
+}
+
+func (suite *CodecTestSuite) TestUseNumberDecodesNumericSlices() {
+	var evidence Evidence
+	suite.Require().NoError(StandardCodec{UseNumber: true}.Unmarshal([]byte(`{"nums":[1,2,9007199254740993],"ratios":[0.5,1],"mixed":[1,"a"]}`), &evidence))
+
+	var floats []float64
+	suite.NoError(evidence.GetEvidence("nums", &floats))
+	suite.Equal([]float64{1, 2, 9007199254740993}, floats)
+
+	var ints []int64
+	suite.NoError(evidence.GetEvidence("nums", &ints))
+	suite.Equal([]int64{1, 2, 9007199254740993}, ints)
+
+	var numbers []json.Number
+	suite.NoError(evidence.GetEvidence("ratios", &numbers))
+	suite.Equal([]json.Number{"0.5", "1"}, numbers)
+
+	suite.Error(evidence.GetEvidence("ratios", &ints))
+
+	var names []string
+	suite.EqualError(evidence.GetEvidence("nums", &names), "expecting outValue to be a pointer to slice of float64")
+	suite.EqualError(evidence.GetEvidence("mixed", &names), "expecting outValue to be a pointer to slice of interface")
+
+	var mixed []interface{}
+	suite.NoError(evidence.GetEvidence("mixed", &mixed))
+	suite.Equal([]interface{}{json.Number("1"), "a"}, mixed)
+}
+
+func (suite *CodecTestSuite) TestCustomCodecIsUsed() {
+	codec := &countingCodec{}
+	client := suite.newClient(codec)
+
+	assessment, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	suite.Equal("id", assessment.ID)
+
+	suite.Equal(1, codec.marshals)
+	suite.Equal(1, codec.unmarshals)
+	suite.Len(suite.bodies, 1)
+	suite.Contains(suite.bodies[0], `"account_id":"account-id"`)
+}
+
+func TestCodecTestSuite(t *testing.T) {
+	suite.Run(t, new(CodecTestSuite))
+}
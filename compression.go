@@ -0,0 +1,50 @@
+package incognia
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultCompressionThreshold = 1024
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported response content encoding")
+)
+
+// compressRequestBody gzips body into a new buffer when compression is on
+// and body is at least the threshold. It returns nil otherwise.
+func (c *Client) compressRequestBody(body *requestBuffer) (*requestBuffer, error) {
+	if !c.compressRequests || len(body.bytes()) < c.compressionThreshold {
+		return nil, nil
+	}
+
+	return gzipRequestBody(body.bytes())
+}
+
+// responseReader returns a reader of the body of res, decompressed by its
+// Content-Encoding, and a function to call once done with it. The client
+// asks for gzip itself, so the transport leaves compressed bodies to it.
+// An empty body is returned as is whatever its encoding, so an error
+// response without a body still becomes an APIError.
+func responseReader(res *http.Response) (io.Reader, func(), error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return res.Body, func() {}, nil
+	case "gzip":
+		body := bufio.NewReader(res.Body)
+		if _, err := body.Peek(1); err == io.EOF {
+			return body, func() {}, nil
+		}
+		reader, err := getGzipReader(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, func() { putGzipReader(reader) }, nil
+	default:
+		return nil, nil, fmt.Errorf("%q: %w", encoding, ErrUnsupportedContentEncoding)
+	}
+}
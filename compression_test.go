@@ -0,0 +1,221 @@
+package incognia
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CompressionTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	server      *httptest.Server
+
+	mutex            sync.Mutex
+	requests         []*postTransactionRequestBody
+	contentEncodings []string
+	failures         int
+	responseEncoding string
+}
+
+func (suite *CompressionTestSuite) SetupTest() {
+	suite.requests = nil
+	suite.contentEncodings = nil
+	suite.failures = 0
+	suite.responseEncoding = ""
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gzipReader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reader = gzipReader
+		}
+
+		var body postTransactionRequestBody
+		if err := json.NewDecoder(reader).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		suite.mutex.Lock()
+		suite.requests = append(suite.requests, &body)
+		suite.contentEncodings = append(suite.contentEncodings, r.Header.Get("Content-Encoding"))
+		fail := suite.failures > 0
+		suite.failures--
+		responseEncoding := suite.responseEncoding
+		suite.mutex.Unlock()
+
+		if fail {
+			if responseEncoding == "gzip" {
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		response := []byte(`{"id":"` + body.ExternalID + `","risk_assessment":"low_risk"}`)
+		switch {
+		case responseEncoding == "gzip" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"):
+			w.Header().Set("Content-Encoding", "gzip")
+			writer := gzip.NewWriter(w)
+			writer.Write(response)
+			writer.Close()
+		case responseEncoding == "br":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write(response)
+		default:
+			w.Write(response)
+		}
+	}))
+}
+
+func (suite *CompressionTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *CompressionTestSuite) newClient(config *IncogniaClientConfig) *Client {
+	config.ClientID = clientID
+	config.ClientSecret = clientSecret
+	config.LogLevel = LogLevelOff
+	client, err := New(config)
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.server.URL
+	return client
+}
+
+func largePayment() *Payment {
+	payment := &Payment{
+		AccountID:        "account-id",
+		ExternalID:       "external-id",
+		CustomProperties: map[string]interface{}{},
+		Addresses: []*TransactionAddress{
+			{Type: Billing, AddressLine: "350 Fifth Avenue, New York, NY 10118"},
+			{Type: Shipping, AddressLine: "20 W 34th St, New York, NY 10001"},
+		},
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		payment.CustomProperties[key] = strings.Repeat(key, 200)
+	}
+
+	return payment
+}
+
+func (suite *CompressionTestSuite) TestLargeRequestsAreCompressed() {
+	client := suite.newClient(&IncogniaClientConfig{CompressRequests: true})
+
+	assessment, err := client.RegisterPayment(largePayment())
+	suite.Require().NoError(err)
+	suite.Equal("external-id", assessment.ID)
+
+	suite.Equal([]string{"gzip"}, suite.contentEncodings)
+	suite.Equal("account-id", suite.requests[0].AccountID)
+	suite.Equal(strings.Repeat("h", 200), suite.requests[0].CustomProperties["h"])
+	suite.Len(suite.requests[0].Addresses, 2)
+}
+
+func (suite *CompressionTestSuite) TestSmallRequestsAreNotCompressed() {
+	client := suite.newClient(&IncogniaClientConfig{CompressRequests: true})
+	_, err := client.RegisterPayment(&Payment{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	client = suite.newClient(&IncogniaClientConfig{CompressRequests: true, CompressionThreshold: 10})
+	_, err = client.RegisterPayment(&Payment{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	client = suite.newClient(&IncogniaClientConfig{})
+	_, err = client.RegisterPayment(largePayment())
+	suite.Require().NoError(err)
+
+	suite.Equal([]string{"", "gzip", ""}, suite.contentEncodings)
+}
+
+func (suite *CompressionTestSuite) TestRetriesResendCompressedBody() {
+	suite.failures = 1
+	client := suite.newClient(&IncogniaClientConfig{CompressRequests: true, MaxRetries: 1, RetryBackoff: time.Millisecond})
+
+	_, err := client.RegisterPayment(largePayment())
+	suite.Require().NoError(err)
+	suite.Equal([]string{"gzip", "gzip"}, suite.contentEncodings)
+	suite.Equal(suite.requests[0], suite.requests[1])
+}
+
+func (suite *CompressionTestSuite) TestCompressedResponses() {
+	suite.responseEncoding = "gzip"
+	client := suite.newClient(&IncogniaClientConfig{CompressRequests: true})
+
+	assessment, err := client.RegisterPayment(largePayment())
+	suite.Require().NoError(err)
+	suite.Equal("external-id", assessment.ID)
+
+	suite.responseEncoding = "br"
+	_, err = client.RegisterPayment(largePayment())
+	suite.True(errors.Is(err, ErrUnsupportedContentEncoding))
+}
+
+func (suite *CompressionTestSuite) TestEmptyCompressedErrorResponse() {
+	suite.responseEncoding = "gzip"
+	suite.failures = 1
+	client := suite.newClient(&IncogniaClientConfig{})
+
+	_, err := client.RegisterPayment(largePayment())
+	var apiErr *APIError
+	suite.Require().True(errors.As(err, &apiErr))
+	suite.Equal(http.StatusTooManyRequests, apiErr.StatusCode)
+	suite.Empty(apiErr.Body)
+}
+
+func (suite *CompressionTestSuite) TestResponseReader() {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(`{"id":"id"}`))
+	writer.Close()
+
+	reader, closeReader, err := responseReader(&http.Response{
+		Header: http.Header{"Content-Encoding": []string{"GZIP"}},
+		Body:   ioutil.NopCloser(&compressed),
+	})
+	suite.Require().NoError(err)
+	body, err := ioutil.ReadAll(reader)
+	closeReader()
+	suite.NoError(err)
+	suite.Equal(`{"id":"id"}`, string(body))
+
+	_, _, err = responseReader(&http.Response{
+		Header: http.Header{"Content-Encoding": []string{"gzip"}},
+		Body:   ioutil.NopCloser(strings.NewReader(`{"id":"id"}`)),
+	})
+	suite.Error(err)
+
+	reader, closeReader, err = responseReader(&http.Response{
+		Header: http.Header{"Content-Encoding": []string{"gzip"}},
+		Body:   ioutil.NopCloser(strings.NewReader("")),
+	})
+	suite.Require().NoError(err)
+	body, err = ioutil.ReadAll(reader)
+	closeReader()
+	suite.NoError(err)
+	suite.Empty(body)
+}
+
+func TestCompressionTestSuite(t *testing.T) {
+	suite.Run(t, new(CompressionTestSuite))
+}
@@ -0,0 +1,393 @@
+package incognia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "INCOGNIA_"
+
+// ConfigError lists every problem found while loading a configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid incognia client config: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ConfigError) add(format string, v ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, v...))
+}
+
+func (e *ConfigError) errOrNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fileConfig is the file and environment representation of
+// IncogniaClientConfig. Durations are strings such as "2s" or "500ms", and
+// the *_file fields point to files holding the value, so secrets can be
+// mounted instead of set inline.
+type fileConfig struct {
+	ClientID               string          `json:"client_id"`
+	ClientIDFile           string          `json:"client_id_file"`
+	ClientSecret           string          `json:"client_secret"`
+	ClientSecretFile       string          `json:"client_secret_file"`
+	CredentialsFile        string          `json:"credentials_file"`
+	CredentialsGracePeriod string          `json:"credentials_grace_period"`
+	BaseURL                string          `json:"base_url"`
+	Timeout                string          `json:"timeout"`
+	TokenRouteTimeout      string          `json:"token_route_timeout"`
+	Retry                  retryConfig     `json:"retry"`
+	RateLimit              rateLimitConfig `json:"rate_limit"`
+	Log                    logConfig       `json:"log"`
+	Deduplication          dedupConfig     `json:"deduplication"`
+	DryRun                 string          `json:"dry_run"`
+	Stats                  statsConfig     `json:"stats"`
+	TokenExpiryMargin      string          `json:"token_expiry_margin"`
+	Compression            compressConfig  `json:"compression"`
+	MaxResponseSize        string          `json:"max_response_size"`
+	Codec                  codecConfig     `json:"codec"`
+}
+
+type retryConfig struct {
+	MaxRetries string `json:"max_retries"`
+	Backoff    string `json:"backoff"`
+}
+
+type rateLimitConfig struct {
+	RequestsPerSecond string `json:"requests_per_second"`
+	Burst             string `json:"burst"`
+}
+
+type logConfig struct {
+	Level string `json:"level"`
+}
+
+type dedupConfig struct {
+	Enabled string `json:"enabled"`
+	TTL     string `json:"ttl"`
+}
+
+type statsConfig struct {
+	Window        string `json:"window"`
+	LatencyHeader string `json:"latency_header"`
+}
+
+type compressConfig struct {
+	Enabled   string `json:"enabled"`
+	Threshold string `json:"threshold"`
+}
+
+type codecConfig struct {
+	UseNumber string `json:"use_number"`
+}
+
+// NewFromEnv creates a client from INCOGNIA_* environment variables.
+func NewFromEnv() (*Client, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(config)
+}
+
+// ConfigFromEnv reads INCOGNIA_CLIENT_ID, INCOGNIA_CLIENT_SECRET (or their
+// _FILE variants), INCOGNIA_CREDENTIALS_FILE, INCOGNIA_CREDENTIALS_GRACE_PERIOD,
+// INCOGNIA_BASE_URL, INCOGNIA_TIMEOUT, INCOGNIA_TOKEN_ROUTE_TIMEOUT,
+// INCOGNIA_MAX_RETRIES, INCOGNIA_RETRY_BACKOFF, INCOGNIA_RATE_LIMIT,
+// INCOGNIA_RATE_LIMIT_BURST, INCOGNIA_LOG_LEVEL,
+// INCOGNIA_DEDUPLICATE_REQUESTS, INCOGNIA_DEDUPLICATION_TTL,
+// INCOGNIA_DRY_RUN, INCOGNIA_STATS_WINDOW, INCOGNIA_LATENCY_HEADER,
+// INCOGNIA_TOKEN_EXPIRY_MARGIN, INCOGNIA_COMPRESS_REQUESTS,
+// INCOGNIA_COMPRESSION_THRESHOLD, INCOGNIA_MAX_RESPONSE_SIZE and
+// INCOGNIA_USE_NUMBER.
+func ConfigFromEnv() (*IncogniaClientConfig, error) {
+	env := func(name string) string {
+		return os.Getenv(envPrefix + name)
+	}
+
+	return fileConfig{
+		ClientID:               env("CLIENT_ID"),
+		ClientIDFile:           env("CLIENT_ID_FILE"),
+		ClientSecret:           env("CLIENT_SECRET"),
+		ClientSecretFile:       env("CLIENT_SECRET_FILE"),
+		CredentialsFile:        env("CREDENTIALS_FILE"),
+		CredentialsGracePeriod: env("CREDENTIALS_GRACE_PERIOD"),
+		BaseURL:                env("BASE_URL"),
+		Timeout:                env("TIMEOUT"),
+		TokenRouteTimeout:      env("TOKEN_ROUTE_TIMEOUT"),
+		Retry: retryConfig{
+			MaxRetries: env("MAX_RETRIES"),
+			Backoff:    env("RETRY_BACKOFF"),
+		},
+		RateLimit: rateLimitConfig{
+			RequestsPerSecond: env("RATE_LIMIT"),
+			Burst:             env("RATE_LIMIT_BURST"),
+		},
+		Log: logConfig{Level: env("LOG_LEVEL")},
+		Deduplication: dedupConfig{
+			Enabled: env("DEDUPLICATE_REQUESTS"),
+			TTL:     env("DEDUPLICATION_TTL"),
+		},
+		DryRun: env("DRY_RUN"),
+		Stats: statsConfig{
+			Window:        env("STATS_WINDOW"),
+			LatencyHeader: env("LATENCY_HEADER"),
+		},
+		TokenExpiryMargin: env("TOKEN_EXPIRY_MARGIN"),
+		Compression: compressConfig{
+			Enabled:   env("COMPRESS_REQUESTS"),
+			Threshold: env("COMPRESSION_THRESHOLD"),
+		},
+		MaxResponseSize: env("MAX_RESPONSE_SIZE"),
+		Codec:           codecConfig{UseNumber: env("USE_NUMBER")},
+	}.toClientConfig(envPrefix, &ConfigError{})
+}
+
+// LoadConfig reads a YAML or JSON file, picking the format from its
+// extension. Keys mirror the INCOGNIA_* variables in snake case, with retry,
+// rate_limit, log, deduplication, stats, compression and codec settings
+// nested. Unknown keys are reported along with
+// the other problems.
+func LoadConfig(path string) (*IncogniaClientConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers and booleans are read as strings so both formats share the
+	// parsing and validation below.
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, &ConfigError{Problems: []string{fmt.Sprintf("%s: unknown config file extension", path)}}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	normalized, err := json.Marshal(stringifyValues(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var config fileConfig
+	if err := json.Unmarshal(normalized, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	problems := &ConfigError{}
+	for _, key := range unknownKeys(raw, reflect.TypeOf(config), "") {
+		problems.add("%s: unknown key", key)
+	}
+
+	return config.toClientConfig("", problems)
+}
+
+// unknownKeys lists the keys of raw, sorted, that no json tag of the struct
+// type t matches, descending into nested settings.
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fields[strings.Split(field.Tag.Get("json"), ",")[0]] = field.Type
+	}
+
+	var unknown []string
+	for key, value := range raw {
+		fieldType, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && fieldType.Kind() == reflect.Struct {
+			unknown = append(unknown, unknownKeys(nested, fieldType, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+func stringifyValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = stringifyValues(item)
+		}
+		return out
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toClientConfig parses and validates every field. Problems name the
+// environment variable when prefix is set, and the file key otherwise.
+func (f fileConfig) toClientConfig(prefix string, problems *ConfigError) (*IncogniaClientConfig, error) {
+	name := func(fileKey, envKey string) string {
+		if prefix == "" {
+			return fileKey
+		}
+		return prefix + envKey
+	}
+
+	config := &IncogniaClientConfig{}
+
+	config.ClientID = f.secret(problems, name("client_id", "CLIENT_ID"), f.ClientID, f.ClientIDFile)
+	config.ClientSecret = f.secret(problems, name("client_secret", "CLIENT_SECRET"), f.ClientSecret, f.ClientSecretFile)
+
+	if f.CredentialsFile != "" {
+		provider, err := NewFileCredentialsProvider(f.CredentialsFile, 0)
+		if err != nil {
+			problems.add("%s: %v", name("credentials_file", "CREDENTIALS_FILE"), err)
+		} else {
+			config.CredentialsProvider = provider
+		}
+	} else if config.ClientID == "" || config.ClientSecret == "" {
+		problems.add("%s and %s are required", name("client_id", "CLIENT_ID"), name("client_secret", "CLIENT_SECRET"))
+	}
+
+	config.CredentialsGracePeriod = parseDuration(problems, name("credentials_grace_period", "CREDENTIALS_GRACE_PERIOD"), f.CredentialsGracePeriod)
+	config.Timeout = parseDuration(problems, name("timeout", "TIMEOUT"), f.Timeout)
+	config.TokenRouteTimeout = parseDuration(problems, name("token_route_timeout", "TOKEN_ROUTE_TIMEOUT"), f.TokenRouteTimeout)
+
+	if f.BaseURL != "" {
+		baseURL, err := url.Parse(f.BaseURL)
+		if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+			problems.add("%s: %q is not an http or https URL", name("base_url", "BASE_URL"), f.BaseURL)
+		} else {
+			config.BaseURL = f.BaseURL
+		}
+	}
+
+	config.MaxRetries = parseNonNegativeInt(problems, name("retry.max_retries", "MAX_RETRIES"), f.Retry.MaxRetries)
+	config.RetryBackoff = parseDuration(problems, name("retry.backoff", "RETRY_BACKOFF"), f.Retry.Backoff)
+
+	if f.RateLimit.RequestsPerSecond != "" {
+		rate, err := strconv.ParseFloat(f.RateLimit.RequestsPerSecond, 64)
+		if err != nil || rate < 0 {
+			problems.add("%s: %q is not a non-negative number", name("rate_limit.requests_per_second", "RATE_LIMIT"), f.RateLimit.RequestsPerSecond)
+		}
+		config.RateLimit = rate
+	}
+	config.RateLimitBurst = parseNonNegativeInt(problems, name("rate_limit.burst", "RATE_LIMIT_BURST"), f.RateLimit.Burst)
+
+	config.LogLevel = LogLevel(strings.ToLower(f.Log.Level))
+	if !config.LogLevel.valid() {
+		problems.add("%s: %q is not one of off, error or debug", name("log.level", "LOG_LEVEL"), f.Log.Level)
+	}
+
+	config.DeduplicateRequests = parseBool(problems, name("deduplication.enabled", "DEDUPLICATE_REQUESTS"), f.Deduplication.Enabled)
+	config.DeduplicationTTL = parseDuration(problems, name("deduplication.ttl", "DEDUPLICATION_TTL"), f.Deduplication.TTL)
+	config.DryRun = parseBool(problems, name("dry_run", "DRY_RUN"), f.DryRun)
+	config.StatsWindow = parseDuration(problems, name("stats.window", "STATS_WINDOW"), f.Stats.Window)
+
+	config.LatencyHeader = LatencyHeaderMode(strings.ToLower(f.Stats.LatencyHeader))
+	if !config.LatencyHeader.valid() {
+		problems.add("%s: %q is not one of last, aggregate or off", name("stats.latency_header", "LATENCY_HEADER"), f.Stats.LatencyHeader)
+	}
+
+	config.TokenExpiryMargin = parseDuration(problems, name("token_expiry_margin", "TOKEN_EXPIRY_MARGIN"), f.TokenExpiryMargin)
+	config.CompressRequests = parseBool(problems, name("compression.enabled", "COMPRESS_REQUESTS"), f.Compression.Enabled)
+	config.CompressionThreshold = parseNonNegativeInt(problems, name("compression.threshold", "COMPRESSION_THRESHOLD"), f.Compression.Threshold)
+	config.MaxResponseSize = int64(parseNonNegativeInt(problems, name("max_response_size", "MAX_RESPONSE_SIZE"), f.MaxResponseSize))
+
+	if parseBool(problems, name("codec.use_number", "USE_NUMBER"), f.Codec.UseNumber) {
+		config.Codec = StandardCodec{UseNumber: true}
+	}
+
+	if err := problems.errOrNil(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (f fileConfig) secret(problems *ConfigError, name, value, path string) string {
+	if path == "" {
+		return value
+	}
+
+	if value != "" {
+		problems.add("%s: set either the value or the file, not both", name)
+		return ""
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		problems.add("%s: %v", name, err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func parseDuration(problems *ConfigError, name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		problems.add("%s: %q is not a non-negative duration", name, value)
+		return 0
+	}
+
+	return duration
+}
+
+func parseBool(problems *ConfigError, name, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		problems.add("%s: %q is not a boolean", name, value)
+		return false
+	}
+
+	return b
+}
+
+func parseNonNegativeInt(problems *ConfigError, name, value string) int {
+	if value == "" {
+		return 0
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		problems.add("%s: %q is not a non-negative integer", name, value)
+		return 0
+	}
+
+	return n
+}
@@ -0,0 +1,288 @@
+package incognia
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+var configEnvVars = []string{
+	"CLIENT_ID", "CLIENT_ID_FILE", "CLIENT_SECRET", "CLIENT_SECRET_FILE", "CREDENTIALS_FILE",
+	"CREDENTIALS_GRACE_PERIOD", "BASE_URL", "TIMEOUT", "TOKEN_ROUTE_TIMEOUT", "MAX_RETRIES",
+	"RETRY_BACKOFF", "RATE_LIMIT", "RATE_LIMIT_BURST", "LOG_LEVEL", "DEDUPLICATE_REQUESTS",
+	"DEDUPLICATION_TTL", "DRY_RUN", "STATS_WINDOW", "LATENCY_HEADER", "TOKEN_EXPIRY_MARGIN",
+	"COMPRESS_REQUESTS", "COMPRESSION_THRESHOLD", "MAX_RESPONSE_SIZE", "USE_NUMBER",
+}
+
+// configFileKeys maps the fields of IncogniaClientConfig to the file key
+// setting them. Fields that cannot be written in a file map to "".
+var configFileKeys = map[string]string{
+	"ClientID":               "client_id",
+	"ClientSecret":           "client_secret",
+	"CredentialsProvider":    "credentials_file",
+	"CredentialsGracePeriod": "credentials_grace_period",
+	"TokenProvider":          "",
+	"Timeout":                "timeout",
+	"TokenRouteTimeout":      "token_route_timeout",
+	"HTTPClient":             "",
+	"BaseURL":                "base_url",
+	"MaxRetries":             "retry.max_retries",
+	"RetryBackoff":           "retry.backoff",
+	"RateLimit":              "rate_limit.requests_per_second",
+	"RateLimitBurst":         "rate_limit.burst",
+	"Logger":                 "",
+	"LogLevel":               "log.level",
+	"AuditSink":              "",
+	"DeduplicateRequests":    "deduplication.enabled",
+	"DeduplicationTTL":       "deduplication.ttl",
+	"DryRun":                 "dry_run",
+	"OnDryRun":               "",
+	"OnPanic":                "",
+	"StatsWindow":            "stats.window",
+	"LatencyHeader":          "stats.latency_header",
+	"Clock":                  "",
+	"TokenExpiryMargin":      "token_expiry_margin",
+	"CompressRequests":       "compression.enabled",
+	"CompressionThreshold":   "compression.threshold",
+	"MaxResponseSize":        "max_response_size",
+	"Codec":                  "codec.use_number",
+}
+
+type ConfigTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (suite *ConfigTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "config")
+	suite.Require().NoError(err)
+	suite.dir = dir
+	suite.clearEnv()
+}
+
+func (suite *ConfigTestSuite) TearDownTest() {
+	suite.clearEnv()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *ConfigTestSuite) clearEnv() {
+	for _, name := range configEnvVars {
+		os.Unsetenv(envPrefix + name)
+	}
+}
+
+func (suite *ConfigTestSuite) writeFile(name, content string) string {
+	path := filepath.Join(suite.dir, name)
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func (suite *ConfigTestSuite) TestConfigFromEnv() {
+	os.Setenv("INCOGNIA_CLIENT_ID", "env-id")
+	os.Setenv("INCOGNIA_CLIENT_SECRET_FILE", suite.writeFile("secret", "file-secret\n"))
+	os.Setenv("INCOGNIA_BASE_URL", "http://localhost:8080/api")
+	os.Setenv("INCOGNIA_TIMEOUT", "2s")
+	os.Setenv("INCOGNIA_TOKEN_ROUTE_TIMEOUT", "10s")
+	os.Setenv("INCOGNIA_MAX_RETRIES", "3")
+	os.Setenv("INCOGNIA_RETRY_BACKOFF", "50ms")
+	os.Setenv("INCOGNIA_RATE_LIMIT", "12.5")
+	os.Setenv("INCOGNIA_RATE_LIMIT_BURST", "5")
+	os.Setenv("INCOGNIA_LOG_LEVEL", "DEBUG")
+	os.Setenv("INCOGNIA_DEDUPLICATE_REQUESTS", "true")
+	os.Setenv("INCOGNIA_DEDUPLICATION_TTL", "2s")
+	os.Setenv("INCOGNIA_DRY_RUN", "1")
+	os.Setenv("INCOGNIA_STATS_WINDOW", "1m")
+	os.Setenv("INCOGNIA_LATENCY_HEADER", "aggregate")
+	os.Setenv("INCOGNIA_TOKEN_EXPIRY_MARGIN", "30s")
+	os.Setenv("INCOGNIA_COMPRESS_REQUESTS", "true")
+	os.Setenv("INCOGNIA_COMPRESSION_THRESHOLD", "512")
+	os.Setenv("INCOGNIA_MAX_RESPONSE_SIZE", "65536")
+	os.Setenv("INCOGNIA_USE_NUMBER", "true")
+
+	config, err := ConfigFromEnv()
+	suite.Require().NoError(err)
+	suite.Equal(&IncogniaClientConfig{
+		ClientID:             "env-id",
+		ClientSecret:         "file-secret",
+		BaseURL:              "http://localhost:8080/api",
+		Timeout:              2 * time.Second,
+		TokenRouteTimeout:    10 * time.Second,
+		MaxRetries:           3,
+		RetryBackoff:         50 * time.Millisecond,
+		RateLimit:            12.5,
+		RateLimitBurst:       5,
+		LogLevel:             LogLevelDebug,
+		DeduplicateRequests:  true,
+		DeduplicationTTL:     2 * time.Second,
+		DryRun:               true,
+		StatsWindow:          time.Minute,
+		LatencyHeader:        LatencyHeaderAggregate,
+		TokenExpiryMargin:    30 * time.Second,
+		CompressRequests:     true,
+		CompressionThreshold: 512,
+		MaxResponseSize:      65536,
+		Codec:                StandardCodec{UseNumber: true},
+	}, config)
+
+	client, err := NewFromEnv()
+	suite.NoError(err)
+	suite.Equal("http://localhost:8080/api/v2/authentication/transactions", client.endpoints.Transactions)
+}
+
+func (suite *ConfigTestSuite) TestConfigFromEnvListsEveryProblem() {
+	os.Setenv("INCOGNIA_TIMEOUT", "soon")
+	os.Setenv("INCOGNIA_MAX_RETRIES", "-1")
+	os.Setenv("INCOGNIA_BASE_URL", "localhost")
+	os.Setenv("INCOGNIA_LOG_LEVEL", "verbose")
+	os.Setenv("INCOGNIA_DRY_RUN", "maybe")
+	os.Setenv("INCOGNIA_LATENCY_HEADER", "median")
+
+	_, err := NewFromEnv()
+
+	var configErr *ConfigError
+	suite.Require().True(errors.As(err, &configErr))
+	suite.Equal([]string{
+		"INCOGNIA_CLIENT_ID and INCOGNIA_CLIENT_SECRET are required",
+		`INCOGNIA_TIMEOUT: "soon" is not a non-negative duration`,
+		`INCOGNIA_BASE_URL: "localhost" is not an http or https URL`,
+		`INCOGNIA_MAX_RETRIES: "-1" is not a non-negative integer`,
+		`INCOGNIA_LOG_LEVEL: "verbose" is not one of off, error or debug`,
+		`INCOGNIA_DRY_RUN: "maybe" is not a boolean`,
+		`INCOGNIA_LATENCY_HEADER: "median" is not one of last, aggregate or off`,
+	}, configErr.Problems)
+}
+
+func (suite *ConfigTestSuite) TestLoadYAMLConfig() {
+	secretPath := suite.writeFile("secret", "yaml-secret")
+	path := suite.writeFile("incognia.yaml", `
+client_id: yaml-id
+client_secret_file: `+secretPath+`
+timeout: 1500ms
+retry:
+  max_retries: 2
+  backoff: 1s
+rate_limit:
+  requests_per_second: 100
+  burst: 20
+log:
+  level: error
+deduplication:
+  enabled: true
+  ttl: 1s
+dry_run: false
+stats:
+  window: 10m
+  latency_header: "off"
+token_expiry_margin: 5s
+compression:
+  enabled: true
+  threshold: 2048
+max_response_size: 4096
+codec:
+  use_number: true
+`)
+
+	config, err := LoadConfig(path)
+	suite.Require().NoError(err)
+	suite.Equal("yaml-id", config.ClientID)
+	suite.Equal("yaml-secret", config.ClientSecret)
+	suite.Equal(1500*time.Millisecond, config.Timeout)
+	suite.Equal(2, config.MaxRetries)
+	suite.Equal(time.Second, config.RetryBackoff)
+	suite.Equal(100.0, config.RateLimit)
+	suite.Equal(20, config.RateLimitBurst)
+	suite.Equal(LogLevelError, config.LogLevel)
+	suite.True(config.DeduplicateRequests)
+	suite.Equal(time.Second, config.DeduplicationTTL)
+	suite.False(config.DryRun)
+	suite.Equal(10*time.Minute, config.StatsWindow)
+	suite.Equal(LatencyHeaderOff, config.LatencyHeader)
+	suite.Equal(5*time.Second, config.TokenExpiryMargin)
+	suite.True(config.CompressRequests)
+	suite.Equal(2048, config.CompressionThreshold)
+	suite.Equal(int64(4096), config.MaxResponseSize)
+	suite.Equal(StandardCodec{UseNumber: true}, config.Codec)
+}
+
+func (suite *ConfigTestSuite) TestEveryConfigFieldHasAFileKey() {
+	configType := reflect.TypeOf(IncogniaClientConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key, ok := configFileKeys[field.Name]
+		if !suite.True(ok, "%s has no file key, add it to fileConfig and ConfigFromEnv", field.Name) || key == "" {
+			continue
+		}
+
+		raw := map[string]interface{}{}
+		parts := strings.Split(key, ".")
+		if len(parts) == 2 {
+			raw[parts[0]] = map[string]interface{}{parts[1]: ""}
+		} else {
+			raw[key] = ""
+		}
+		suite.Empty(unknownKeys(raw, reflect.TypeOf(fileConfig{}), ""), "%s: file key %s", field.Name, key)
+	}
+}
+
+func (suite *ConfigTestSuite) TestLoadJSONConfigWithCredentialsFile() {
+	credentialsPath := suite.writeFile("credentials.json", `{"client_id": "id", "client_secret": "secret"}`)
+	path := suite.writeFile("incognia.json", `{
+		"credentials_file": "`+credentialsPath+`",
+		"credentials_grace_period": "1h",
+		"retry": {"max_retries": 1}
+	}`)
+
+	config, err := LoadConfig(path)
+	suite.Require().NoError(err)
+	suite.NotNil(config.CredentialsProvider)
+	suite.Equal(time.Hour, config.CredentialsGracePeriod)
+	suite.Equal(1, config.MaxRetries)
+
+	_, err = New(config)
+	suite.NoError(err)
+}
+
+func (suite *ConfigTestSuite) TestLoadConfigErrors() {
+	path := suite.writeFile("incognia.yml", `
+client_id: id
+client_secret: secret
+client_secret_file: /does/not/matter
+max_retry: 3
+retry:
+  max_retires: 3
+rate_limit:
+  requests_per_second: fast
+  burst: many
+`)
+
+	_, err := LoadConfig(path)
+
+	var configErr *ConfigError
+	suite.Require().True(errors.As(err, &configErr))
+	suite.Equal([]string{
+		"max_retry: unknown key",
+		"retry.max_retires: unknown key",
+		"client_secret: set either the value or the file, not both",
+		"client_id and client_secret are required",
+		`rate_limit.requests_per_second: "fast" is not a non-negative number`,
+		`rate_limit.burst: "many" is not a non-negative integer`,
+	}, configErr.Problems)
+
+	_, err = LoadConfig(suite.writeFile("incognia.toml", ""))
+	suite.True(errors.As(err, &configErr))
+
+	_, err = LoadConfig(filepath.Join(suite.dir, "missing.yaml"))
+	suite.True(os.IsNotExist(err))
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
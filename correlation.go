@@ -0,0 +1,104 @@
+package incognia
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+const (
+	// The header names are in canonical form, which saves converting them on
+	// every request.
+	correlationIDHeader = "X-Correlation-Id"
+	requestIDHeader     = "X-Request-Id"
+)
+
+type correlationIDKey struct{}
+
+type callInfoKey struct{}
+
+// WithCorrelationID returns a context whose calls send id in the
+// X-Correlation-ID header. Calls without one send a generated ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID set on ctx by WithCorrelationID.
+func CorrelationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// callInfo follows a call through retries and deduplication, from
+// the correlation ID sent to the request ID the API answers with.
+type callInfo struct {
+	correlationID string
+
+	mutex     sync.Mutex
+	requestID string
+}
+
+func withCallInfo(ctx context.Context) (context.Context, *callInfo) {
+	id, ok := CorrelationID(ctx)
+	if !ok {
+		id = newCorrelationID()
+	}
+
+	info := &callInfo{correlationID: id}
+	return context.WithValue(ctx, callInfoKey{}, info), info
+}
+
+// callInfoFrom returns the callInfo of ctx, or an empty one for requests
+// made outside of a call.
+func callInfoFrom(ctx context.Context) *callInfo {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		return info
+	}
+
+	return &callInfo{}
+}
+
+// setRequestID keeps the first request ID the call gets.
+func (i *callInfo) setRequestID(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.requestID == "" {
+		i.requestID = id
+	}
+}
+
+func (i *callInfo) getRequestID() string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.requestID
+}
+
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
+
+type requestIDSetter interface {
+	setRequestID(id string)
+}
+
+// setRequestID fills RequestID from the response header unless the body
+// had one.
+func (a *SignupAssessment) setRequestID(id string) {
+	if a.RequestID == "" {
+		a.RequestID = id
+	}
+}
+
+func (a *TransactionAssessment) setRequestID(id string) {
+	if a.RequestID == "" {
+		a.RequestID = id
+	}
+}
@@ -0,0 +1,165 @@
+package incognia
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CorrelationTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	server      *httptest.Server
+
+	mutex          sync.Mutex
+	correlationIDs []string
+	failures       int
+	body           string
+}
+
+func (suite *CorrelationTestSuite) SetupTest() {
+	suite.correlationIDs = nil
+	suite.failures = 0
+	suite.body = `{"id":"id","risk_assessment":"low_risk"}`
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mutex.Lock()
+		suite.correlationIDs = append(suite.correlationIDs, r.Header.Get(correlationIDHeader))
+		fail := suite.failures > 0
+		suite.failures--
+		body := suite.body
+		suite.mutex.Unlock()
+
+		w.Header().Set(requestIDHeader, "request-"+r.Header.Get(correlationIDHeader))
+		if fail {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func (suite *CorrelationTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *CorrelationTestSuite) newClient(config *IncogniaClientConfig) *Client {
+	config.ClientID = clientID
+	config.ClientSecret = clientSecret
+	config.LogLevel = LogLevelOff
+	client, err := New(config)
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Signups = suite.server.URL
+	client.endpoints.Transactions = suite.server.URL
+	return client
+}
+
+func (suite *CorrelationTestSuite) TestCorrelationIDFromContext() {
+	client := suite.newClient(&IncogniaClientConfig{})
+
+	ctx := WithCorrelationID(context.Background(), "my-id")
+	assessment, err := client.RegisterLoginWithContext(ctx, &Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"my-id"}, suite.correlationIDs)
+	suite.Equal("request-my-id", assessment.RequestID)
+
+	id, ok := CorrelationID(ctx)
+	suite.True(ok)
+	suite.Equal("my-id", id)
+	_, ok = CorrelationID(context.Background())
+	suite.False(ok)
+}
+
+func (suite *CorrelationTestSuite) TestGeneratedCorrelationIDIsKeptAcrossRetries() {
+	suite.failures = 1
+	client := suite.newClient(&IncogniaClientConfig{MaxRetries: 1, RetryBackoff: time.Millisecond})
+
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	_, err = client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.correlationIDs, 3)
+	suite.Regexp(regexp.MustCompile(`^[0-9a-f]{32}$`), suite.correlationIDs[0])
+	suite.Equal(suite.correlationIDs[0], suite.correlationIDs[1])
+	suite.NotEqual(suite.correlationIDs[0], suite.correlationIDs[2])
+}
+
+func (suite *CorrelationTestSuite) TestRequestID() {
+	client := suite.newClient(&IncogniaClientConfig{})
+	ctx := WithCorrelationID(context.Background(), "my-id")
+
+	signup, err := client.RegisterSignupWithContext(ctx, &Signup{InstallationID: "installation-id"})
+	suite.Require().NoError(err)
+	suite.Equal("request-my-id", signup.RequestID)
+
+	suite.body = `{"id":"id","request_id":"body-id","risk_assessment":"low_risk"}`
+	signup, err = client.RegisterSignupWithContext(ctx, &Signup{InstallationID: "installation-id"})
+	suite.Require().NoError(err)
+	suite.Equal("body-id", signup.RequestID, "the request ID of the body is kept")
+
+	suite.failures = 1
+	_, err = client.RegisterPaymentWithContext(ctx, &Payment{AccountID: "account-id"})
+	var apiErr *APIError
+	suite.Require().True(errors.As(err, &apiErr))
+	suite.Equal("request-my-id", apiErr.RequestID)
+}
+
+func (suite *CorrelationTestSuite) TestDeduplicatedCallsShareRequestID() {
+	client := suite.newClient(&IncogniaClientConfig{DeduplicateRequests: true, DeduplicationTTL: time.Minute})
+
+	first, err := client.RegisterLoginWithContext(WithCorrelationID(context.Background(), "first"), &Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	second, err := client.RegisterLoginWithContext(WithCorrelationID(context.Background(), "second"), &Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+
+	suite.Equal([]string{"first"}, suite.correlationIDs)
+	suite.Equal("request-first", first.RequestID)
+	suite.Equal("request-first", second.RequestID)
+}
+
+func (suite *CorrelationTestSuite) TestAuditRecords() {
+	sink := &recordingAuditSink{}
+	async := NewAsyncAuditSink(sink, 10)
+	client := suite.newClient(&IncogniaClientConfig{AuditSink: async})
+	ctx := WithCorrelationID(context.Background(), "my-id")
+
+	_, err := client.RegisterLoginWithContext(ctx, &Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	suite.failures = 1
+	_, err = client.RegisterLoginWithContext(ctx, &Login{AccountID: "account-id"})
+	suite.Require().Error(err)
+	suite.Require().NoError(async.Close())
+
+	records := sink.recorded()
+	suite.Require().Len(records, 2)
+	for _, record := range records {
+		suite.Equal("my-id", record.CorrelationID)
+		suite.Equal("request-my-id", record.RequestID)
+	}
+}
+
+func (suite *CorrelationTestSuite) TestDryRunHeader() {
+	client := suite.newClient(&IncogniaClientConfig{})
+
+	var result DryRunResult
+	ctx := WithDryRun(WithCorrelationID(context.Background(), "my-id"), &result)
+	_, err := client.RegisterLoginWithContext(ctx, &Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	suite.Equal("my-id", result.Header.Get(correlationIDHeader))
+}
+
+func TestCorrelationTestSuite(t *testing.T) {
+	suite.Run(t, new(CorrelationTestSuite))
+}
@@ -0,0 +1,167 @@
+package incognia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClientIDEnvVar     = "INCOGNIA_CLIENT_ID"
+	defaultClientSecretEnvVar = "INCOGNIA_CLIENT_SECRET"
+	defaultFilePollInterval   = 10 * time.Second
+)
+
+var (
+	ErrMissingCredentialsFile = errors.New("missing credentials file path")
+)
+
+type Credentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func (c Credentials) valid() bool {
+	return c.ClientID != "" && c.ClientSecret != ""
+}
+
+// CredentialsProvider is called by TokenClient on every token refresh, so
+// rotated credentials are picked up without rebuilding the client.
+type CredentialsProvider interface {
+	GetCredentials() (Credentials, error)
+}
+
+type StaticCredentialsProvider struct {
+	credentials Credentials
+}
+
+func NewStaticCredentialsProvider(clientID, clientSecret string) *StaticCredentialsProvider {
+	return &StaticCredentialsProvider{credentials: Credentials{ClientID: clientID, ClientSecret: clientSecret}}
+}
+
+func (p *StaticCredentialsProvider) GetCredentials() (Credentials, error) {
+	if !p.credentials.valid() {
+		return Credentials{}, ErrMissingClientIDOrClientSecret
+	}
+
+	return p.credentials, nil
+}
+
+type EnvCredentialsProvider struct {
+	clientIDVar     string
+	clientSecretVar string
+}
+
+// NewEnvCredentialsProvider reads credentials from the given environment
+// variables, defaulting to INCOGNIA_CLIENT_ID and INCOGNIA_CLIENT_SECRET.
+func NewEnvCredentialsProvider(clientIDVar, clientSecretVar string) *EnvCredentialsProvider {
+	if clientIDVar == "" {
+		clientIDVar = defaultClientIDEnvVar
+	}
+	if clientSecretVar == "" {
+		clientSecretVar = defaultClientSecretEnvVar
+	}
+
+	return &EnvCredentialsProvider{clientIDVar: clientIDVar, clientSecretVar: clientSecretVar}
+}
+
+func (p *EnvCredentialsProvider) GetCredentials() (Credentials, error) {
+	credentials := Credentials{
+		ClientID:     os.Getenv(p.clientIDVar),
+		ClientSecret: os.Getenv(p.clientSecretVar),
+	}
+
+	if !credentials.valid() {
+		return Credentials{}, fmt.Errorf("%s, %s: %w", p.clientIDVar, p.clientSecretVar, ErrMissingClientIDOrClientSecret)
+	}
+
+	return credentials, nil
+}
+
+// FileCredentialsProvider reads credentials from a JSON file with client_id
+// and client_secret keys, and reloads it when its modification time changes.
+// Once loaded, the credentials are kept while the file is missing, unreadable
+// or invalid, for instance while it is being rewritten, and the file is
+// tried again on the next check.
+type FileCredentialsProvider struct {
+	path         string
+	pollInterval time.Duration
+
+	mutex       sync.Mutex
+	credentials Credentials
+	modTime     time.Time
+	checkedAt   time.Time
+}
+
+// NewFileCredentialsProvider loads the file right away, so a missing or
+// invalid file is reported at startup. The file is checked for changes at
+// most once per pollInterval.
+func NewFileCredentialsProvider(path string, pollInterval time.Duration) (*FileCredentialsProvider, error) {
+	if path == "" {
+		return nil, ErrMissingCredentialsFile
+	}
+
+	if pollInterval == 0 {
+		pollInterval = defaultFilePollInterval
+	}
+
+	p := &FileCredentialsProvider{path: path, pollInterval: pollInterval}
+	if _, err := p.GetCredentials(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *FileCredentialsProvider) GetCredentials() (Credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if p.credentials.valid() && now.Sub(p.checkedAt) < p.pollInterval {
+		return p.credentials, nil
+	}
+	p.checkedAt = now
+
+	if err := p.reload(); err != nil && !p.credentials.valid() {
+		return Credentials{}, err
+	}
+
+	return p.credentials, nil
+}
+
+// reload reads the file when its modification time changed, and keeps the
+// current credentials when it fails.
+func (p *FileCredentialsProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	if p.credentials.valid() && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var credentials Credentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	if !credentials.valid() {
+		return fmt.Errorf("%s: %w", p.path, ErrMissingClientIDOrClientSecret)
+	}
+
+	p.credentials = credentials
+	p.modTime = info.ModTime()
+
+	return nil
+}
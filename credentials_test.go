@@ -0,0 +1,254 @@
+package incognia
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CredentialsTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	mutex       sync.Mutex
+	accepted    map[Credentials]bool
+	dir         string
+}
+
+func (suite *CredentialsTestSuite) SetupTest() {
+	suite.accepted = map[Credentials]bool{}
+	suite.tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+
+		suite.mutex.Lock()
+		ok := suite.accepted[Credentials{ClientID: username, ClientSecret: password}]
+		suite.mutex.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		res, _ := json.Marshal(map[string]string{
+			"access_token": username + ":" + password,
+			"expires_in":   tokenExpiresIn,
+			"token_type":   "Bearer",
+		})
+		w.Write(res)
+	}))
+
+	dir, err := ioutil.TempDir("", "credentials")
+	suite.Require().NoError(err)
+	suite.dir = dir
+}
+
+func (suite *CredentialsTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *CredentialsTestSuite) accept(credentials ...Credentials) {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+
+	suite.accepted = map[Credentials]bool{}
+	for _, c := range credentials {
+		suite.accepted[c] = true
+	}
+}
+
+func (suite *CredentialsTestSuite) tokenClient(provider CredentialsProvider, gracePeriod time.Duration) *TokenClient {
+	tokenClient := NewTokenClient(&TokenClientConfig{CredentialsProvider: provider, CredentialsGracePeriod: gracePeriod})
+	tokenClient.tokenEndpoint = suite.tokenServer.URL
+	return tokenClient
+}
+
+func (suite *CredentialsTestSuite) TestProviderIsCalledOnEveryRefresh() {
+	provider := &rotatingCredentialsProvider{credentials: Credentials{ClientID: "id", ClientSecret: "old"}}
+	suite.accept(Credentials{ClientID: "id", ClientSecret: "old"}, Credentials{ClientID: "id", ClientSecret: "new"})
+	tokenClient := suite.tokenClient(provider, 0)
+
+	token, err := tokenClient.requestToken()
+	suite.NoError(err)
+	suite.Equal("id:old", token.(*accessToken).AccessToken)
+
+	provider.set(Credentials{ClientID: "id", ClientSecret: "new"})
+	token, err = tokenClient.requestToken()
+	suite.NoError(err)
+	suite.Equal("id:new", token.(*accessToken).AccessToken)
+}
+
+func (suite *CredentialsTestSuite) TestFallbackToPreviousCredentialsWithinGracePeriod() {
+	old := Credentials{ClientID: "id", ClientSecret: "old"}
+	provider := &rotatingCredentialsProvider{credentials: old}
+	suite.accept(old)
+	tokenClient := suite.tokenClient(provider, time.Hour)
+
+	_, err := tokenClient.requestToken()
+	suite.NoError(err)
+
+	provider.set(Credentials{ClientID: "id", ClientSecret: "not-active-yet"})
+	token, err := tokenClient.requestToken()
+	suite.NoError(err)
+	suite.Equal("id:old", token.(*accessToken).AccessToken)
+}
+
+func (suite *CredentialsTestSuite) TestNoFallbackAfterGracePeriod() {
+	old := Credentials{ClientID: "id", ClientSecret: "old"}
+	provider := &rotatingCredentialsProvider{credentials: old}
+	suite.accept(old)
+	tokenClient := suite.tokenClient(provider, time.Hour)
+
+	_, err := tokenClient.requestToken()
+	suite.NoError(err)
+
+	provider.set(Credentials{ClientID: "id", ClientSecret: "not-active-yet"})
+	_, _, _ = tokenClient.credentials()
+	tokenClient.rotatedAt = time.Now().Add(-2 * time.Hour)
+
+	_, err = tokenClient.requestToken()
+	suite.Equal(ErrInvalidCredentials, err)
+}
+
+func (suite *CredentialsTestSuite) TestNoFallbackWithoutGracePeriod() {
+	old := Credentials{ClientID: "id", ClientSecret: "old"}
+	provider := &rotatingCredentialsProvider{credentials: old}
+	suite.accept(old)
+	tokenClient := suite.tokenClient(provider, 0)
+
+	_, err := tokenClient.requestToken()
+	suite.NoError(err)
+
+	provider.set(Credentials{ClientID: "id", ClientSecret: "not-active-yet"})
+	_, err = tokenClient.requestToken()
+	suite.Equal(ErrInvalidCredentials, err)
+}
+
+func (suite *CredentialsTestSuite) TestClientWithCredentialsProvider() {
+	client, err := New(&IncogniaClientConfig{CredentialsProvider: NewStaticCredentialsProvider("id", "secret")})
+	suite.NoError(err)
+	suite.NotNil(client)
+
+	_, err = New(&IncogniaClientConfig{})
+	suite.Equal(ErrMissingClientIDOrClientSecret, err)
+}
+
+func (suite *CredentialsTestSuite) TestStaticCredentialsProvider() {
+	credentials, err := NewStaticCredentialsProvider("id", "secret").GetCredentials()
+	suite.NoError(err)
+	suite.Equal(Credentials{ClientID: "id", ClientSecret: "secret"}, credentials)
+
+	_, err = NewStaticCredentialsProvider("id", "").GetCredentials()
+	suite.Equal(ErrMissingClientIDOrClientSecret, err)
+}
+
+func (suite *CredentialsTestSuite) TestEnvCredentialsProvider() {
+	os.Setenv("TEST_INCOGNIA_CLIENT_ID", "env-id")
+	os.Setenv("TEST_INCOGNIA_CLIENT_SECRET", "env-secret")
+	defer os.Unsetenv("TEST_INCOGNIA_CLIENT_ID")
+	defer os.Unsetenv("TEST_INCOGNIA_CLIENT_SECRET")
+
+	provider := NewEnvCredentialsProvider("TEST_INCOGNIA_CLIENT_ID", "TEST_INCOGNIA_CLIENT_SECRET")
+	credentials, err := provider.GetCredentials()
+	suite.NoError(err)
+	suite.Equal(Credentials{ClientID: "env-id", ClientSecret: "env-secret"}, credentials)
+
+	os.Setenv("TEST_INCOGNIA_CLIENT_SECRET", "rotated-secret")
+	credentials, _ = provider.GetCredentials()
+	suite.Equal("rotated-secret", credentials.ClientSecret)
+
+	os.Unsetenv("TEST_INCOGNIA_CLIENT_ID")
+	_, err = provider.GetCredentials()
+	suite.True(errors.Is(err, ErrMissingClientIDOrClientSecret))
+}
+
+func (suite *CredentialsTestSuite) TestFileCredentialsProviderReloadsOnChange() {
+	path := filepath.Join(suite.dir, "credentials.json")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(`{"client_id": "file-id", "client_secret": "old"}`), 0600))
+
+	provider, err := NewFileCredentialsProvider(path, time.Nanosecond)
+	suite.Require().NoError(err)
+
+	credentials, err := provider.GetCredentials()
+	suite.NoError(err)
+	suite.Equal("old", credentials.ClientSecret)
+
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(`{"client_id": "file-id", "client_secret": "new"}`), 0600))
+	later := time.Now().Add(time.Minute)
+	suite.Require().NoError(os.Chtimes(path, later, later))
+
+	credentials, err = provider.GetCredentials()
+	suite.NoError(err)
+	suite.Equal("new", credentials.ClientSecret)
+}
+
+func (suite *CredentialsTestSuite) TestFileCredentialsProviderKeepsCredentialsOnFailure() {
+	path := filepath.Join(suite.dir, "credentials.json")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(`{"client_id": "file-id", "client_secret": "old"}`), 0600))
+
+	provider, err := NewFileCredentialsProvider(path, time.Nanosecond)
+	suite.Require().NoError(err)
+
+	for i, content := range []string{`{"client_id": "file-`, `{"client_id": "file-id"}`} {
+		suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0600))
+		later := time.Now().Add(time.Duration(i+1) * time.Minute)
+		suite.Require().NoError(os.Chtimes(path, later, later))
+
+		credentials, err := provider.GetCredentials()
+		suite.NoError(err, content)
+		suite.Equal("old", credentials.ClientSecret, content)
+	}
+
+	suite.Require().NoError(os.Remove(path))
+	credentials, err := provider.GetCredentials()
+	suite.NoError(err)
+	suite.Equal("old", credentials.ClientSecret)
+
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(`{"client_id": "file-id", "client_secret": "new"}`), 0600))
+	credentials, err = provider.GetCredentials()
+	suite.NoError(err)
+	suite.Equal("new", credentials.ClientSecret, "the file is tried again once fixed")
+}
+
+func (suite *CredentialsTestSuite) TestFileCredentialsProviderErrors() {
+	_, err := NewFileCredentialsProvider("", 0)
+	suite.Equal(ErrMissingCredentialsFile, err)
+
+	_, err = NewFileCredentialsProvider(filepath.Join(suite.dir, "missing.json"), 0)
+	suite.True(os.IsNotExist(err))
+
+	path := filepath.Join(suite.dir, "incomplete.json")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(`{"client_id": "file-id"}`), 0600))
+	_, err = NewFileCredentialsProvider(path, 0)
+	suite.True(errors.Is(err, ErrMissingClientIDOrClientSecret))
+}
+
+func TestCredentialsTestSuite(t *testing.T) {
+	suite.Run(t, new(CredentialsTestSuite))
+}
+
+type rotatingCredentialsProvider struct {
+	mutex       sync.Mutex
+	credentials Credentials
+}
+
+func (p *rotatingCredentialsProvider) set(credentials Credentials) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.credentials = credentials
+}
+
+func (p *rotatingCredentialsProvider) GetCredentials() (Credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.credentials, nil
+}
@@ -0,0 +1,45 @@
+package decision
+
+type Diff struct {
+	Index     int
+	Baseline  Result
+	Candidate Result
+}
+
+type Comparison struct {
+	Total   int
+	Changed int
+	// Transitions counts inputs by baseline outcome and then candidate outcome.
+	Transitions map[Outcome]map[Outcome]int
+	Diffs       []Diff
+}
+
+// Compare evaluates the same inputs with two engines without acting on the
+// outcomes, reporting every input whose outcome changed.
+func Compare(baseline, candidate *Engine, inputs []Input) *Comparison {
+	comparison := &Comparison{
+		Total:       len(inputs),
+		Transitions: map[Outcome]map[Outcome]int{},
+	}
+
+	for i, input := range inputs {
+		baselineResult := baseline.Evaluate(input)
+		candidateResult := candidate.Evaluate(input)
+
+		if comparison.Transitions[baselineResult.Outcome] == nil {
+			comparison.Transitions[baselineResult.Outcome] = map[Outcome]int{}
+		}
+		comparison.Transitions[baselineResult.Outcome][candidateResult.Outcome]++
+
+		if baselineResult.Outcome != candidateResult.Outcome {
+			comparison.Changed++
+			comparison.Diffs = append(comparison.Diffs, Diff{
+				Index:     i,
+				Baseline:  baselineResult,
+				Candidate: candidateResult,
+			})
+		}
+	}
+
+	return comparison
+}
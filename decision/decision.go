@@ -0,0 +1,525 @@
+// Package decision turns Incognia assessments into local approve, review or
+// decline outcomes using declarative rules.
+package decision
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"repo.incognia.com/go/incognia"
+)
+
+type Outcome string
+
+const (
+	Approve Outcome = "approve"
+	Review  Outcome = "review"
+	Decline Outcome = "decline"
+)
+
+var outcomeSeverity = map[Outcome]int{
+	Approve: 0,
+	Review:  1,
+	Decline: 2,
+}
+
+type Operator string
+
+const (
+	Equal              Operator = "eq"
+	NotEqual           Operator = "ne"
+	GreaterThan        Operator = "gt"
+	GreaterThanOrEqual Operator = "gte"
+	LessThan           Operator = "lt"
+	LessThanOrEqual    Operator = "lte"
+	In                 Operator = "in"
+	NotIn              Operator = "not_in"
+	Contains           Operator = "contains"
+	Exists             Operator = "exists"
+	NotExists          Operator = "not_exists"
+)
+
+var (
+	ErrPolicyIsNil      = errors.New("decision policy is required")
+	ErrInvalidOutcome   = errors.New("invalid outcome")
+	ErrInvalidOperator  = errors.New("invalid operator")
+	ErrInvalidField     = errors.New("invalid field")
+	ErrMissingRuleName  = errors.New("missing rule name")
+	ErrMissingCondition = errors.New("rule has no conditions")
+)
+
+// Policy is a set of rules. Every rule whose conditions all match is
+// reported, and the most severe outcome among them wins. When no rule
+// matches, Default is used, falling back to Approve.
+type Policy struct {
+	Default Outcome `json:"default,omitempty" yaml:"default,omitempty"`
+	Rules   []Rule  `json:"rules" yaml:"rules"`
+}
+
+type Rule struct {
+	Name    string      `json:"name" yaml:"name"`
+	Outcome Outcome     `json:"outcome" yaml:"outcome"`
+	When    []Condition `json:"when" yaml:"when"`
+}
+
+// Condition compares a field against Value. Fields are one of
+// risk_assessment, reasons, reasons.local, reasons.global, actions,
+// evidence.<path>, signals.<path> or request.<attribute>.
+type Condition struct {
+	Field string      `json:"field" yaml:"field"`
+	Op    Operator    `json:"op" yaml:"op"`
+	Value interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+type Request struct {
+	AccountID        string
+	ExternalID       string
+	PolicyID         string
+	StoreID          string
+	Amount           float64
+	Currency         string
+	PaymentMethods   []string
+	CustomProperties map[string]interface{}
+}
+
+type Input struct {
+	RiskAssessment incognia.Assessment
+	Reasons        []incognia.Reason
+	Actions        []incognia.Action
+	Evidence       incognia.Evidence
+	Signals        incognia.Signals
+	Request        Request
+}
+
+func FromPayment(assessment *incognia.TransactionAssessment, payment *incognia.Payment) Input {
+	input := fromTransaction(assessment)
+	if payment == nil {
+		return input
+	}
+
+	input.Request = Request{
+		AccountID:        payment.AccountID,
+		ExternalID:       payment.ExternalID,
+		PolicyID:         payment.PolicyID,
+		StoreID:          payment.StoreID,
+		CustomProperties: payment.CustomProperties,
+	}
+	if payment.Value != nil {
+		input.Request.Amount = payment.Value.Amount
+		input.Request.Currency = payment.Value.Currency
+	}
+	for _, method := range payment.Methods {
+		if method != nil {
+			input.Request.PaymentMethods = append(input.Request.PaymentMethods, string(method.Type))
+		}
+	}
+
+	return input
+}
+
+func FromLogin(assessment *incognia.TransactionAssessment, login *incognia.Login) Input {
+	input := fromTransaction(assessment)
+	if login == nil {
+		return input
+	}
+
+	input.Request = Request{
+		AccountID:        login.AccountID,
+		ExternalID:       login.ExternalID,
+		PolicyID:         login.PolicyID,
+		CustomProperties: login.CustomProperties,
+	}
+
+	return input
+}
+
+func FromSignup(assessment *incognia.SignupAssessment, signup *incognia.Signup) Input {
+	var input Input
+	if assessment != nil {
+		input = Input{
+			RiskAssessment: assessment.RiskAssessment,
+			Reasons:        assessment.Reasons,
+			Actions:        assessment.Actions,
+			Evidence:       assessment.Evidence,
+			Signals:        assessment.Signals,
+		}
+	}
+	if signup == nil {
+		return input
+	}
+
+	input.Request = Request{
+		AccountID:        signup.AccountID,
+		ExternalID:       signup.ExternalID,
+		PolicyID:         signup.PolicyID,
+		CustomProperties: signup.CustomProperties,
+	}
+
+	return input
+}
+
+func fromTransaction(assessment *incognia.TransactionAssessment) Input {
+	if assessment == nil {
+		return Input{}
+	}
+
+	return Input{
+		RiskAssessment: assessment.RiskAssessment,
+		Reasons:        assessment.Reasons,
+		Actions:        assessment.Actions,
+		Evidence:       assessment.Evidence,
+		Signals:        assessment.Signals,
+	}
+}
+
+type Result struct {
+	Outcome Outcome
+	Matched []Rule
+}
+
+func (r Result) MatchedNames() []string {
+	names := make([]string, 0, len(r.Matched))
+	for _, rule := range r.Matched {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+type Engine struct {
+	policy Policy
+}
+
+func New(policy *Policy) (*Engine, error) {
+	if policy == nil {
+		return nil, ErrPolicyIsNil
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{policy: *policy}
+	if engine.policy.Default == "" {
+		engine.policy.Default = Approve
+	}
+
+	return engine, nil
+}
+
+func (p *Policy) Validate() error {
+	if p.Default != "" {
+		if _, ok := outcomeSeverity[p.Default]; !ok {
+			return fmt.Errorf("default: %w %q", ErrInvalidOutcome, p.Default)
+		}
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: %w", i, ErrMissingRuleName)
+		}
+		if _, ok := outcomeSeverity[rule.Outcome]; !ok {
+			return fmt.Errorf("rule %s: %w %q", rule.Name, ErrInvalidOutcome, rule.Outcome)
+		}
+		if len(rule.When) == 0 {
+			return fmt.Errorf("rule %s: %w", rule.Name, ErrMissingCondition)
+		}
+		for _, condition := range rule.When {
+			if err := condition.validate(); err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c Condition) validate() error {
+	switch c.Op {
+	case Equal, NotEqual, GreaterThan, GreaterThanOrEqual, LessThan, LessThanOrEqual, Contains, Exists, NotExists:
+	case In, NotIn:
+		if _, ok := toList(c.Value); !ok {
+			return fmt.Errorf("%s: %w %q expects a list value", c.Field, ErrInvalidOperator, c.Op)
+		}
+	default:
+		return fmt.Errorf("%s: %w %q", c.Field, ErrInvalidOperator, c.Op)
+	}
+
+	switch {
+	case c.Field == "risk_assessment", c.Field == "reasons", c.Field == "reasons.local",
+		c.Field == "reasons.global", c.Field == "actions":
+	case strings.HasPrefix(c.Field, "evidence.") && len(c.Field) > len("evidence."):
+	case strings.HasPrefix(c.Field, "signals.") && len(c.Field) > len("signals."):
+	case strings.HasPrefix(c.Field, "request."):
+		if _, ok := requestFields[strings.TrimPrefix(c.Field, "request.")]; !ok &&
+			!strings.HasPrefix(c.Field, "request.custom_properties.") {
+			return fmt.Errorf("%w %q", ErrInvalidField, c.Field)
+		}
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidField, c.Field)
+	}
+
+	return nil
+}
+
+var requestFields = map[string]func(Request) interface{}{
+	"account_id":     func(r Request) interface{} { return r.AccountID },
+	"external_id":    func(r Request) interface{} { return r.ExternalID },
+	"policy_id":      func(r Request) interface{} { return r.PolicyID },
+	"store_id":       func(r Request) interface{} { return r.StoreID },
+	"amount":         func(r Request) interface{} { return r.Amount },
+	"currency":       func(r Request) interface{} { return r.Currency },
+	"payment_method": func(r Request) interface{} { return toInterfaces(r.PaymentMethods) },
+}
+
+func (e *Engine) Policy() Policy {
+	return e.policy
+}
+
+func (e *Engine) Evaluate(input Input) Result {
+	result := Result{Outcome: e.policy.Default}
+
+	for _, rule := range e.policy.Rules {
+		if !rule.matches(input) {
+			continue
+		}
+
+		if len(result.Matched) == 0 || outcomeSeverity[rule.Outcome] > outcomeSeverity[result.Outcome] {
+			result.Outcome = rule.Outcome
+		}
+		result.Matched = append(result.Matched, rule)
+	}
+
+	return result
+}
+
+func (r Rule) matches(input Input) bool {
+	for _, condition := range r.When {
+		if !condition.matches(input) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matches(input Input) bool {
+	value, found := c.resolve(input)
+
+	switch c.Op {
+	case Exists:
+		return found
+	case NotExists:
+		return !found
+	}
+
+	if !found {
+		return c.Op == NotEqual || c.Op == NotIn
+	}
+
+	if list, ok := value.([]interface{}); ok {
+		return matchList(c.Op, list, c.Value)
+	}
+
+	return matchScalar(c.Op, value, c.Value)
+}
+
+func (c Condition) resolve(input Input) (interface{}, bool) {
+	switch c.Field {
+	case "risk_assessment":
+		return string(input.RiskAssessment), input.RiskAssessment != ""
+	case "reasons":
+		return reasonCodes(input.Reasons, ""), true
+	case "reasons.local":
+		return reasonCodes(input.Reasons, incognia.ReasonSourceLocal), true
+	case "reasons.global":
+		return reasonCodes(input.Reasons, incognia.ReasonSourceGlobal), true
+	case "actions":
+		actions := make([]interface{}, 0, len(input.Actions))
+		for _, action := range input.Actions {
+			actions = append(actions, string(action))
+		}
+		return actions, true
+	}
+
+	if path := strings.TrimPrefix(c.Field, "evidence."); path != c.Field {
+		return lookup(input.Evidence.GetEvidence, path, c.Value)
+	}
+
+	if path := strings.TrimPrefix(c.Field, "signals."); path != c.Field {
+		return lookup(input.Signals.GetSignal, path, c.Value)
+	}
+
+	if key := strings.TrimPrefix(c.Field, "request.custom_properties."); key != c.Field {
+		value, ok := input.Request.CustomProperties[key]
+		return normalize(value), ok && value != nil
+	}
+
+	if get, ok := requestFields[strings.TrimPrefix(c.Field, "request.")]; ok {
+		value := get(input.Request)
+		return value, !isZero(value)
+	}
+
+	return nil, false
+}
+
+// lookup reads a path with GetEvidence or GetSignal, using the type of the
+// expected value to pick the output type.
+func lookup(get func(string, interface{}) error, path string, expected interface{}) (interface{}, bool) {
+	if list, ok := toList(expected); ok && len(list) > 0 {
+		expected = list[0]
+	}
+
+	switch normalize(expected).(type) {
+	case float64:
+		var out float64
+		return out, get(path, &out) == nil
+	case bool:
+		var out bool
+		return out, get(path, &out) == nil
+	case string:
+		var out string
+		return out, get(path, &out) == nil
+	}
+
+	for _, out := range []interface{}{new(string), new(float64), new(bool)} {
+		err := get(path, out)
+		if err == nil {
+			return reflect.ValueOf(out).Elem().Interface(), true
+		}
+		if errors.Is(err, incognia.ErrEvidenceNotFound) || errors.Is(err, incognia.ErrSignalNotFound) {
+			return nil, false
+		}
+	}
+
+	return nil, true
+}
+
+func reasonCodes(reasons []incognia.Reason, source incognia.ReasonSource) []interface{} {
+	codes := make([]interface{}, 0, len(reasons))
+	for _, reason := range reasons {
+		if source == "" || reason.Source == source {
+			codes = append(codes, string(reason.Code))
+		}
+	}
+	return codes
+}
+
+func matchList(op Operator, list []interface{}, expected interface{}) bool {
+	switch op {
+	case Equal, Contains:
+		return containsValue(list, expected)
+	case NotEqual:
+		return !containsValue(list, expected)
+	case In, NotIn:
+		candidates, _ := toList(expected)
+		matched := false
+		for _, candidate := range candidates {
+			if containsValue(list, candidate) {
+				matched = true
+				break
+			}
+		}
+		return matched == (op == In)
+	}
+	return false
+}
+
+func matchScalar(op Operator, value interface{}, expected interface{}) bool {
+	switch op {
+	case Equal:
+		return equal(value, expected)
+	case NotEqual:
+		return !equal(value, expected)
+	case In, NotIn:
+		candidates, _ := toList(expected)
+		return containsValue(candidates, value) == (op == In)
+	case Contains:
+		s, ok := value.(string)
+		sub, subOk := expected.(string)
+		return ok && subOk && strings.Contains(s, sub)
+	}
+
+	a, aOk := normalize(value).(float64)
+	b, bOk := normalize(expected).(float64)
+	if !aOk || !bOk {
+		return false
+	}
+
+	switch op {
+	case GreaterThan:
+		return a > b
+	case GreaterThanOrEqual:
+		return a >= b
+	case LessThan:
+		return a < b
+	case LessThanOrEqual:
+		return a <= b
+	}
+	return false
+}
+
+func containsValue(list []interface{}, expected interface{}) bool {
+	for _, v := range list {
+		if equal(v, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func toList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		return toInterfaces(v), true
+	}
+	return nil, false
+}
+
+func toInterfaces(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		list = append(list, v)
+	}
+	return list
+}
+
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case incognia.Assessment:
+		return string(v)
+	case incognia.ReasonCode:
+		return string(v)
+	case incognia.Action:
+		return string(v)
+	}
+	return value
+}
+
+func isZero(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return value == nil
+}
@@ -0,0 +1,208 @@
+package decision
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia"
+)
+
+const policyYAML = `
+default: approve
+rules:
+  - name: high-risk
+    outcome: decline
+    when:
+      - field: risk_assessment
+        op: eq
+        value: high_risk
+  - name: large-card-payment
+    outcome: review
+    when:
+      - field: request.amount
+        op: gte
+        value: 1000
+      - field: request.payment_method
+        op: contains
+        value: credit_card
+  - name: spoofing
+    outcome: decline
+    when:
+      - field: reasons.global
+        op: in
+        value: [location_spoofing, emulator]
+  - name: rooted-device
+    outcome: review
+    when:
+      - field: evidence.device_integrity.probable_root
+        op: eq
+        value: true
+`
+
+const candidatePolicyJSON = `{
+	"rules": [
+		{
+			"name": "any-large-payment",
+			"outcome": "review",
+			"when": [{"field": "request.amount", "op": "gt", "value": 500}]
+		}
+	]
+}`
+
+type DecisionTestSuite struct {
+	suite.Suite
+
+	engine *Engine
+}
+
+func (suite *DecisionTestSuite) SetupTest() {
+	policy, err := Parse([]byte(policyYAML), YAML)
+	suite.Require().NoError(err)
+
+	suite.engine, err = New(policy)
+	suite.Require().NoError(err)
+}
+
+func (suite *DecisionTestSuite) TestNoRuleMatchedUsesDefault() {
+	result := suite.engine.Evaluate(FromPayment(lowRiskAssessment(), payment(10, incognia.PaymentMethod{Type: incognia.Pix})))
+
+	suite.Equal(Approve, result.Outcome)
+	suite.Empty(result.Matched)
+}
+
+func (suite *DecisionTestSuite) TestRequestAttributes() {
+	result := suite.engine.Evaluate(FromPayment(lowRiskAssessment(), payment(1500, incognia.PaymentMethod{Type: incognia.CreditCard})))
+
+	suite.Equal(Review, result.Outcome)
+	suite.Equal([]string{"large-card-payment"}, result.MatchedNames())
+
+	result = suite.engine.Evaluate(FromPayment(lowRiskAssessment(), payment(1500, incognia.PaymentMethod{Type: incognia.Pix})))
+	suite.Equal(Approve, result.Outcome)
+}
+
+func (suite *DecisionTestSuite) TestMostSevereOutcomeWins() {
+	assessment := lowRiskAssessment()
+	assessment.RiskAssessment = incognia.HighRisk
+
+	result := suite.engine.Evaluate(FromPayment(assessment, payment(1500, incognia.PaymentMethod{Type: incognia.CreditCard})))
+
+	suite.Equal(Decline, result.Outcome)
+	suite.Equal([]string{"high-risk", "large-card-payment"}, result.MatchedNames())
+}
+
+func (suite *DecisionTestSuite) TestReasonsBySource() {
+	assessment := lowRiskAssessment()
+	assessment.Reasons = []incognia.Reason{{Code: incognia.ReasonLocationSpoofing, Source: incognia.ReasonSourceLocal}}
+
+	suite.Equal(Approve, suite.engine.Evaluate(FromLogin(assessment, &incognia.Login{AccountID: "a"})).Outcome)
+
+	assessment.Reasons = []incognia.Reason{{Code: incognia.ReasonLocationSpoofing, Source: incognia.ReasonSourceGlobal}}
+
+	result := suite.engine.Evaluate(FromLogin(assessment, &incognia.Login{AccountID: "a"}))
+	suite.Equal(Decline, result.Outcome)
+	suite.Equal([]string{"spoofing"}, result.MatchedNames())
+}
+
+func (suite *DecisionTestSuite) TestEvidencePath() {
+	assessment := lowRiskAssessment()
+	assessment.Evidence = incognia.Evidence{
+		"device_integrity": map[string]interface{}{"probable_root": true},
+	}
+
+	result := suite.engine.Evaluate(FromPayment(assessment, nil))
+	suite.Equal(Review, result.Outcome)
+	suite.Equal([]string{"rooted-device"}, result.MatchedNames())
+}
+
+func (suite *DecisionTestSuite) TestExistsOperator() {
+	engine, err := New(&Policy{Rules: []Rule{{
+		Name:    "has-signal",
+		Outcome: Review,
+		When:    []Condition{{Field: "signals.device.emulator", Op: Exists}},
+	}}})
+	suite.Require().NoError(err)
+
+	signup := &incognia.SignupAssessment{Signals: incognia.Signals{"device": map[string]interface{}{"emulator": "detected"}}}
+	suite.Equal(Review, engine.Evaluate(FromSignup(signup, nil)).Outcome)
+	suite.Equal(Approve, engine.Evaluate(FromSignup(&incognia.SignupAssessment{}, nil)).Outcome)
+}
+
+func (suite *DecisionTestSuite) TestValidation() {
+	_, err := New(&Policy{Rules: []Rule{{Name: "r", Outcome: "maybe", When: []Condition{{Field: "actions", Op: Contains}}}}})
+	suite.True(errors.Is(err, ErrInvalidOutcome))
+
+	_, err = New(&Policy{Rules: []Rule{{Name: "r", Outcome: Review, When: []Condition{{Field: "device", Op: Equal}}}}})
+	suite.True(errors.Is(err, ErrInvalidField))
+
+	_, err = New(&Policy{Rules: []Rule{{Name: "r", Outcome: Review, When: []Condition{{Field: "actions", Op: "like"}}}}})
+	suite.True(errors.Is(err, ErrInvalidOperator))
+
+	_, err = New(&Policy{Rules: []Rule{{Name: "r", Outcome: Review, When: []Condition{{Field: "actions", Op: In, Value: "allow"}}}}})
+	suite.True(errors.Is(err, ErrInvalidOperator))
+
+	_, err = New(&Policy{Rules: []Rule{{Name: "r", Outcome: Review}}})
+	suite.True(errors.Is(err, ErrMissingCondition))
+
+	_, err = New(nil)
+	suite.Equal(ErrPolicyIsNil, err)
+}
+
+func (suite *DecisionTestSuite) TestLoad() {
+	dir, err := ioutil.TempDir("", "decision")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "candidate.json")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(candidatePolicyJSON), 0600))
+
+	policy, err := Load(path)
+	suite.NoError(err)
+	suite.Len(policy.Rules, 1)
+
+	_, err = Load(filepath.Join(dir, "candidate.toml"))
+	suite.Error(err)
+}
+
+func (suite *DecisionTestSuite) TestCompare() {
+	policy, err := Parse([]byte(candidatePolicyJSON), JSON)
+	suite.Require().NoError(err)
+	candidate, err := New(policy)
+	suite.Require().NoError(err)
+
+	inputs := []Input{
+		FromPayment(lowRiskAssessment(), payment(10, incognia.PaymentMethod{Type: incognia.Pix})),
+		FromPayment(lowRiskAssessment(), payment(800, incognia.PaymentMethod{Type: incognia.Pix})),
+		FromPayment(lowRiskAssessment(), payment(1500, incognia.PaymentMethod{Type: incognia.CreditCard})),
+	}
+
+	comparison := Compare(suite.engine, candidate, inputs)
+
+	suite.Equal(3, comparison.Total)
+	suite.Equal(1, comparison.Changed)
+	suite.Equal(1, comparison.Diffs[0].Index)
+	suite.Equal(Approve, comparison.Diffs[0].Baseline.Outcome)
+	suite.Equal(Review, comparison.Diffs[0].Candidate.Outcome)
+	suite.Equal(1, comparison.Transitions[Approve][Approve])
+	suite.Equal(1, comparison.Transitions[Approve][Review])
+	suite.Equal(1, comparison.Transitions[Review][Review])
+}
+
+func TestDecisionTestSuite(t *testing.T) {
+	suite.Run(t, new(DecisionTestSuite))
+}
+
+func lowRiskAssessment() *incognia.TransactionAssessment {
+	return &incognia.TransactionAssessment{RiskAssessment: incognia.LowRisk}
+}
+
+func payment(amount float64, method incognia.PaymentMethod) *incognia.Payment {
+	return &incognia.Payment{
+		AccountID: "account-id",
+		Value:     &incognia.PaymentValue{Amount: amount, Currency: "BRL"},
+		Methods:   []*incognia.PaymentMethod{&method},
+	}
+}
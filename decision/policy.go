@@ -0,0 +1,61 @@
+package decision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+var ErrUnknownFormat = errors.New("unknown policy format")
+
+func Parse(data []byte, format Format) (*Policy, error) {
+	var policy Policy
+
+	switch format {
+	case JSON:
+		if err := json.Unmarshal(data, &policy); err != nil {
+			return nil, err
+		}
+	case YAML:
+		if err := yaml.Unmarshal(data, &policy); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// Load reads a policy file, picking the format from its extension.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return Parse(data, JSON)
+	case ".yaml", ".yml":
+		return Parse(data, YAML)
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, filepath.Ext(path))
+}
@@ -0,0 +1,146 @@
+package incognia
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errDedupCallAborted is returned to callers waiting on a call that panicked.
+var errDedupCallAborted = errors.New("deduplicated call was aborted")
+
+// deduplicator collapses identical in-flight calls into one upstream call
+// and, when ttl is positive, keeps successful responses for ttl.
+type deduplicator struct {
+	ttl   time.Duration
+	clock Clock
+
+	mutex    sync.Mutex
+	inFlight map[string]*dedupCall
+	cache    map[string]dedupEntry
+	// expiries lists the cached keys in the order they were added, which is
+	// the order they expire in since every entry lives for ttl.
+	expiries []dedupExpiry
+}
+
+// sharedResponse is the raw response of a call and its request ID.
+type sharedResponse struct {
+	body      json.RawMessage
+	requestID string
+}
+
+type dedupCall struct {
+	done     chan struct{}
+	response sharedResponse
+	err      error
+}
+
+type dedupEntry struct {
+	response  sharedResponse
+	expiresAt time.Time
+}
+
+type dedupExpiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newDeduplicator(enabled bool, ttl time.Duration, clock Clock) *deduplicator {
+	if !enabled {
+		return nil
+	}
+
+	return &deduplicator{
+		ttl:      ttl,
+		clock:    clock,
+		inFlight: map[string]*dedupCall{},
+		cache:    map[string]dedupEntry{},
+	}
+}
+
+// dedupKey hashes everything that identifies a call. Bodies are encoded by
+// the client Codec, which must encode equal values to equal bytes for equal
+// requests to hash the same. The StandardCodec sorts map keys to do so.
+func dedupKey(operation Operation, endpoint string, query url.Values, requestBody []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(operation))
+	hash.Write([]byte{0})
+	hash.Write([]byte(endpoint))
+	hash.Write([]byte{0})
+	hash.Write([]byte(query.Encode()))
+	hash.Write([]byte{0})
+	hash.Write(requestBody)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// do returns the response of call for key, running call only when no
+// identical call is in flight or cached. A waiting caller stops waiting when
+// its ctx is done, but the call itself runs on the ctx of the first caller.
+func (d *deduplicator) do(ctx context.Context, key string, call func() (sharedResponse, error)) (sharedResponse, error) {
+	d.mutex.Lock()
+	if entry, ok := d.cache[key]; ok {
+		if d.clock.Now().Before(entry.expiresAt) {
+			d.mutex.Unlock()
+			return entry.response, nil
+		}
+		delete(d.cache, key)
+	}
+
+	if inFlight, ok := d.inFlight[key]; ok {
+		d.mutex.Unlock()
+		select {
+		case <-inFlight.done:
+			return inFlight.response, inFlight.err
+		case <-ctx.Done():
+			return sharedResponse{}, ctx.Err()
+		}
+	}
+
+	current := &dedupCall{done: make(chan struct{}), err: errDedupCallAborted}
+	d.inFlight[key] = current
+	d.mutex.Unlock()
+
+	defer func() {
+		d.mutex.Lock()
+		delete(d.inFlight, key)
+		if current.err == nil && d.ttl > 0 {
+			now := d.clock.Now()
+			d.removeExpired(now)
+			d.cache[key] = dedupEntry{response: current.response, expiresAt: now.Add(d.ttl)}
+			d.expiries = append(d.expiries, dedupExpiry{key: key, expiresAt: now.Add(d.ttl)})
+		}
+		d.mutex.Unlock()
+		close(current.done)
+	}()
+
+	current.response, current.err = call()
+
+	return current.response, current.err
+}
+
+// removeExpired drops the entries expired at now, from the oldest, stopping
+// at the first one still valid.
+func (d *deduplicator) removeExpired(now time.Time) {
+	expired := 0
+	for _, expiry := range d.expiries {
+		if now.Before(expiry.expiresAt) {
+			break
+		}
+		// The key may have been cached again since, with a later expiry.
+		if entry, ok := d.cache[expiry.key]; ok && entry.expiresAt.Equal(expiry.expiresAt) {
+			delete(d.cache, expiry.key)
+		}
+		expired++
+	}
+
+	d.expiries = d.expiries[expired:]
+	if len(d.expiries) == 0 {
+		d.expiries = nil
+	}
+}
@@ -0,0 +1,192 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia/incogniatest"
+)
+
+type DedupTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	server      *httptest.Server
+	calls       int32
+	statusCode  int32
+}
+
+func (suite *DedupTestSuite) SetupTest() {
+	atomic.StoreInt32(&suite.calls, 0)
+	atomic.StoreInt32(&suite.statusCode, http.StatusOK)
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&suite.calls, 1)
+		time.Sleep(50 * time.Millisecond)
+
+		statusCode := int(atomic.LoadInt32(&suite.statusCode))
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		res, _ := json.Marshal(map[string]interface{}{"id": body["account_id"], "risk_assessment": LowRisk})
+		w.Write(res)
+	}))
+}
+
+func (suite *DedupTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *DedupTestSuite) newClient(deduplicate bool, ttl time.Duration) *Client {
+	client, _ := New(&IncogniaClientConfig{
+		ClientID:            clientID,
+		ClientSecret:        clientSecret,
+		LogLevel:            LogLevelOff,
+		DeduplicateRequests: deduplicate,
+		DeduplicationTTL:    ttl,
+	})
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.server.URL
+	client.endpoints.Feedback = suite.server.URL
+	return client
+}
+
+func (suite *DedupTestSuite) loginConcurrently(client *Client, logins ...*Login) ([]*TransactionAssessment, []error) {
+	assessments := make([]*TransactionAssessment, len(logins))
+	errs := make([]error, len(logins))
+
+	var wg sync.WaitGroup
+	for i := range logins {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assessments[i], errs[i] = client.RegisterLogin(logins[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return assessments, errs
+}
+
+func newDedupLogin(accountID string) *Login {
+	installationID := "installation-id"
+	return &Login{
+		InstallationID:   &installationID,
+		RequestToken:     "request-token",
+		AccountID:        accountID,
+		CustomProperties: map[string]interface{}{"b": 1, "a": 2},
+	}
+}
+
+func (suite *DedupTestSuite) TestIdenticalConcurrentCallsAreCollapsed() {
+	client := suite.newClient(true, 0)
+
+	assessments, errs := suite.loginConcurrently(client, newDedupLogin("account"), newDedupLogin("account"), newDedupLogin("account"))
+
+	suite.Equal(int32(1), atomic.LoadInt32(&suite.calls))
+	for i := range assessments {
+		suite.NoError(errs[i])
+		suite.Equal("account", assessments[i].ID)
+	}
+	suite.False(assessments[0] == assessments[1], "callers must not share the same assessment")
+}
+
+func (suite *DedupTestSuite) TestDifferentCallsAreNotCollapsed() {
+	client := suite.newClient(true, 0)
+
+	_, errs := suite.loginConcurrently(client, newDedupLogin("account-1"), newDedupLogin("account-2"))
+
+	suite.NoError(errs[0])
+	suite.NoError(errs[1])
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.calls))
+}
+
+func (suite *DedupTestSuite) TestCallsAreNotCollapsedByDefault() {
+	client := suite.newClient(false, 0)
+
+	suite.loginConcurrently(client, newDedupLogin("account"), newDedupLogin("account"))
+
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.calls))
+}
+
+func (suite *DedupTestSuite) TestResultsAreCachedForTTL() {
+	client := suite.newClient(true, 100*time.Millisecond)
+
+	_, err := client.RegisterLogin(newDedupLogin("account"))
+	suite.NoError(err)
+	assessment, err := client.RegisterLogin(newDedupLogin("account"))
+	suite.NoError(err)
+	suite.Equal("account", assessment.ID)
+	suite.Equal(int32(1), atomic.LoadInt32(&suite.calls))
+
+	time.Sleep(110 * time.Millisecond)
+	_, err = client.RegisterLogin(newDedupLogin("account"))
+	suite.NoError(err)
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.calls))
+}
+
+func (suite *DedupTestSuite) TestErrorsAreSharedButNotCached() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusBadRequest)
+	client := suite.newClient(true, time.Minute)
+
+	_, errs := suite.loginConcurrently(client, newDedupLogin("account"), newDedupLogin("account"))
+	suite.IsType(&APIError{}, errs[0])
+	suite.IsType(&APIError{}, errs[1])
+	suite.Equal(int32(1), atomic.LoadInt32(&suite.calls))
+
+	atomic.StoreInt32(&suite.statusCode, http.StatusOK)
+	_, err := client.RegisterLogin(newDedupLogin("account"))
+	suite.NoError(err)
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.calls))
+}
+
+func (suite *DedupTestSuite) TestFeedbacksAreNotCollapsed() {
+	client := suite.newClient(true, time.Minute)
+
+	suite.NoError(client.RegisterFeedback(AccountTakeover, &now, &FeedbackIdentifiers{AccountID: "account"}))
+	suite.NoError(client.RegisterFeedback(AccountTakeover, &now, &FeedbackIdentifiers{AccountID: "account"}))
+
+	suite.Equal(int32(2), atomic.LoadInt32(&suite.calls))
+}
+
+func (suite *DedupTestSuite) TestExpiredResultsAreRemovedOldestFirst() {
+	clock := incogniatest.NewClock(time.Now())
+	d := newDeduplicator(true, time.Minute, clock)
+	call := func() (sharedResponse, error) { return sharedResponse{body: json.RawMessage(`{}`)}, nil }
+
+	for _, key := range []string{"a", "b"} {
+		_, err := d.do(context.Background(), key, call)
+		suite.Require().NoError(err)
+		clock.Advance(30 * time.Second)
+	}
+
+	_, err := d.do(context.Background(), "c", call)
+	suite.Require().NoError(err)
+	suite.Len(d.cache, 2, "a expired")
+	suite.NotContains(d.cache, "a")
+	suite.Len(d.expiries, 2)
+
+	clock.Advance(time.Minute)
+	_, err = d.do(context.Background(), "b", call)
+	suite.Require().NoError(err)
+	suite.Len(d.cache, 1, "b is cached again, c expired")
+	suite.Contains(d.cache, "b")
+	suite.Len(d.expiries, 1)
+}
+
+func TestDedupTestSuite(t *testing.T) {
+	suite.Run(t, new(DedupTestSuite))
+}
@@ -0,0 +1,80 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// dryRunResponse is the synthetic assessment returned by dry runs.
+var dryRunResponse = json.RawMessage(`{"id":"dry-run","risk_assessment":"unknown_risk"}`)
+
+// DryRunResult is the request a dry run would have sent. The Authorization
+// header is redacted.
+type DryRunResult struct {
+	Operation Operation
+	Method    string
+	URL       string
+	Header    http.Header
+	Body      json.RawMessage
+}
+
+type dryRunKey struct{}
+
+type dryRunValue struct {
+	result *DryRunResult
+}
+
+// WithDryRun returns a context whose calls are dry runs, as with the DryRun
+// config option. Each call stores what it would have sent in result, which
+// may be nil.
+func WithDryRun(ctx context.Context, result *DryRunResult) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, dryRunValue{result: result})
+}
+
+// dryRunResult returns where to store the dry run of a call made with ctx, or
+// nil when the call should be sent.
+func (c *Client) dryRunResult(ctx context.Context) *DryRunResult {
+	if value, ok := ctx.Value(dryRunKey{}).(dryRunValue); ok {
+		if value.result != nil {
+			return value.result
+		}
+		return &DryRunResult{}
+	}
+
+	if c.dryRunAll {
+		return &DryRunResult{}
+	}
+
+	return nil
+}
+
+func (c *Client) dryRun(ctx context.Context, operation Operation, endpoint string, query url.Values, requestBody []byte, response interface{}, result *DryRunResult) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+
+	c.setHeaders(req)
+	req.Header.Set("Authorization", "Bearer "+redactedValue)
+
+	*result = DryRunResult{
+		Operation: operation,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Header:    req.Header,
+		Body:      append(json.RawMessage(nil), requestBody...),
+	}
+
+	c.logger.debugf("dry run: %s %s %s", result.Method, result.URL, requestBody)
+	if c.onDryRun != nil {
+		c.onDryRun(result)
+	}
+
+	return c.decodeRawResponse(dryRunResponse, response)
+}
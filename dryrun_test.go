@@ -0,0 +1,126 @@
+package incognia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type failingHTTPClient struct {
+	calls int32
+}
+
+func (f *failingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return nil, errors.New("unexpected request")
+}
+
+type DryRunTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	tokenCalls  int32
+	httpClient  *failingHTTPClient
+	logs        bytes.Buffer
+}
+
+func (suite *DryRunTestSuite) SetupTest() {
+	atomic.StoreInt32(&suite.tokenCalls, 0)
+	suite.httpClient = &failingHTTPClient{}
+	suite.logs.Reset()
+
+	suite.tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&suite.tokenCalls, 1)
+		w.Write([]byte(`{"access_token":"token","expires_in":"900","token_type":"Bearer"}`))
+	}))
+}
+
+func (suite *DryRunTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.Equal(int32(0), atomic.LoadInt32(&suite.httpClient.calls))
+	suite.Equal(int32(0), atomic.LoadInt32(&suite.tokenCalls))
+}
+
+func (suite *DryRunTestSuite) newClient(config IncogniaClientConfig) *Client {
+	config.ClientID = clientID
+	config.ClientSecret = clientSecret
+	config.HTTPClient = suite.httpClient
+	config.Logger = log.New(&suite.logs, "", 0)
+	config.LogLevel = LogLevelDebug
+
+	client, err := New(&config)
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	return client
+}
+
+func (suite *DryRunTestSuite) TestCallWithDryRunContext() {
+	client := suite.newClient(IncogniaClientConfig{})
+
+	var result DryRunResult
+	installationID := "installation-id"
+	assessment, err := client.RegisterPaymentWithContext(WithDryRun(context.Background(), &result), &Payment{
+		InstallationID: &installationID,
+		AccountID:      "account-id",
+		DeviceOs:       "Android",
+		Eval:           &shouldNotEval,
+	})
+	suite.Require().NoError(err)
+	suite.Equal(&TransactionAssessment{ID: "dry-run", RiskAssessment: UnknownRisk}, assessment)
+
+	suite.Equal(OperationRegisterPayment, result.Operation)
+	suite.Equal("POST", result.Method)
+	suite.Equal(client.endpoints.Transactions+"?eval=false", result.URL)
+	suite.Equal("Bearer REDACTED", result.Header.Get("Authorization"))
+	suite.Equal("application/json", result.Header.Get("Content-Type"))
+	suite.Equal(client.UserAgent, result.Header.Get("User-Agent"))
+
+	var body map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(result.Body, &body))
+	suite.Equal(map[string]interface{}{
+		"installation_id": "installation-id",
+		"account_id":      "account-id",
+		"device_os":       "android",
+		"type":            "payment",
+	}, body)
+	suite.Contains(suite.logs.String(), "dry run: POST "+result.URL)
+}
+
+func (suite *DryRunTestSuite) TestDryRunConfig() {
+	var results []DryRunResult
+	client := suite.newClient(IncogniaClientConfig{
+		DryRun:   true,
+		OnDryRun: func(result *DryRunResult) { results = append(results, *result) },
+	})
+
+	signup, err := client.RegisterSignup("installation-id", nil)
+	suite.NoError(err)
+	suite.Equal("dry-run", signup.ID)
+	suite.NoError(client.RegisterFeedback(AccountTakeover, &now, &FeedbackIdentifiers{AccountID: "account-id"}))
+
+	suite.Require().Len(results, 2)
+	suite.Equal(OperationRegisterSignup, results[0].Operation)
+	suite.Equal(OperationRegisterFeedback, results[1].Operation)
+	suite.Contains(string(results[1].Body), `"event":"account_takeover"`)
+}
+
+func (suite *DryRunTestSuite) TestDryRunStillValidates() {
+	client := suite.newClient(IncogniaClientConfig{DryRun: true})
+
+	_, err := client.RegisterLoginWithContext(context.Background(), &Login{})
+	suite.Equal(ErrMissingAccountID, err)
+	_, err = client.RegisterWebLoginWithContext(WithDryRun(context.Background(), nil), &WebLogin{})
+	suite.Equal(ErrMissingAccountID, err)
+}
+
+func TestDryRunTestSuite(t *testing.T) {
+	suite.Run(t, new(DryRunTestSuite))
+}
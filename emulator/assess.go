@@ -0,0 +1,246 @@
+package emulator
+
+import (
+	"time"
+
+	"repo.incognia.com/go/incognia"
+)
+
+type reason struct {
+	Code   incognia.ReasonCode   `json:"code"`
+	Source incognia.ReasonSource `json:"source"`
+}
+
+type assessment struct {
+	ID             string                 `json:"id"`
+	DeviceID       string                 `json:"device_id,omitempty"`
+	RequestID      string                 `json:"request_id,omitempty"`
+	RiskAssessment incognia.Assessment    `json:"risk_assessment"`
+	Evidence       map[string]interface{} `json:"evidence"`
+	Signals        map[string]interface{} `json:"signals"`
+	Reasons        []reason               `json:"reasons"`
+	Actions        []incognia.Action      `json:"actions,omitempty"`
+}
+
+// fraudReasons are the feedbacks that tag an account or device as
+// fraudulent, with the reason later assessments give.
+var fraudReasons = map[incognia.FeedbackType]incognia.ReasonCode{
+	incognia.AccountTakeover:        incognia.ReasonAccountTakeover,
+	incognia.IdentityFraud:          incognia.ReasonIdentityFraud,
+	incognia.Chargeback:             incognia.ReasonChargeback,
+	incognia.ChargebackNotification: incognia.ReasonChargeback,
+	incognia.PromotionAbuse:         incognia.ReasonPromotionAbuse,
+}
+
+// trustedFeedbacks are the feedbacks that make later assessments low risk.
+var trustedFeedbacks = map[incognia.FeedbackType]bool{
+	incognia.AccountAllowed:                    true,
+	incognia.DeviceAllowed:                     true,
+	incognia.Verified:                          true,
+	incognia.LoginAccepted:                     true,
+	incognia.LoginAcceptedByDeviceVerification: true,
+	incognia.LoginAcceptedByFacialBiometrics:   true,
+	incognia.LoginAcceptedByManualReview:       true,
+	incognia.PaymentAccepted:                   true,
+	incognia.PaymentAcceptedByControlGroup:     true,
+	incognia.PaymentAcceptedByThirdParty:       true,
+	incognia.SignupAccepted:                    true,
+}
+
+var riskActions = map[incognia.Assessment][]incognia.Action{
+	incognia.HighRisk:    {incognia.ActionBlock},
+	incognia.UnknownRisk: {incognia.ActionWarnUser},
+}
+
+var riskReputations = map[incognia.Assessment]string{
+	incognia.LowRisk:     "allowed",
+	incognia.HighRisk:    "suspicious",
+	incognia.UnknownRisk: "unknown",
+}
+
+// assess decides the assessment of req. Feedbacks on the account, then on
+// the device, take precedence over the rules.
+func (e *Emulator) assess(req *assessmentRequest) *assessment {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	device := req.deviceKey()
+
+	accounts := e.deviceAccounts[device]
+	newDevice := device != "" && accounts == nil
+	knownAccount := req.AccountID != "" && accounts[req.AccountID]
+	if device != "" && req.AccountID != "" {
+		if accounts == nil {
+			accounts = map[string]bool{}
+			e.deviceAccounts[device] = accounts
+		}
+		accounts[req.AccountID] = true
+	}
+
+	velocity := 0
+	if req.AccountID != "" {
+		calls := e.accountCalls[req.AccountID]
+		for len(calls) > 0 && now.Sub(calls[0]) > velocityWindow {
+			calls = calls[1:]
+		}
+		calls = append(calls, now)
+		e.accountCalls[req.AccountID] = calls
+		velocity = len(calls)
+	}
+
+	risk := e.config.DefaultRisk
+	var reasons []reason
+	var ruleEvidence map[string]interface{}
+	for i := range e.config.Rules {
+		rule := &e.config.Rules[i]
+		if rule.matches(req) {
+			risk = rule.Risk
+			for _, code := range rule.Reasons {
+				reasons = append(reasons, reason{Code: code, Source: incognia.ReasonSourceLocal})
+			}
+			ruleEvidence = rule.Evidence
+			break
+		}
+	}
+
+	if feedbackRisk, feedbackReasons, ok := e.feedbackAssessment(req.AccountID, device, now); ok {
+		risk, reasons = feedbackRisk, feedbackReasons
+	}
+
+	if reasons == nil {
+		reasons = defaultReasons(risk, newDevice)
+	}
+
+	result := &assessment{
+		ID:             newID(),
+		DeviceID:       deviceID(device),
+		RiskAssessment: risk,
+		Evidence:       evidence(req, risk, reasons, knownAccount, len(accounts), now),
+		Signals: map[string]interface{}{
+			"new_device":         newDevice,
+			"velocity_last_hour": velocity,
+		},
+		Reasons: reasons,
+		Actions: riskActions[risk],
+	}
+	for name, value := range ruleEvidence {
+		result.Evidence[name] = value
+	}
+
+	e.subjects[result.ID] = subject{accountID: req.AccountID, deviceKey: device}
+
+	return result
+}
+
+func (e *Emulator) feedbackAssessment(accountID, device string, now time.Time) (incognia.Assessment, []reason, bool) {
+	candidates := []struct {
+		key    string
+		tagged incognia.ReasonCode
+	}{
+		{accountKey(accountID), incognia.ReasonTaggedAccount},
+		{deviceKey(device), incognia.ReasonTaggedDevice},
+	}
+
+	for _, candidate := range candidates {
+		record, ok := e.feedbacks[candidate.key]
+		if !ok || (record.expiresAt != nil && now.After(*record.expiresAt)) {
+			continue
+		}
+
+		if code, fraud := fraudReasons[record.event]; fraud {
+			return incognia.HighRisk, []reason{
+				{Code: candidate.tagged, Source: incognia.ReasonSourceLocal},
+				{Code: code, Source: incognia.ReasonSourceLocal},
+			}, true
+		}
+
+		if trustedFeedbacks[record.event] {
+			return incognia.LowRisk, []reason{{Code: incognia.ReasonTrustedDevice, Source: incognia.ReasonSourceLocal}}, true
+		}
+	}
+
+	return "", nil, false
+}
+
+func defaultReasons(risk incognia.Assessment, newDevice bool) []reason {
+	var reasons []reason
+	switch risk {
+	case incognia.LowRisk:
+		if !newDevice {
+			reasons = append(reasons, reason{Code: incognia.ReasonTrustedDevice, Source: incognia.ReasonSourceLocal})
+		}
+		reasons = append(reasons, reason{Code: incognia.ReasonTrustedLocation, Source: incognia.ReasonSourceGlobal})
+	case incognia.HighRisk:
+		reasons = append(reasons, reason{Code: incognia.ReasonSuspiciousLocation, Source: incognia.ReasonSourceGlobal})
+	}
+
+	if newDevice && risk != incognia.LowRisk {
+		reasons = append(reasons, reason{Code: incognia.ReasonNewDevice, Source: incognia.ReasonSourceLocal})
+	}
+
+	if reasons == nil {
+		reasons = []reason{}
+	}
+
+	return reasons
+}
+
+// evidence builds evidence consistent with the risk and reasons, so that
+// code reading evidence sees the same picture as code reading reasons.
+func evidence(req *assessmentRequest, risk incognia.Assessment, reasons []reason, knownAccount bool, accountsByDevice int, now time.Time) map[string]interface{} {
+	integrity := map[string]interface{}{
+		"probable_root":       false,
+		"emulator":            false,
+		"gps_spoofing":        false,
+		"from_official_store": true,
+	}
+	reputation := riskReputations[risk]
+	remoteAccess := false
+
+	for _, r := range reasons {
+		switch r.Code {
+		case incognia.ReasonEmulator:
+			integrity["emulator"] = true
+		case incognia.ReasonLocationSpoofing:
+			integrity["gps_spoofing"] = true
+		case incognia.ReasonAppTampering:
+			integrity["from_official_store"] = false
+		case incognia.ReasonDeviceIntegrity:
+			integrity["probable_root"] = true
+		case incognia.ReasonRemoteAccess:
+			remoteAccess = true
+		case incognia.ReasonTaggedDevice, incognia.ReasonTaggedAccount:
+			reputation = "suspicious"
+		}
+	}
+
+	result := map[string]interface{}{
+		"device_integrity":        integrity,
+		"device_fraud_reputation": reputation,
+		"remote_access":           remoteAccess,
+		"known_account":           knownAccount,
+		"accounts_by_device":      accountsByDevice,
+		"location_services": map[string]interface{}{
+			"location_permission_enabled": true,
+			"location_sensors_enabled":    true,
+		},
+		"last_location_ts": now.UTC().Format(time.RFC3339),
+	}
+
+	switch risk {
+	case incognia.LowRisk:
+		result["distance_to_trusted_location"] = 0.05
+	case incognia.HighRisk:
+		result["distance_to_trusted_location"] = 874.2
+	}
+
+	switch req.DeviceOs {
+	case "android":
+		result["device_model"] = "Pixel 7"
+	case "ios":
+		result["device_model"] = "iPhone14,2"
+	}
+
+	return result
+}
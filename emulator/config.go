@@ -0,0 +1,199 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"repo.incognia.com/go/incognia"
+)
+
+const defaultTokenExpiresIn = 15 * time.Minute
+
+// Operations matched by rules.
+const (
+	OperationSignup  = "signup"
+	OperationLogin   = "login"
+	OperationPayment = "payment"
+)
+
+var (
+	ErrUnknownRisk       = errors.New("unknown risk assessment")
+	ErrUnknownReasonCode = errors.New("unknown reason code")
+	ErrUnknownOperation  = errors.New("unknown operation")
+	ErrInvalidAmounts    = errors.New("min_amount is greater than max_amount")
+)
+
+// Config sets the credentials the emulator accepts and the rules deciding
+// its assessments. Any credentials are accepted when ClientID is empty.
+type Config struct {
+	ClientID     string `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	// TokenExpiresIn is the lifetime of issued tokens, 15 minutes by default.
+	TokenExpiresIn time.Duration `json:"token_expires_in,omitempty" yaml:"token_expires_in,omitempty"`
+	// DefaultRisk is the assessment when no rule matches, low_risk by
+	// default.
+	DefaultRisk incognia.Assessment `json:"default_risk,omitempty" yaml:"default_risk,omitempty"`
+	// Rules are tried in order and the first one matching decides.
+	Rules []Rule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// Rule matches assessments by every field set, and an empty rule matches
+// all of them. Fields listing values match when any of them is equal.
+type Rule struct {
+	Name            string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Operations      []string `json:"operations,omitempty" yaml:"operations,omitempty"`
+	AccountIDs      []string `json:"account_ids,omitempty" yaml:"account_ids,omitempty"`
+	InstallationIDs []string `json:"installation_ids,omitempty" yaml:"installation_ids,omitempty"`
+	// Countries are ISO 3166 codes, matched against the login countries and
+	// the country code of the signup or payment addresses.
+	Countries []string `json:"countries,omitempty" yaml:"countries,omitempty"`
+	// MinAmount and MaxAmount bound the payment value, inclusive. Requests
+	// without a value do not match a rule with either of them.
+	MinAmount *float64 `json:"min_amount,omitempty" yaml:"min_amount,omitempty"`
+	MaxAmount *float64 `json:"max_amount,omitempty" yaml:"max_amount,omitempty"`
+	// CustomProperties match when every property has the given value,
+	// compared in its text form.
+	CustomProperties map[string]interface{} `json:"custom_properties,omitempty" yaml:"custom_properties,omitempty"`
+
+	Risk incognia.Assessment `json:"risk" yaml:"risk"`
+	// Reasons replace the reasons generated for Risk.
+	Reasons []incognia.ReasonCode `json:"reasons,omitempty" yaml:"reasons,omitempty"`
+	// Evidence is merged over the generated evidence.
+	Evidence map[string]interface{} `json:"evidence,omitempty" yaml:"evidence,omitempty"`
+}
+
+// Parse reads a config in YAML, which also accepts JSON.
+func Parse(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	if c.DefaultRisk != "" && !knownRisk(c.DefaultRisk) {
+		return fmt.Errorf("default_risk: %w %q", ErrUnknownRisk, c.DefaultRisk)
+	}
+
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return fmt.Errorf("rule %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if !knownRisk(r.Risk) {
+		return fmt.Errorf("%w %q", ErrUnknownRisk, r.Risk)
+	}
+
+	for _, reason := range r.Reasons {
+		if !reason.IsKnown() {
+			return fmt.Errorf("%w %q", ErrUnknownReasonCode, reason)
+		}
+	}
+
+	for _, operation := range r.Operations {
+		if operation != OperationSignup && operation != OperationLogin && operation != OperationPayment {
+			return fmt.Errorf("%w %q", ErrUnknownOperation, operation)
+		}
+	}
+
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return ErrInvalidAmounts
+	}
+
+	return nil
+}
+
+func knownRisk(risk incognia.Assessment) bool {
+	return risk == incognia.LowRisk || risk == incognia.HighRisk || risk == incognia.UnknownRisk
+}
+
+func (r *Rule) matches(req *assessmentRequest) bool {
+	if len(r.Operations) > 0 && !contains(r.Operations, req.operation, false) {
+		return false
+	}
+
+	if len(r.AccountIDs) > 0 && !contains(r.AccountIDs, req.AccountID, false) {
+		return false
+	}
+
+	if len(r.InstallationIDs) > 0 && !contains(r.InstallationIDs, req.InstallationID, false) {
+		return false
+	}
+
+	if len(r.Countries) > 0 {
+		matched := false
+		for _, country := range req.countries() {
+			if contains(r.Countries, country, true) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.MinAmount != nil || r.MaxAmount != nil {
+		if req.PaymentValue == nil {
+			return false
+		}
+		if r.MinAmount != nil && req.PaymentValue.Amount < *r.MinAmount {
+			return false
+		}
+		if r.MaxAmount != nil && req.PaymentValue.Amount > *r.MaxAmount {
+			return false
+		}
+	}
+
+	for name, expected := range r.CustomProperties {
+		value, ok := req.CustomProperties[name]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(expected) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string, ignoreCase bool) bool {
+	for _, v := range values {
+		if v == value || (ignoreCase && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,342 @@
+// Package emulator is a local stand-in for the Incognia API, for development
+// and QA environments without real credentials. It serves the token,
+// signups, transactions and feedbacks endpoints, decides risk by configurable
+// rules, and remembers feedbacks so later assessments of the same account or
+// device change.
+//
+// Point a client at it with the BaseURL option:
+//
+//	server := httptest.NewServer(emu)
+//	client, err := incognia.New(&incognia.IncogniaClientConfig{
+//		ClientID:     "id",
+//		ClientSecret: "secret",
+//		BaseURL:      server.URL,
+//	})
+package emulator
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"repo.incognia.com/go/incognia"
+)
+
+const velocityWindow = time.Hour
+
+// Emulator is an http.Handler serving the Incognia API, under / or /api.
+// It keeps its state in memory and is safe for concurrent use.
+type Emulator struct {
+	config Config
+
+	mutex          sync.Mutex
+	tokens         map[string]time.Time
+	subjects       map[string]subject
+	feedbacks      map[string]feedbackRecord
+	deviceAccounts map[string]map[string]bool
+	accountCalls   map[string][]time.Time
+}
+
+// subject is who an assessment was about, so that feedbacks referencing the
+// assessment by ID apply to them.
+type subject struct {
+	accountID string
+	deviceKey string
+}
+
+type feedbackRecord struct {
+	event     incognia.FeedbackType
+	expiresAt *time.Time
+}
+
+// New returns an emulator for config, or with the defaults when config is
+// nil.
+func New(config *Config) (*Emulator, error) {
+	e := &Emulator{
+		tokens:         map[string]time.Time{},
+		subjects:       map[string]subject{},
+		feedbacks:      map[string]feedbackRecord{},
+		deviceAccounts: map[string]map[string]bool{},
+		accountCalls:   map[string][]time.Time{},
+	}
+
+	if config != nil {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		e.config = *config
+	}
+
+	if e.config.TokenExpiresIn <= 0 {
+		e.config.TokenExpiresIn = defaultTokenExpiresIn
+	}
+	if e.config.DefaultRisk == "" {
+		e.config.DefaultRisk = incognia.LowRisk
+	}
+
+	return e, nil
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	if path == "/v2/token" {
+		e.serveToken(w, r)
+		return
+	}
+
+	if !e.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer body.Close()
+		r.Body = body
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content encoding %q", encoding))
+		return
+	}
+
+	switch path {
+	case "/v2/onboarding/signups":
+		e.serveAssessment(w, r, true)
+	case "/v2/authentication/transactions":
+		e.serveAssessment(w, r, false)
+	case "/v2/feedbacks":
+		e.serveFeedback(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (e *Emulator) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || (e.config.ClientID != "" && (clientID != e.config.ClientID || clientSecret != e.config.ClientSecret)) {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	token := newID()
+	now := time.Now()
+
+	e.mutex.Lock()
+	for issued, expiresAt := range e.tokens {
+		if now.After(expiresAt) {
+			delete(e.tokens, issued)
+		}
+	}
+	e.tokens[token] = now.Add(e.config.TokenExpiresIn)
+	e.mutex.Unlock()
+
+	writeJSON(w, map[string]string{
+		"access_token": token,
+		"expires_in":   fmt.Sprintf("%d", int64(e.config.TokenExpiresIn/time.Second)),
+		"token_type":   "Bearer",
+	})
+}
+
+func (e *Emulator) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	expiresAt, ok := e.tokens[token]
+	return ok && time.Now().Before(expiresAt)
+}
+
+type assessmentRequest struct {
+	Type              string                         `json:"type"`
+	InstallationID    string                         `json:"installation_id"`
+	RequestToken      string                         `json:"request_token"`
+	AccountID         string                         `json:"account_id"`
+	DeviceOs          string                         `json:"device_os"`
+	Countries         []string                       `json:"countries"`
+	StructuredAddress *incognia.StructuredAddress    `json:"structured_address"`
+	Addresses         []*incognia.TransactionAddress `json:"addresses"`
+	PaymentValue      *incognia.PaymentValue         `json:"payment_value"`
+	CustomProperties  map[string]interface{}         `json:"custom_properties"`
+
+	operation string
+}
+
+func (r *assessmentRequest) countries() []string {
+	countries := append([]string(nil), r.Countries...)
+	if r.StructuredAddress != nil && r.StructuredAddress.CountryCode != "" {
+		countries = append(countries, r.StructuredAddress.CountryCode)
+	}
+	for _, address := range r.Addresses {
+		if address != nil && address.StructuredAddress != nil && address.StructuredAddress.CountryCode != "" {
+			countries = append(countries, address.StructuredAddress.CountryCode)
+		}
+	}
+
+	return countries
+}
+
+// deviceKey identifies the device, by its installation or, on the web, by
+// its request token.
+func (r *assessmentRequest) deviceKey() string {
+	if r.InstallationID != "" {
+		return r.InstallationID
+	}
+
+	return r.RequestToken
+}
+
+func (e *Emulator) serveAssessment(w http.ResponseWriter, r *http.Request, signup bool) {
+	req := &assessmentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if signup {
+		req.operation = OperationSignup
+	} else {
+		req.operation = req.Type
+		if req.operation != OperationLogin && req.operation != OperationPayment {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown transaction type %q", req.Type))
+			return
+		}
+		if req.AccountID == "" {
+			writeError(w, http.StatusBadRequest, "account_id is required")
+			return
+		}
+	}
+
+	if req.deviceKey() == "" && req.AccountID == "" {
+		writeError(w, http.StatusBadRequest, "installation_id or request_token is required")
+		return
+	}
+
+	result := e.assess(req)
+	if r.URL.Query().Get("eval") == "false" {
+		writeJSON(w, map[string]string{"id": result.ID})
+		return
+	}
+	if signup {
+		result.RequestID = result.ID
+	}
+
+	writeJSON(w, result)
+}
+
+type feedbackRequest struct {
+	Event          incognia.FeedbackType `json:"event"`
+	ExpiresAt      *time.Time            `json:"expires_at"`
+	InstallationID string                `json:"installation_id"`
+	RequestToken   string                `json:"request_token"`
+	LoginID        string                `json:"login_id"`
+	PaymentID      string                `json:"payment_id"`
+	SignupID       string                `json:"signup_id"`
+	AccountID      string                `json:"account_id"`
+}
+
+func (e *Emulator) serveFeedback(w http.ResponseWriter, r *http.Request) {
+	req := &feedbackRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !req.Event.IsKnown() {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown feedback event %q", req.Event))
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var keys []string
+	if req.AccountID != "" {
+		keys = append(keys, accountKey(req.AccountID))
+	}
+	for _, device := range []string{req.InstallationID, req.RequestToken} {
+		if device != "" {
+			keys = append(keys, deviceKey(device))
+		}
+	}
+	for _, id := range []string{req.LoginID, req.PaymentID, req.SignupID} {
+		if s, ok := e.subjects[id]; ok {
+			if s.accountID != "" {
+				keys = append(keys, accountKey(s.accountID))
+			}
+			if s.deviceKey != "" {
+				keys = append(keys, deviceKey(s.deviceKey))
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		writeError(w, http.StatusBadRequest, "no known identifier")
+		return
+	}
+
+	for _, key := range keys {
+		if req.Event == incognia.Reset {
+			delete(e.feedbacks, key)
+		} else {
+			e.feedbacks[key] = feedbackRecord{event: req.Event, expiresAt: req.ExpiresAt}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func accountKey(accountID string) string {
+	return "account:" + accountID
+}
+
+func deviceKey(key string) string {
+	return "device:" + key
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// newID returns a random version 4 UUID.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func deviceID(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,236 @@
+package emulator
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia"
+)
+
+const rulesYAML = `
+client_id: client-id
+client_secret: client-secret
+rules:
+  - name: blocked account
+    account_ids: [fraudster]
+    risk: high_risk
+    reasons: [account_takeover]
+  - name: emulated device
+    installation_ids: [emulated-installation]
+    risk: high_risk
+    reasons: [emulator]
+  - name: big payments
+    operations: [payment]
+    min_amount: 5000
+    risk: unknown_risk
+  - name: risky country
+    countries: [ng]
+    risk: high_risk
+  - name: vip
+    custom_properties:
+      tier: vip
+      score: 42
+    risk: low_risk
+    evidence:
+      vip: true
+`
+
+type EmulatorTestSuite struct {
+	suite.Suite
+
+	emulator *Emulator
+	server   *httptest.Server
+	client   *incognia.Client
+}
+
+func (suite *EmulatorTestSuite) SetupTest() {
+	config, err := Parse([]byte(rulesYAML))
+	suite.Require().NoError(err)
+	config.DefaultRisk = incognia.UnknownRisk
+
+	suite.emulator, err = New(config)
+	suite.Require().NoError(err)
+	suite.server = httptest.NewServer(suite.emulator)
+	suite.client = suite.newClient("client-id", "client-secret")
+}
+
+func (suite *EmulatorTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *EmulatorTestSuite) newClient(clientID, clientSecret string) *incognia.Client {
+	client, err := incognia.New(&incognia.IncogniaClientConfig{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		BaseURL:      suite.server.URL,
+	})
+	suite.Require().NoError(err)
+	return client
+}
+
+func (suite *EmulatorTestSuite) login(accountID, installationID string) *incognia.TransactionAssessment {
+	assessment, err := suite.client.RegisterLogin(&incognia.Login{AccountID: accountID, InstallationID: &installationID, DeviceOs: "Android"})
+	suite.Require().NoError(err)
+	return assessment
+}
+
+func (suite *EmulatorTestSuite) TestRulesDecideRisk() {
+	assessment := suite.login("fraudster", "installation")
+	suite.Equal(incognia.HighRisk, assessment.RiskAssessment)
+	suite.Equal([]incognia.Reason{{Code: incognia.ReasonAccountTakeover, Source: incognia.ReasonSourceLocal}}, assessment.Reasons)
+	suite.Equal([]incognia.Action{incognia.ActionBlock}, assessment.Actions)
+
+	assessment = suite.login("account", "emulated-installation")
+	suite.Equal(incognia.HighRisk, assessment.RiskAssessment)
+	var emulated bool
+	suite.NoError(assessment.Evidence.GetEvidence("device_integrity.emulator", &emulated))
+	suite.True(emulated)
+
+	value := 9000.0
+	payment, err := suite.client.RegisterPayment(&incognia.Payment{AccountID: "account", Value: &incognia.PaymentValue{Amount: value, Currency: "BRL"}})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.UnknownRisk, payment.RiskAssessment)
+	suite.Equal([]incognia.Action{incognia.ActionWarnUser}, payment.Actions)
+
+	signup, err := suite.client.RegisterSignupWithParams(&incognia.Signup{
+		InstallationID: "installation",
+		Address:        &incognia.Address{StructuredAddress: &incognia.StructuredAddress{CountryCode: "NG"}},
+	})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.HighRisk, signup.RiskAssessment)
+	suite.Equal(signup.ID, signup.RequestID)
+
+	login, err := suite.client.RegisterLogin(&incognia.Login{AccountID: "account", CustomProperties: map[string]interface{}{"tier": "vip", "score": 42}})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.LowRisk, login.RiskAssessment)
+	suite.Equal(true, login.Evidence["vip"])
+
+	login, err = suite.client.RegisterLogin(&incognia.Login{AccountID: "account", Countries: []string{"BR"}})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.UnknownRisk, login.RiskAssessment)
+}
+
+func (suite *EmulatorTestSuite) TestEvidenceAndSignalsFollowTheDevice() {
+	first := suite.login("account-1", "shared-installation")
+	second := suite.login("account-2", "shared-installation")
+	again := suite.login("account-1", "shared-installation")
+
+	suite.Equal(first.DeviceID, second.DeviceID)
+	suite.NotEqual(first.ID, second.ID)
+	suite.Equal(true, first.Signals["new_device"])
+	suite.Equal(false, second.Signals["new_device"])
+	suite.Equal(float64(2), again.Signals["velocity_last_hour"])
+
+	var known bool
+	suite.NoError(again.Evidence.GetEvidence("known_account", &known))
+	suite.True(known)
+	suite.NoError(second.Evidence.GetEvidence("known_account", &known))
+	suite.False(known)
+	suite.Equal(float64(2), second.Evidence["accounts_by_device"])
+	suite.Equal("Pixel 7", second.Evidence["device_model"])
+	suite.Equal([]incognia.Reason{{Code: incognia.ReasonNewDevice, Source: incognia.ReasonSourceLocal}}, first.Reasons)
+}
+
+func (suite *EmulatorTestSuite) TestFeedbackChangesLaterAssessments() {
+	installationID := "installation"
+	payment, err := suite.client.RegisterPayment(&incognia.Payment{AccountID: "account", InstallationID: &installationID})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.UnknownRisk, payment.RiskAssessment)
+
+	suite.Require().NoError(suite.client.RegisterFeedback(incognia.Chargeback, nil, &incognia.FeedbackIdentifiers{PaymentID: payment.ID}))
+	assessment := suite.login("account", "other-installation")
+	suite.Equal(incognia.HighRisk, assessment.RiskAssessment)
+	suite.Equal([]incognia.Reason{
+		{Code: incognia.ReasonTaggedAccount, Source: incognia.ReasonSourceLocal},
+		{Code: incognia.ReasonChargeback, Source: incognia.ReasonSourceLocal},
+	}, assessment.Reasons)
+	suite.Equal("suspicious", assessment.Evidence["device_fraud_reputation"])
+
+	// The device of the tagged payment is tagged too.
+	assessment = suite.login("another-account", installationID)
+	suite.Equal(incognia.ReasonTaggedDevice, assessment.Reasons[0].Code)
+
+	suite.Require().NoError(suite.client.RegisterFeedback(incognia.Reset, nil, &incognia.FeedbackIdentifiers{AccountID: "account", InstallationID: installationID}))
+	suite.Require().NoError(suite.client.RegisterFeedback(incognia.AccountAllowed, nil, &incognia.FeedbackIdentifiers{AccountID: "account"}))
+	assessment = suite.login("account", installationID)
+	suite.Equal(incognia.LowRisk, assessment.RiskAssessment)
+}
+
+func (suite *EmulatorTestSuite) TestEvalFalseReturnsOnlyTheID() {
+	eval := false
+	assessment, err := suite.client.RegisterLogin(&incognia.Login{AccountID: "fraudster", Eval: &eval})
+	suite.Require().NoError(err)
+	suite.NotEmpty(assessment.ID)
+	suite.Empty(assessment.RiskAssessment)
+}
+
+func (suite *EmulatorTestSuite) TestRequestsAreValidated() {
+	_, err := suite.newClient("client-id", "wrong-secret").RegisterLogin(&incognia.Login{AccountID: "account"})
+	suite.Equal(incognia.ErrInvalidCredentials, err)
+
+	err = suite.client.RegisterFeedback(incognia.FeedbackType("made_up"), nil, &incognia.FeedbackIdentifiers{AccountID: "account"})
+	var apiErr *incognia.APIError
+	suite.Require().True(errors.As(err, &apiErr))
+	suite.Equal(http.StatusBadRequest, apiErr.StatusCode)
+
+	res, err := http.Post(suite.server.URL+"/api/v2/authentication/transactions", "application/json", strings.NewReader(`{}`))
+	suite.Require().NoError(err)
+	res.Body.Close()
+	suite.Equal(http.StatusUnauthorized, res.StatusCode)
+}
+
+func (suite *EmulatorTestSuite) TestCompressedRequests() {
+	client, err := incognia.New(&incognia.IncogniaClientConfig{
+		ClientID:             "client-id",
+		ClientSecret:         "client-secret",
+		BaseURL:              suite.server.URL,
+		CompressRequests:     true,
+		CompressionThreshold: 1,
+	})
+	suite.Require().NoError(err)
+
+	installationID := "installation"
+	assessment, err := client.RegisterLogin(&incognia.Login{AccountID: "fraudster", InstallationID: &installationID})
+	suite.Require().NoError(err)
+	suite.Equal(incognia.HighRisk, assessment.RiskAssessment)
+
+	token, err := client.TokenProvider().GetToken()
+	suite.Require().NoError(err)
+	request, _ := http.NewRequest(http.MethodPost, suite.server.URL+"/api/v2/authentication/transactions", strings.NewReader(`{}`))
+	token.SetAuthHeader(request)
+	request.Header.Set("Content-Encoding", "br")
+	res, err := http.DefaultClient.Do(request)
+	suite.Require().NoError(err)
+	res.Body.Close()
+	suite.Equal(http.StatusUnsupportedMediaType, res.StatusCode)
+}
+
+func (suite *EmulatorTestSuite) TestConfigValidation() {
+	cases := []struct {
+		data     string
+		expected error
+	}{
+		{`default_risk: medium`, ErrUnknownRisk},
+		{`rules: [{risk: high}]`, ErrUnknownRisk},
+		{`rules: [{risk: high_risk, reasons: [bad_vibes]}]`, ErrUnknownReasonCode},
+		{`rules: [{risk: high_risk, operations: [refund]}]`, ErrUnknownOperation},
+		{`rules: [{risk: high_risk, min_amount: 10, max_amount: 1}]`, ErrInvalidAmounts},
+	}
+
+	for _, c := range cases {
+		_, err := Parse([]byte(c.data))
+		suite.True(errors.Is(err, c.expected), "%s: %v", c.data, err)
+	}
+
+	config, err := Parse([]byte(`{"token_expires_in": "1m", "rules": [{"risk": "low_risk"}]}`))
+	suite.Require().NoError(err)
+	suite.Equal("1m0s", config.TokenExpiresIn.String())
+}
+
+func TestEmulatorTestSuite(t *testing.T) {
+	suite.Run(t, new(EmulatorTestSuite))
+}
@@ -1,5 +1,7 @@
 package incognia
 
+import "strings"
+
 const (
 	tokenEndpoint        = "/v2/token"
 	signupsEndpoint      = "/v2/onboarding/signups"
@@ -18,11 +20,16 @@ type endpoints struct {
 	Feedback     string
 }
 
-func getEndpoints() endpoints {
+func getEndpoints(baseURL string) endpoints {
+	base := strings.TrimSuffix(baseURL, "/")
+	if base == "" {
+		base = baseEndpoint
+	}
+
 	return endpoints{
-		Token:        baseEndpoint + tokenEndpoint,
-		Signups:      baseEndpoint + signupsEndpoint,
-		Transactions: baseEndpoint + transactionsEndpoint,
-		Feedback:     baseEndpoint + feedbackEndpoint,
+		Token:        base + tokenEndpoint,
+		Signups:      base + signupsEndpoint,
+		Transactions: base + transactionsEndpoint,
+		Feedback:     base + feedbackEndpoint,
 	}
 }
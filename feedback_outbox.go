@@ -0,0 +1,444 @@
+package incognia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultOutboxMinBackoff   = time.Second
+	defaultOutboxMaxBackoff   = 5 * time.Minute
+	defaultOutboxCompactAfter = 1000
+)
+
+var (
+	ErrMissingOutboxPath    = errors.New("missing outbox path")
+	ErrFeedbackOutboxClosed = errors.New("feedback outbox is closed")
+	ErrMissingOutboxClient  = errors.New("outbox client is required")
+	ErrOutboxConfigIsNil    = errors.New("outbox config is required")
+)
+
+type FeedbackOutboxConfig struct {
+	// Path is the outbox file. It is created when missing.
+	Path string
+	// MinBackoff is the wait after a failed send, doubled after each
+	// consecutive failure up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// CompactAfter is how many sent feedbacks are left in the file before it
+	// is rewritten with only the pending ones.
+	CompactAfter int
+}
+
+// FeedbackOutbox writes feedbacks to a local file before acknowledging them,
+// and sends them from a background goroutine in the order they were
+// registered. Failed sends are retried with backoff, also after a restart.
+// Feedbacks rejected with a 4xx status other than 429 are dropped, since
+// retrying would not help. Feedbacks are delivered at least once: one sent
+// right before a crash may be sent again.
+type FeedbackOutbox struct {
+	// dropped is updated atomically, so it comes first to be 64-bit aligned
+	// on 32-bit platforms.
+	dropped int64
+
+	client *Client
+	config FeedbackOutboxConfig
+
+	mutex     sync.Mutex
+	file      *os.File
+	pending   []*outboxEntry
+	nextID    uint64
+	sentCount int
+	closed    bool
+
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// outboxEntry is a line of the outbox file. A line with Feedback adds a
+// feedback, and a line with Sent removes the one with the same ID.
+type outboxEntry struct {
+	ID         uint64                   `json:"id"`
+	EnqueuedAt time.Time                `json:"enqueued_at,omitempty"`
+	Feedback   *postFeedbackRequestBody `json:"feedback,omitempty"`
+	Sent       bool                     `json:"sent,omitempty"`
+}
+
+// NewFeedbackOutbox loads the feedbacks left pending in the file and starts
+// sending them. The outbox is closed when the client is.
+func NewFeedbackOutbox(client *Client, config *FeedbackOutboxConfig) (*FeedbackOutbox, error) {
+	if client == nil {
+		return nil, ErrMissingOutboxClient
+	}
+
+	if config == nil {
+		return nil, ErrOutboxConfigIsNil
+	}
+
+	if config.Path == "" {
+		return nil, ErrMissingOutboxPath
+	}
+
+	o := &FeedbackOutbox{
+		client: client,
+		config: *config,
+		nextID: 1,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if o.config.MinBackoff <= 0 {
+		o.config.MinBackoff = defaultOutboxMinBackoff
+	}
+	if o.config.MaxBackoff < o.config.MinBackoff {
+		o.config.MaxBackoff = defaultOutboxMaxBackoff
+		if o.config.MaxBackoff < o.config.MinBackoff {
+			o.config.MaxBackoff = o.config.MinBackoff
+		}
+	}
+	if o.config.CompactAfter <= 0 {
+		o.config.CompactAfter = defaultOutboxCompactAfter
+	}
+
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	// Loading drops sent entries, so the file is rewritten right away.
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	if err := client.addCloser(o); err != nil {
+		o.file.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+	go o.run(ctx)
+	o.notify()
+
+	return o, nil
+}
+
+func (o *FeedbackOutbox) load() error {
+	data, err := ioutil.ReadFile(o.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only lines ending in a newline were fully written. A line cut short by
+	// a crash belongs to a feedback that was never acknowledged.
+	lines := bytes.Split(data, []byte("\n"))
+	pending := map[uint64]*outboxEntry{}
+	for i, line := range lines[:len(lines)-1] {
+		if len(line) == 0 {
+			continue
+		}
+
+		entry := &outboxEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", o.config.Path, i+1, err)
+		}
+
+		if entry.ID >= o.nextID {
+			o.nextID = entry.ID + 1
+		}
+
+		if entry.Sent {
+			delete(pending, entry.ID)
+		} else if entry.Feedback != nil {
+			pending[entry.ID] = entry
+		}
+	}
+
+	for _, entry := range pending {
+		o.pending = append(o.pending, entry)
+	}
+	sort.Slice(o.pending, func(i, j int) bool { return o.pending[i].ID < o.pending[j].ID })
+
+	return nil
+}
+
+func (o *FeedbackOutbox) RegisterFeedback(feedbackEvent FeedbackType, occurredAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) error {
+	return o.RegisterFeedbackWithExpiration(feedbackEvent, occurredAt, nil, feedbackIdentifiers)
+}
+
+// RegisterFeedbackWithExpiration returns once the feedback is synced to
+// disk.
+func (o *FeedbackOutbox) RegisterFeedbackWithExpiration(feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) error {
+	requestBody := newFeedbackRequestBody(feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return ErrFeedbackOutboxClosed
+	}
+
+	entry := &outboxEntry{ID: o.nextID, EnqueuedAt: o.client.clock.Now(), Feedback: &requestBody}
+	if err := o.append(entry); err != nil {
+		return err
+	}
+	if err := o.file.Sync(); err != nil {
+		return err
+	}
+
+	o.nextID++
+	o.pending = append(o.pending, entry)
+	o.notify()
+
+	return nil
+}
+
+// Pending returns how many feedbacks are waiting to be sent.
+func (o *FeedbackOutbox) Pending() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return len(o.pending)
+}
+
+// OldestPendingAge returns how long the oldest pending feedback has waited,
+// or zero when none is pending.
+func (o *FeedbackOutbox) OldestPendingAge() time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.pending) == 0 {
+		return 0
+	}
+
+	return o.client.clock.Now().Sub(o.pending[0].EnqueuedAt)
+}
+
+// Dropped returns how many feedbacks were rejected by the API and dropped.
+func (o *FeedbackOutbox) Dropped() int64 {
+	return atomic.LoadInt64(&o.dropped)
+}
+
+// Close stops the sender and closes the file. Pending feedbacks stay in the
+// file and are sent by the next outbox opened on it.
+func (o *FeedbackOutbox) Close() error {
+	o.mutex.Lock()
+	if o.closed {
+		o.mutex.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mutex.Unlock()
+
+	close(o.stop)
+	o.cancel()
+	<-o.done
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.file.Close()
+}
+
+func (o *FeedbackOutbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *FeedbackOutbox) run(ctx context.Context) {
+	defer close(o.done)
+
+	backoff := time.Duration(0)
+	for {
+		entry := o.head()
+		if entry == nil {
+			select {
+			case <-o.wake:
+				continue
+			case <-o.stop:
+				return
+			}
+		}
+
+		err := o.send(ctx, entry)
+		if err == nil || isPermanentFeedbackError(err) {
+			if err != nil {
+				atomic.AddInt64(&o.dropped, 1)
+				o.client.logger.errorf("outbox: dropping feedback %d: %v", entry.ID, err)
+			}
+			if ackErr := o.ack(entry); ackErr != nil {
+				o.client.logger.errorf("outbox: %v", ackErr)
+			}
+			backoff = 0
+			continue
+		}
+
+		backoff *= 2
+		if backoff == 0 {
+			backoff = o.config.MinBackoff
+		}
+		if backoff > o.config.MaxBackoff {
+			backoff = o.config.MaxBackoff
+		}
+		o.client.logger.errorf("outbox: sending feedback %d failed, retrying in %s: %v", entry.ID, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-o.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (o *FeedbackOutbox) head() *outboxEntry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.pending) == 0 {
+		return nil
+	}
+
+	return o.pending[0]
+}
+
+func (o *FeedbackOutbox) send(ctx context.Context, entry *outboxEntry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = o.client.panicError(OperationRegisterFeedback, r)
+		}
+	}()
+
+	return o.client.post(ctx, OperationRegisterFeedback, o.client.endpoints.Feedback, nil, *entry.Feedback, nil)
+}
+
+func isPermanentFeedbackError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode >= http.StatusBadRequest &&
+		apiErr.StatusCode < http.StatusInternalServerError &&
+		apiErr.StatusCode != http.StatusTooManyRequests
+}
+
+func (o *FeedbackOutbox) ack(entry *outboxEntry) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.pending) > 0 && o.pending[0] == entry {
+		o.pending = o.pending[1:]
+	}
+
+	if err := o.append(&outboxEntry{ID: entry.ID, Sent: true}); err != nil {
+		return err
+	}
+
+	o.sentCount++
+	if o.sentCount >= o.config.CompactAfter {
+		return o.compact()
+	}
+
+	return nil
+}
+
+func (o *FeedbackOutbox) append(entry *outboxEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.file.Write(append(line, '\n'))
+	return err
+}
+
+// compact replaces the file with one holding only the pending feedbacks.
+func (o *FeedbackOutbox) compact() error {
+	tmpPath := o.config.Path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	for _, entry := range o.pending {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+
+	if _, err := tmp.Write(buffer.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, o.config.Path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(o.config.Path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(o.config.Path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = file
+	o.sentCount = 0
+
+	return nil
+}
+
+// syncDir flushes a rename in dir to disk, so a compacted file is not lost in
+// a crash. Windows cannot sync directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
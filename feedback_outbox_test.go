@@ -0,0 +1,200 @@
+package incognia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia/incogniatest"
+)
+
+type FeedbackOutboxTestSuite struct {
+	suite.Suite
+
+	client         *Client
+	tokenServer    *httptest.Server
+	feedbackServer *httptest.Server
+	dir            string
+	path           string
+
+	statusCode int32
+	mutex      sync.Mutex
+	received   []string
+}
+
+func (suite *FeedbackOutboxTestSuite) SetupTest() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusOK)
+	suite.received = nil
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.feedbackServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode := int(atomic.LoadInt32(&suite.statusCode))
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		var body postFeedbackRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+		suite.received = append(suite.received, body.AccountID)
+	}))
+
+	suite.client, _ = New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, LogLevel: LogLevelOff})
+	suite.client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	suite.client.endpoints.Feedback = suite.feedbackServer.URL
+
+	var err error
+	suite.dir, err = ioutil.TempDir("", "outbox")
+	suite.Require().NoError(err)
+	suite.path = filepath.Join(suite.dir, "feedbacks.outbox")
+}
+
+func (suite *FeedbackOutboxTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.feedbackServer.Close()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *FeedbackOutboxTestSuite) newOutbox(compactAfter int) *FeedbackOutbox {
+	outbox, err := NewFeedbackOutbox(suite.client, &FeedbackOutboxConfig{
+		Path:         suite.path,
+		MinBackoff:   5 * time.Millisecond,
+		MaxBackoff:   20 * time.Millisecond,
+		CompactAfter: compactAfter,
+	})
+	suite.Require().NoError(err)
+	return outbox
+}
+
+func (suite *FeedbackOutboxTestSuite) receivedAccounts() []string {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	return append([]string(nil), suite.received...)
+}
+
+func (suite *FeedbackOutboxTestSuite) register(outbox *FeedbackOutbox, accountID string) {
+	suite.Require().NoError(outbox.RegisterFeedback(AccountTakeover, &now, &FeedbackIdentifiers{AccountID: accountID}))
+}
+
+func (suite *FeedbackOutboxTestSuite) TestFeedbacksAreSentInOrder() {
+	outbox := suite.newOutbox(0)
+	defer outbox.Close()
+
+	for _, account := range []string{"a", "b", "c"} {
+		suite.register(outbox, account)
+	}
+
+	suite.Eventually(func() bool { return outbox.Pending() == 0 }, time.Second, time.Millisecond)
+	suite.Equal([]string{"a", "b", "c"}, suite.receivedAccounts())
+	suite.Equal(time.Duration(0), outbox.OldestPendingAge())
+}
+
+func (suite *FeedbackOutboxTestSuite) TestFeedbacksWaitForOutageToEnd() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusServiceUnavailable)
+	outbox := suite.newOutbox(0)
+	defer outbox.Close()
+
+	suite.register(outbox, "a")
+	suite.register(outbox, "b")
+	time.Sleep(30 * time.Millisecond)
+
+	suite.Equal(2, outbox.Pending())
+	suite.True(outbox.OldestPendingAge() >= 30*time.Millisecond)
+	suite.Empty(suite.receivedAccounts())
+
+	atomic.StoreInt32(&suite.statusCode, http.StatusOK)
+	suite.Eventually(func() bool { return outbox.Pending() == 0 }, time.Second, time.Millisecond)
+	suite.Equal([]string{"a", "b"}, suite.receivedAccounts())
+}
+
+func (suite *FeedbackOutboxTestSuite) TestPendingAgeUsesClientClock() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusServiceUnavailable)
+	clock := incogniatest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	suite.client.clock = clock
+	outbox := suite.newOutbox(0)
+	defer outbox.Close()
+
+	suite.register(outbox, "a")
+	clock.Advance(time.Hour)
+	suite.Equal(time.Hour, outbox.OldestPendingAge())
+}
+
+func (suite *FeedbackOutboxTestSuite) TestPendingFeedbacksSurviveRestart() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusInternalServerError)
+	outbox := suite.newOutbox(0)
+	suite.register(outbox, "a")
+	suite.register(outbox, "b")
+	suite.NoError(outbox.Close())
+	suite.Equal(ErrFeedbackOutboxClosed, outbox.RegisterFeedback(AccountTakeover, nil, nil))
+
+	// A line cut short by a crash is ignored.
+	file, err := os.OpenFile(suite.path, os.O_WRONLY|os.O_APPEND, 0600)
+	suite.Require().NoError(err)
+	file.WriteString(`{"id":3,"feedback":{"event":"acc`)
+	file.Close()
+
+	atomic.StoreInt32(&suite.statusCode, http.StatusOK)
+	restarted := suite.newOutbox(0)
+	defer restarted.Close()
+
+	suite.Eventually(func() bool { return restarted.Pending() == 0 }, time.Second, time.Millisecond)
+	suite.Equal([]string{"a", "b"}, suite.receivedAccounts())
+
+	suite.register(restarted, "c")
+	suite.Eventually(func() bool { return restarted.Pending() == 0 }, time.Second, time.Millisecond)
+	suite.Equal([]string{"a", "b", "c"}, suite.receivedAccounts())
+}
+
+func (suite *FeedbackOutboxTestSuite) TestRejectedFeedbacksAreDropped() {
+	atomic.StoreInt32(&suite.statusCode, http.StatusBadRequest)
+	outbox := suite.newOutbox(0)
+	defer outbox.Close()
+
+	suite.register(outbox, "a")
+
+	suite.Eventually(func() bool { return outbox.Pending() == 0 }, time.Second, time.Millisecond)
+	suite.Equal(int64(1), outbox.Dropped())
+}
+
+func (suite *FeedbackOutboxTestSuite) TestFileIsCompacted() {
+	outbox := suite.newOutbox(2)
+	for _, account := range []string{"a", "b"} {
+		suite.register(outbox, account)
+		suite.Eventually(func() bool { return outbox.Pending() == 0 }, time.Second, time.Millisecond)
+	}
+
+	atomic.StoreInt32(&suite.statusCode, http.StatusServiceUnavailable)
+	suite.register(outbox, "c")
+	suite.NoError(outbox.Close())
+
+	data, err := ioutil.ReadFile(suite.path)
+	suite.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	suite.Require().Len(lines, 1)
+	suite.Contains(lines[0], `"account_id":"c"`)
+}
+
+func (suite *FeedbackOutboxTestSuite) TestConfigValidation() {
+	_, err := NewFeedbackOutbox(nil, &FeedbackOutboxConfig{Path: suite.path})
+	suite.Equal(ErrMissingOutboxClient, err)
+	_, err = NewFeedbackOutbox(suite.client, nil)
+	suite.Equal(ErrOutboxConfigIsNil, err)
+	_, err = NewFeedbackOutbox(suite.client, &FeedbackOutboxConfig{})
+	suite.Equal(ErrMissingOutboxPath, err)
+}
+
+func TestFeedbackOutboxTestSuite(t *testing.T) {
+	suite.Run(t, new(FeedbackOutboxTestSuite))
+}
@@ -0,0 +1,341 @@
+package feedbackimport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia"
+)
+
+const mappingYAML = `
+event:
+  column: reason
+  values:
+    "4837": chargeback
+    fraud: identity_fraud
+occurred_at:
+  column: dispute_date
+  layout: "2006-01-02"
+  timezone: America/Sao_Paulo
+expires_after: 720h
+identifiers:
+  payment_id: transaction
+  account_id: customer
+  person_id:
+    type: cpf
+    column: document
+`
+
+const chargebacksCSV = `reason,dispute_date,transaction,customer,document
+4837,2024-03-01,payment-1,account-1,12345678901
+fraud,2024-03-02,payment-2,account-2,
+unknown,2024-03-03,payment-3,account-3,
+4837,03/04/2024,payment-4,account-4,
+4837,2024-03-05,,,
+4837,2024-03-06,payment-6,account-6,
+`
+
+type FeedbackImportTestSuite struct {
+	suite.Suite
+
+	server *httptest.Server
+	client *incognia.Client
+	dir    string
+
+	mutex      sync.Mutex
+	received   []map[string]interface{}
+	failFirst  map[string]bool
+	onFeedback func()
+}
+
+// notifyingRows closes read once the row numbered row has been read.
+type notifyingRows struct {
+	Rows
+	row  int
+	read chan struct{}
+}
+
+func (r *notifyingRows) Next() (Row, error) {
+	row, err := r.Rows.Next()
+	if err == nil && row.Number == r.row {
+		close(r.read)
+	}
+	return row, err
+}
+
+func (suite *FeedbackImportTestSuite) SetupTest() {
+	suite.received = nil
+	suite.failFirst = map[string]bool{}
+	suite.onFeedback = nil
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			w.Write([]byte(`{"access_token":"token","expires_in":"900","token_type":"Bearer"}`))
+			return
+		}
+
+		if suite.onFeedback != nil {
+			suite.onFeedback()
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+
+		paymentID, _ := body["payment_id"].(string)
+		if suite.failFirst[paymentID] {
+			delete(suite.failFirst, paymentID)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		suite.received = append(suite.received, body)
+	}))
+
+	var err error
+	suite.client, err = incognia.New(&incognia.IncogniaClientConfig{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		BaseURL:      suite.server.URL,
+	})
+	suite.Require().NoError(err)
+
+	suite.dir, err = ioutil.TempDir("", "feedbackimport")
+	suite.Require().NoError(err)
+}
+
+func (suite *FeedbackImportTestSuite) TearDownTest() {
+	suite.server.Close()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *FeedbackImportTestSuite) mapping() *Mapping {
+	mapping, err := Parse([]byte(mappingYAML), YAML)
+	suite.Require().NoError(err)
+	return mapping
+}
+
+func (suite *FeedbackImportTestSuite) csvRows() Rows {
+	rows, err := NewCSVRows(strings.NewReader(chargebacksCSV))
+	suite.Require().NoError(err)
+	return rows
+}
+
+func (suite *FeedbackImportTestSuite) TestImportReportsEveryRow() {
+	report, err := Import(context.Background(), &Config{
+		Client:    suite.client,
+		Mapping:   suite.mapping(),
+		Workers:   3,
+		RateLimit: 1000,
+	}, suite.csvRows())
+	suite.Require().NoError(err)
+
+	suite.Equal(3, report.Sent)
+	suite.Equal(3, report.Invalid)
+	suite.Equal(0, report.Failed)
+	suite.Require().Len(report.Results, 6)
+
+	suite.Equal(Result{Row: 1, Status: StatusSent, Event: incognia.Chargeback}, report.Results[0])
+	suite.Equal(incognia.IdentityFraud, report.Results[1].Event)
+	suite.Equal(`reason: unknown feedback type "unknown"`, report.Results[2].Error)
+	suite.Contains(report.Results[3].Error, "dispute_date")
+	suite.Equal("no identifier is present", report.Results[4].Error)
+
+	suite.Require().Len(suite.received, 3)
+	var first map[string]interface{}
+	for _, body := range suite.received {
+		if body["payment_id"] == "payment-1" {
+			first = body
+		}
+	}
+	suite.Require().NotNil(first)
+	suite.Equal("chargeback", first["event"])
+	suite.Equal("account-1", first["account_id"])
+	suite.Equal(map[string]interface{}{"type": "cpf", "value": "12345678901"}, first["person_id"])
+	suite.Equal("2024-03-01T00:00:00-03:00", first["occurred_at"])
+	suite.Equal("2024-03-31T00:00:00-03:00", first["expires_at"])
+
+	var out bytes.Buffer
+	suite.NoError(report.WriteCSV(&out))
+	suite.True(strings.HasPrefix(out.String(), "row,status,event,error\n1,sent,chargeback,\n"))
+}
+
+func (suite *FeedbackImportTestSuite) TestImportResumesFromCheckpoint() {
+	checkpointPath := filepath.Join(suite.dir, "checkpoint")
+	suite.failFirst["payment-6"] = true
+	inputPath := filepath.Join(suite.dir, "chargebacks.csv")
+	suite.Require().NoError(ioutil.WriteFile(inputPath, []byte(chargebacksCSV), 0644))
+	fingerprint, err := FileFingerprint(inputPath)
+	suite.Require().NoError(err)
+	config := &Config{Client: suite.client, Mapping: suite.mapping(), CheckpointPath: checkpointPath, InputFingerprint: fingerprint}
+
+	report, err := Import(context.Background(), config, suite.csvRows())
+	suite.Require().NoError(err)
+	suite.Equal(2, report.Sent)
+	suite.Equal(1, report.Failed)
+
+	report, err = Import(context.Background(), config, suite.csvRows())
+	suite.Require().NoError(err)
+	suite.Equal(1, report.Sent)
+	suite.Equal(2, report.Skipped)
+	suite.Equal(StatusSent, report.Results[5].Status)
+	suite.Len(suite.received, 3)
+}
+
+func (suite *FeedbackImportTestSuite) TestCheckpointOfChangedInputIsRejected() {
+	checkpointPath := filepath.Join(suite.dir, "checkpoint")
+	inputPath := filepath.Join(suite.dir, "chargebacks.csv")
+	suite.Require().NoError(ioutil.WriteFile(inputPath, []byte(chargebacksCSV), 0644))
+	fingerprint, _ := FileFingerprint(inputPath)
+
+	_, err := Import(context.Background(), &Config{Client: suite.client, Mapping: suite.mapping(), CheckpointPath: checkpointPath}, suite.csvRows())
+	suite.Equal(ErrMissingInputFingerprint, err)
+
+	_, err = Import(context.Background(), &Config{Client: suite.client, Mapping: suite.mapping(), CheckpointPath: checkpointPath, InputFingerprint: fingerprint}, suite.csvRows())
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(ioutil.WriteFile(inputPath, []byte(chargebacksCSV+"4837,2024-03-07,payment-7,account-7,\n"), 0644))
+	changed, _ := FileFingerprint(inputPath)
+	suite.NotEqual(fingerprint, changed)
+
+	sent := len(suite.received)
+	_, err = Import(context.Background(), &Config{Client: suite.client, Mapping: suite.mapping(), CheckpointPath: checkpointPath, InputFingerprint: changed}, suite.csvRows())
+	suite.True(errors.Is(err, ErrCheckpointMismatch))
+	suite.Len(suite.received, sent, "nothing is sent")
+}
+
+func (suite *FeedbackImportTestSuite) TestCheckpointIgnoresTruncatedLine() {
+	checkpointPath := filepath.Join(suite.dir, "checkpoint")
+	suite.Require().NoError(ioutil.WriteFile(checkpointPath, []byte("input sha256:abc\n2\n1"), 0644))
+
+	checkpoint, err := openCheckpoint(checkpointPath, "sha256:abc")
+	suite.Require().NoError(err)
+	suite.True(checkpoint.done(2))
+	suite.False(checkpoint.done(1))
+	suite.NoError(checkpoint.add(6))
+	suite.NoError(checkpoint.close())
+
+	data, err := ioutil.ReadFile(checkpointPath)
+	suite.Require().NoError(err)
+	suite.Equal("input sha256:abc\n2\n1\n6\n", string(data))
+
+	suite.Require().NoError(ioutil.WriteFile(checkpointPath, []byte("inp"), 0644))
+	checkpoint, err = openCheckpoint(checkpointPath, "sha256:abc")
+	suite.Require().NoError(err)
+	suite.NoError(checkpoint.close())
+	data, _ = ioutil.ReadFile(checkpointPath)
+	suite.Equal("input sha256:abc\n", string(data), "a truncated header is rewritten")
+}
+
+func (suite *FeedbackImportTestSuite) TestImportJSONLines() {
+	input := `{"reason":"4837","dispute_date":"2024-03-01","transaction":"payment-1","customer":42}
+
+{"reason":"fraud","dispute_date":null,"transaction":"payment-2"}
+not json
+`
+	report, err := Import(context.Background(), &Config{Client: suite.client, Mapping: suite.mapping()}, NewJSONLinesRows(strings.NewReader(input)))
+	suite.Require().NoError(err)
+	suite.Equal(2, report.Sent)
+	suite.Equal(1, report.Invalid)
+	suite.Equal(3, report.Results[2].Row)
+
+	for _, body := range suite.received {
+		if body["payment_id"] == "payment-1" {
+			suite.Equal("42", body["account_id"])
+		}
+	}
+}
+
+func (suite *FeedbackImportTestSuite) TestImportChecksColumns() {
+	rows, err := NewCSVRows(strings.NewReader("reason,transaction\n4837,payment-1\n"))
+	suite.Require().NoError(err)
+
+	_, err = Import(context.Background(), &Config{Client: suite.client, Mapping: suite.mapping()}, rows)
+	suite.True(errors.Is(err, ErrMissingColumns))
+	suite.Contains(err.Error(), "dispute_date, customer, document")
+}
+
+func (suite *FeedbackImportTestSuite) TestImportStopsWhenContextIsDone() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := Import(ctx, &Config{Client: suite.client, Mapping: suite.mapping()}, suite.csvRows())
+	suite.Equal(context.Canceled, err)
+	suite.Empty(report.Results)
+	suite.Empty(suite.received)
+}
+
+func (suite *FeedbackImportTestSuite) TestRowBeingDispatchedFailsWhenContextIsDone() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rows := &notifyingRows{Rows: suite.csvRows(), row: 2, read: make(chan struct{})}
+	suite.onFeedback = func() {
+		<-rows.read
+		cancel()
+	}
+
+	report, err := Import(ctx, &Config{Client: suite.client, Mapping: suite.mapping(), Workers: 1}, rows)
+	suite.Equal(context.Canceled, err)
+
+	var second *Result
+	for i := range report.Results {
+		if report.Results[i].Row == 2 {
+			second = &report.Results[i]
+		}
+	}
+	suite.Require().NotNil(second)
+	suite.Equal(StatusFailed, second.Status)
+	suite.Equal(incognia.IdentityFraud, second.Event)
+	suite.Contains(second.Error, context.Canceled.Error())
+}
+
+func (suite *FeedbackImportTestSuite) TestMappingValidation() {
+	cases := []struct {
+		data     string
+		expected error
+	}{
+		{`{"identifiers":{"account_id":"a"}}`, ErrMissingEvent},
+		{`{"event":{"default":"nope"},"identifiers":{"account_id":"a"}}`, ErrUnknownFeedbackType},
+		{`{"event":{"column":"e","values":{"x":"nope"}},"identifiers":{"account_id":"a"}}`, ErrUnknownFeedbackType},
+		{`{"event":{"default":"chargeback"}}`, ErrMissingIdentifiers},
+		{`{"event":{"default":"chargeback"},"expires_after":"1h","identifiers":{"account_id":"a"}}`, ErrMissingOccurredAt},
+		{`{"event":{"default":"chargeback"},"identifiers":{"person_id":{"column":"doc"}}}`, ErrMissingPersonIDColumn},
+		{`{"event":{"default":"chargeback"},"occurred_at":{"column":"o"},"expires_at":{"column":"e"},"expires_after":"1h","identifiers":{"account_id":"a"}}`, ErrInvalidExpiration},
+	}
+
+	for _, c := range cases {
+		_, err := Parse([]byte(c.data), JSON)
+		suite.True(errors.Is(err, c.expected), "%s: %v", c.data, err)
+	}
+
+	_, err := Parse([]byte(`{}`), "toml")
+	suite.True(errors.Is(err, ErrUnknownFormat))
+}
+
+func (suite *FeedbackImportTestSuite) TestUnixTimes() {
+	mapping, err := Parse([]byte(`{"event":{"default":"verified"},"occurred_at":{"column":"at","layout":"unix"},"identifiers":{"account_id":"a"}}`), JSON)
+	suite.Require().NoError(err)
+
+	feedback, err := mapping.Feedback(Row{Number: 1, Values: map[string]string{"at": "1700000000", "a": "account"}})
+	suite.Require().NoError(err)
+	suite.Equal(time.Unix(1700000000, 0).UTC(), *feedback.OccurredAt)
+	suite.Nil(feedback.ExpiresAt)
+	suite.Equal(incognia.Verified, feedback.Event)
+}
+
+func TestFeedbackImportTestSuite(t *testing.T) {
+	suite.Run(t, new(FeedbackImportTestSuite))
+}
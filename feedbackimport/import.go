@@ -0,0 +1,386 @@
+package feedbackimport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"repo.incognia.com/go/incognia"
+	"repo.incognia.com/go/incognia/internal/ratelimit"
+)
+
+var (
+	ErrConfigIsNil             = errors.New("import config is required")
+	ErrMissingClient           = errors.New("missing client")
+	ErrMissingMapping          = errors.New("missing mapping")
+	ErrMissingColumns          = errors.New("input is missing mapped columns")
+	ErrMissingInputFingerprint = errors.New("a checkpoint requires the input fingerprint")
+	ErrCheckpointMismatch      = errors.New("checkpoint belongs to another input")
+)
+
+type Status string
+
+const (
+	StatusSent    Status = "sent"
+	StatusInvalid Status = "invalid"
+	StatusFailed  Status = "failed"
+	// StatusSkipped marks rows already sent according to the checkpoint.
+	StatusSkipped Status = "skipped"
+)
+
+type Config struct {
+	Client  *incognia.Client
+	Mapping *Mapping
+	// Workers is how many feedbacks are in flight at once. Defaults to 1.
+	Workers int
+	// RateLimit is the maximum feedbacks per second. Zero means no limit.
+	RateLimit float64
+	// CheckpointPath, when set, names a file where sent row numbers are
+	// appended. Rows found there are skipped, so an interrupted import can be
+	// run again on the same input.
+	CheckpointPath string
+	// InputFingerprint identifies the input, for instance with
+	// FileFingerprint, and is required with CheckpointPath. It is stored in
+	// the checkpoint, and resuming with another one fails with
+	// ErrCheckpointMismatch, since row numbers would not match anymore.
+	InputFingerprint string
+}
+
+type Result struct {
+	Row    int                   `json:"row"`
+	Status Status                `json:"status"`
+	Event  incognia.FeedbackType `json:"event,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+type Report struct {
+	Sent    int      `json:"sent"`
+	Invalid int      `json:"invalid"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Results []Result `json:"results"`
+}
+
+type job struct {
+	row      int
+	feedback *Feedback
+}
+
+// Import validates every row and registers the valid ones. Invalid rows and
+// failed calls are reported in their results and do not stop the import.
+// Reading errors other than RowError stop it, as does ctx being done; the
+// report of the rows handled so far is returned along with the error. A valid
+// row that was read but not sent when ctx is done fails with the context
+// error.
+func Import(ctx context.Context, config *Config, rows Rows) (*Report, error) {
+	if config == nil {
+		return nil, ErrConfigIsNil
+	}
+
+	if config.Client == nil {
+		return nil, ErrMissingClient
+	}
+
+	if config.Mapping == nil {
+		return nil, ErrMissingMapping
+	}
+
+	if err := config.Mapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	if withColumns, ok := rows.(interface{ Columns() []string }); ok {
+		if missing := missingColumns(config.Mapping.Columns(), withColumns.Columns()); len(missing) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMissingColumns, strings.Join(missing, ", "))
+		}
+	}
+
+	if config.CheckpointPath != "" && config.InputFingerprint == "" {
+		return nil, ErrMissingInputFingerprint
+	}
+
+	checkpoint, err := openCheckpoint(config.CheckpointPath, config.InputFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer checkpoint.close()
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	limiter := ratelimit.New(config.RateLimit, 1)
+
+	var mutex sync.Mutex
+	var results []Result
+	addResult := func(result Result) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+	}
+
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				addResult(send(ctx, config.Client, limiter, checkpoint, j))
+			}
+		}()
+	}
+
+	readErr := dispatch(ctx, config.Mapping, rows, checkpoint, jobs, addResult)
+	close(jobs)
+	wg.Wait()
+
+	if readErr == nil {
+		readErr = ctx.Err()
+	}
+
+	return newReport(results), readErr
+}
+
+func dispatch(ctx context.Context, mapping *Mapping, rows Rows, checkpoint *checkpoint, jobs chan<- job, addResult func(Result)) error {
+	for ctx.Err() == nil {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			addResult(Result{Row: rowErr.Number, Status: StatusInvalid, Error: rowErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if checkpoint.done(row.Number) {
+			addResult(Result{Row: row.Number, Status: StatusSkipped})
+			continue
+		}
+
+		feedback, err := mapping.Feedback(row)
+		if err != nil {
+			addResult(Result{Row: row.Number, Status: StatusInvalid, Error: err.Error()})
+			continue
+		}
+
+		select {
+		case jobs <- job{row: row.Number, feedback: feedback}:
+		case <-ctx.Done():
+			addResult(Result{Row: row.Number, Status: StatusFailed, Event: feedback.Event, Error: ctx.Err().Error()})
+		}
+	}
+
+	return nil
+}
+
+func send(ctx context.Context, client *incognia.Client, limiter *ratelimit.Limiter, checkpoint *checkpoint, j job) Result {
+	result := Result{Row: j.row, Event: j.feedback.Event}
+
+	if err := limiter.Wait(ctx); err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	feedback := j.feedback
+	if err := client.RegisterFeedbackWithContext(ctx, feedback.Event, feedback.OccurredAt, feedback.ExpiresAt, feedback.Identifiers); err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = StatusSent
+	if err := checkpoint.add(j.row); err != nil {
+		result.Error = fmt.Sprintf("checkpoint: %v", err)
+	}
+
+	return result
+}
+
+func missingColumns(mapped, available []string) []string {
+	present := map[string]bool{}
+	for _, column := range available {
+		present[column] = true
+	}
+
+	var missing []string
+	for _, column := range mapped {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+
+	return missing
+}
+
+func newReport(results []Result) *Report {
+	sort.Slice(results, func(i, j int) bool { return results[i].Row < results[j].Row })
+
+	report := &Report{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case StatusSent:
+			report.Sent++
+		case StatusInvalid:
+			report.Invalid++
+		case StatusFailed:
+			report.Failed++
+		case StatusSkipped:
+			report.Skipped++
+		}
+	}
+
+	return report
+}
+
+// WriteCSV writes one line per row with its number, status, event and error.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"row", "status", "event", "error"})
+	for _, result := range r.Results {
+		writer.Write([]string{strconv.Itoa(result.Row), string(result.Status), string(result.Event), result.Error})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// FileFingerprint returns the SHA-256 of the file at path, as a
+// Config.InputFingerprint.
+func FileFingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+const checkpointHeaderPrefix = "input "
+
+// checkpoint is an append-only list of sent row numbers, after a header line
+// with the input fingerprint. Every line is synced before add returns, so a
+// row reported as sent is not sent again after a crash. A nil checkpoint
+// records nothing.
+type checkpoint struct {
+	mutex sync.Mutex
+	file  *os.File
+	sent  map[int]bool
+}
+
+func openCheckpoint(path, fingerprint string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header := checkpointHeaderPrefix + fingerprint + "\n"
+	if end := strings.IndexByte(string(data), '\n'); end < 0 {
+		// A new checkpoint, or one whose header was cut short by a crash
+		// before any row was added.
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if _, err := file.WriteString(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		data = nil
+	} else if string(data[:end+1]) != header {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrCheckpointMismatch)
+	} else {
+		data = data[end+1:]
+	}
+
+	// Only lines ending in a newline were fully written. A line cut short by
+	// a crash is ignored, its row sent again, and ended so it doesn't merge
+	// with the next entry.
+	lines := strings.Split(string(data), "\n")
+	if tail := lines[len(lines)-1]; tail != "" {
+		if _, err := file.WriteString("\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	c := &checkpoint{file: file, sent: map[int]bool{}}
+	for _, line := range lines[:len(lines)-1] {
+		if row, err := strconv.Atoi(line); err == nil {
+			c.sent[row] = true
+		}
+	}
+
+	return c, nil
+}
+
+func (c *checkpoint) done(row int) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sent[row]
+}
+
+func (c *checkpoint) add(row int) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sent[row] = true
+	if _, err := fmt.Fprintf(c.file, "%d\n", row); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+
+	return c.file.Close()
+}
@@ -0,0 +1,344 @@
+// Package feedbackimport registers feedbacks in bulk from CSV or JSON lines
+// files, mapping their columns onto feedback fields.
+package feedbackimport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"repo.incognia.com/go/incognia"
+)
+
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// LayoutUnix parses times given in seconds since the Unix epoch.
+const LayoutUnix = "unix"
+
+var (
+	ErrUnknownFormat         = errors.New("unknown mapping format")
+	ErrMissingEvent          = errors.New("event needs a column or a default")
+	ErrUnknownFeedbackType   = errors.New("unknown feedback type")
+	ErrMissingOccurredAt     = errors.New("occurred_at needs a column")
+	ErrInvalidExpiration     = errors.New("set either expires_at or expires_after")
+	ErrMissingIdentifiers    = errors.New("map at least one identifier")
+	ErrMissingPersonIDColumn = errors.New("person_id needs a type and a column")
+)
+
+// Mapping tells which columns hold each feedback field.
+type Mapping struct {
+	Event      EventMapping `json:"event" yaml:"event"`
+	OccurredAt *TimeMapping `json:"occurred_at,omitempty" yaml:"occurred_at,omitempty"`
+	ExpiresAt  *TimeMapping `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	// ExpiresAfter sets the expiration relative to the occurrence, such as
+	// "720h". It requires OccurredAt.
+	ExpiresAfter string             `json:"expires_after,omitempty" yaml:"expires_after,omitempty"`
+	Identifiers  IdentifiersMapping `json:"identifiers" yaml:"identifiers"`
+
+	expiresAfter time.Duration
+}
+
+// EventMapping reads the feedback type from Column, translating it through
+// Values when the value is listed there. Default is used when the column is
+// not set or the value is empty.
+type EventMapping struct {
+	Column  string                           `json:"column,omitempty" yaml:"column,omitempty"`
+	Values  map[string]incognia.FeedbackType `json:"values,omitempty" yaml:"values,omitempty"`
+	Default incognia.FeedbackType            `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// TimeMapping reads a time from Column using a Go time layout, RFC 3339 by
+// default, or LayoutUnix. Timezone is an IANA name used for layouts without
+// a zone, UTC by default.
+type TimeMapping struct {
+	Column   string `json:"column" yaml:"column"`
+	Layout   string `json:"layout,omitempty" yaml:"layout,omitempty"`
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+
+	location *time.Location
+}
+
+// IdentifiersMapping names the column of each identifier. Empty fields are
+// not sent.
+type IdentifiersMapping struct {
+	InstallationID string           `json:"installation_id,omitempty" yaml:"installation_id,omitempty"`
+	SessionToken   string           `json:"session_token,omitempty" yaml:"session_token,omitempty"`
+	RequestToken   string           `json:"request_token,omitempty" yaml:"request_token,omitempty"`
+	LoginID        string           `json:"login_id,omitempty" yaml:"login_id,omitempty"`
+	PaymentID      string           `json:"payment_id,omitempty" yaml:"payment_id,omitempty"`
+	SignupID       string           `json:"signup_id,omitempty" yaml:"signup_id,omitempty"`
+	AccountID      string           `json:"account_id,omitempty" yaml:"account_id,omitempty"`
+	ExternalID     string           `json:"external_id,omitempty" yaml:"external_id,omitempty"`
+	PersonID       *PersonIDMapping `json:"person_id,omitempty" yaml:"person_id,omitempty"`
+}
+
+// PersonIDMapping reads the person ID value from Column. Type is fixed, such
+// as "cpf".
+type PersonIDMapping struct {
+	Type   string `json:"type" yaml:"type"`
+	Column string `json:"column" yaml:"column"`
+}
+
+// Feedback holds the arguments of incognia.Client.RegisterFeedbackWithExpiration.
+type Feedback struct {
+	Event       incognia.FeedbackType
+	OccurredAt  *time.Time
+	ExpiresAt   *time.Time
+	Identifiers *incognia.FeedbackIdentifiers
+}
+
+func Parse(data []byte, format Format) (*Mapping, error) {
+	var mapping Mapping
+
+	switch format {
+	case JSON:
+		if err := json.Unmarshal(data, &mapping); err != nil {
+			return nil, err
+		}
+	case YAML:
+		if err := yaml.Unmarshal(data, &mapping); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &mapping, nil
+}
+
+// LoadMapping reads a mapping file, picking the format from its extension.
+func LoadMapping(path string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return Parse(data, JSON)
+	case ".yaml", ".yml":
+		return Parse(data, YAML)
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, filepath.Ext(path))
+}
+
+func (m *Mapping) Validate() error {
+	if m.Event.Column == "" && m.Event.Default == "" {
+		return fmt.Errorf("event: %w", ErrMissingEvent)
+	}
+
+	if m.Event.Default != "" && !m.Event.Default.IsKnown() {
+		return fmt.Errorf("event.default: %w %q", ErrUnknownFeedbackType, m.Event.Default)
+	}
+
+	for value, feedbackType := range m.Event.Values {
+		if !feedbackType.IsKnown() {
+			return fmt.Errorf("event.values.%s: %w %q", value, ErrUnknownFeedbackType, feedbackType)
+		}
+	}
+
+	if m.OccurredAt != nil {
+		if err := m.OccurredAt.validate(); err != nil {
+			return fmt.Errorf("occurred_at: %w", err)
+		}
+	}
+
+	if m.ExpiresAt != nil {
+		if m.ExpiresAfter != "" {
+			return ErrInvalidExpiration
+		}
+		if err := m.ExpiresAt.validate(); err != nil {
+			return fmt.Errorf("expires_at: %w", err)
+		}
+	}
+
+	if m.ExpiresAfter != "" {
+		if m.OccurredAt == nil {
+			return fmt.Errorf("expires_after: %w", ErrMissingOccurredAt)
+		}
+
+		duration, err := time.ParseDuration(m.ExpiresAfter)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("expires_after: %q is not a positive duration", m.ExpiresAfter)
+		}
+		m.expiresAfter = duration
+	}
+
+	if person := m.Identifiers.PersonID; person != nil && (person.Type == "" || person.Column == "") {
+		return fmt.Errorf("identifiers.person_id: %w", ErrMissingPersonIDColumn)
+	}
+
+	if len(m.identifierColumns()) == 0 {
+		return fmt.Errorf("identifiers: %w", ErrMissingIdentifiers)
+	}
+
+	return nil
+}
+
+func (t *TimeMapping) validate() error {
+	if t.Column == "" {
+		return errors.New("missing column")
+	}
+
+	t.location = time.UTC
+	if t.Timezone != "" {
+		location, err := time.LoadLocation(t.Timezone)
+		if err != nil {
+			return err
+		}
+		t.location = location
+	}
+
+	return nil
+}
+
+// Columns lists every column the mapping reads.
+func (m *Mapping) Columns() []string {
+	var columns []string
+	if m.Event.Column != "" {
+		columns = append(columns, m.Event.Column)
+	}
+	if m.OccurredAt != nil {
+		columns = append(columns, m.OccurredAt.Column)
+	}
+	if m.ExpiresAt != nil {
+		columns = append(columns, m.ExpiresAt.Column)
+	}
+
+	return append(columns, m.identifierColumns()...)
+}
+
+func (m *Mapping) identifierColumns() []string {
+	var columns []string
+
+	ids := m.Identifiers
+	for _, column := range []string{ids.InstallationID, ids.SessionToken, ids.RequestToken, ids.LoginID, ids.PaymentID, ids.SignupID, ids.AccountID, ids.ExternalID} {
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+
+	if ids.PersonID != nil {
+		columns = append(columns, ids.PersonID.Column)
+	}
+
+	return columns
+}
+
+// Feedback maps a row onto a feedback, checking its event type, times and
+// that at least one identifier is present.
+func (m *Mapping) Feedback(row Row) (*Feedback, error) {
+	feedback := &Feedback{}
+
+	value := row.Values[m.Event.Column]
+	switch {
+	case value == "":
+		feedback.Event = m.Event.Default
+	case m.Event.Values[value] != "":
+		feedback.Event = m.Event.Values[value]
+	default:
+		feedback.Event = incognia.FeedbackType(value)
+	}
+
+	if feedback.Event == "" {
+		return nil, fmt.Errorf("%s: missing event", m.Event.Column)
+	}
+
+	if !feedback.Event.IsKnown() {
+		return nil, fmt.Errorf("%s: %w %q", m.Event.Column, ErrUnknownFeedbackType, value)
+	}
+
+	var err error
+	if m.OccurredAt != nil {
+		if feedback.OccurredAt, err = m.OccurredAt.parse(row); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.ExpiresAt != nil {
+		if feedback.ExpiresAt, err = m.ExpiresAt.parse(row); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.expiresAfter > 0 && feedback.OccurredAt != nil {
+		expiresAt := feedback.OccurredAt.Add(m.expiresAfter)
+		feedback.ExpiresAt = &expiresAt
+	}
+
+	if feedback.OccurredAt != nil && feedback.ExpiresAt != nil && !feedback.ExpiresAt.After(*feedback.OccurredAt) {
+		return nil, errors.New("expires_at must be after occurred_at")
+	}
+
+	ids := m.Identifiers
+	feedback.Identifiers = &incognia.FeedbackIdentifiers{
+		InstallationID: row.Values[ids.InstallationID],
+		SessionToken:   row.Values[ids.SessionToken],
+		RequestToken:   row.Values[ids.RequestToken],
+		LoginID:        row.Values[ids.LoginID],
+		PaymentID:      row.Values[ids.PaymentID],
+		SignupID:       row.Values[ids.SignupID],
+		AccountID:      row.Values[ids.AccountID],
+		ExternalID:     row.Values[ids.ExternalID],
+	}
+
+	if ids.PersonID != nil && row.Values[ids.PersonID.Column] != "" {
+		feedback.Identifiers.PersonID = &incognia.PersonID{Type: ids.PersonID.Type, Value: row.Values[ids.PersonID.Column]}
+	}
+
+	if *feedback.Identifiers == (incognia.FeedbackIdentifiers{}) {
+		return nil, errors.New("no identifier is present")
+	}
+
+	return feedback, nil
+}
+
+func (t *TimeMapping) parse(row Row) (*time.Time, error) {
+	value := row.Values[t.Column]
+	if value == "" {
+		return nil, nil
+	}
+
+	location := t.location
+	if location == nil {
+		location = time.UTC
+	}
+
+	var parsed time.Time
+	switch t.Layout {
+	case LayoutUnix:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a Unix time", t.Column, value)
+		}
+		parsed = time.Unix(seconds, 0).UTC()
+	case "":
+		var err error
+		if parsed, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Column, err)
+		}
+	default:
+		var err error
+		if parsed, err = time.ParseInLocation(t.Layout, value, location); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Column, err)
+		}
+	}
+
+	return &parsed, nil
+}
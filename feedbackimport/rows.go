@@ -0,0 +1,147 @@
+package feedbackimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const maxLineSize = 1 << 20
+
+// Row is one input record. Number counts data rows from 1, not counting the
+// CSV header.
+type Row struct {
+	Number int
+	Values map[string]string
+}
+
+// RowError reports a row that could not be read. Reading can go on after it.
+type RowError struct {
+	Number int
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Number, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Rows returns io.EOF after the last row.
+type Rows interface {
+	Next() (Row, error)
+}
+
+type CSVRows struct {
+	reader  *csv.Reader
+	columns []string
+	number  int
+}
+
+// NewCSVRows reads the header right away, so its columns can be checked
+// against the mapping before any row is read.
+func NewCSVRows(r io.Reader) (*CSVRows, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing CSV header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+	}
+
+	return &CSVRows{reader: reader, columns: columns}, nil
+}
+
+func (r *CSVRows) Columns() []string {
+	return r.columns
+}
+
+func (r *CSVRows) Next() (Row, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return Row{}, io.EOF
+	}
+
+	r.number++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Row{}, &RowError{Number: r.number, Err: err}
+	}
+	if err != nil {
+		return Row{}, err
+	}
+
+	if len(record) != len(r.columns) {
+		return Row{}, &RowError{Number: r.number, Err: fmt.Errorf("has %d fields, header has %d", len(record), len(r.columns))}
+	}
+
+	row := Row{Number: r.number, Values: make(map[string]string, len(record))}
+	for i, value := range record {
+		row.Values[r.columns[i]] = strings.TrimSpace(value)
+	}
+
+	return row, nil
+}
+
+// JSONLinesRows reads one JSON object per line. Numbers and booleans are
+// read as their text, and nested values as JSON.
+type JSONLinesRows struct {
+	scanner *bufio.Scanner
+	number  int
+}
+
+func NewJSONLinesRows(r io.Reader) *JSONLinesRows {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	return &JSONLinesRows{scanner: scanner}
+}
+
+func (r *JSONLinesRows) Next() (Row, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.number++
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(line, &object); err != nil {
+			return Row{}, &RowError{Number: r.number, Err: err}
+		}
+
+		row := Row{Number: r.number, Values: make(map[string]string, len(object))}
+		for key, raw := range object {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				row.Values[key] = strings.TrimSpace(s)
+			} else if string(raw) != "null" {
+				row.Values[key] = string(raw)
+			}
+		}
+
+		return row, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Row{}, err
+	}
+
+	return Row{}, io.EOF
+}
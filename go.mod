@@ -4,4 +4,4 @@ go 1.13
 
 require github.com/stretchr/testify v1.6.1
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
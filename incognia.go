@@ -1,21 +1,29 @@
 package incognia
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"repo.incognia.com/go/incognia/internal/ratelimit"
 )
 
 const (
 	defaultNetClientTimeout = 5 * time.Second
+	defaultRetryBackoff     = 100 * time.Millisecond
 	metricsHeader           = "X-Incognia-Latency"
 )
 
@@ -30,6 +38,34 @@ var (
 	ErrMissingLocationLatLong        = errors.New("location field missing latitude and/or longitude")
 )
 
+type Operation string
+
+const (
+	OperationRegisterSignup    Operation = "register_signup"
+	OperationRegisterWebSignup Operation = "register_web_signup"
+	OperationRegisterPayment   Operation = "register_payment"
+	OperationRegisterLogin     Operation = "register_login"
+	OperationRegisterWebLogin  Operation = "register_web_login"
+	OperationRegisterFeedback  Operation = "register_feedback"
+)
+
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+	// RequestID is the X-Request-ID header of the response, which identifies
+	// the call to Incognia.
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Body) > 0 {
+		return fmt.Sprintf("%s %s", e.Status, string(e.Body))
+	}
+
+	return e.Status
+}
+
 func libraryVersion() string {
 	if buildInfo, ok := debug.ReadBuildInfo(); ok {
 		for _, dep := range buildInfo.Deps {
@@ -65,15 +101,104 @@ type Client struct {
 	UserAgent        string
 	lastLatency      *int64
 	lastLatencyMutex sync.RWMutex
+	maxRetries       int
+	retryBackoff     time.Duration
+	rateLimiter      *ratelimit.Limiter
+	logger           *clientLogger
+	auditSink        AuditSink
+	ownedAuditSink   *AsyncAuditSink
+	ownedTransport   *http.Transport
+	deduplicator     *deduplicator
+	dryRunAll        bool
+	onDryRun         func(*DryRunResult)
+	onPanic          func(*PanicError)
+	stats            *latencyStats
+	latencyHeader    LatencyHeaderMode
+	tokenClient      *TokenClient
+	clock            Clock
+
+	compressRequests     bool
+	compressionThreshold int
+	maxResponseSize      int64
+	baseHeader           http.Header
+	baseUserAgent        string
+	codec                Codec
+
+	lifecycleMutex sync.Mutex
+	closed         bool
+	inFlight       int
+	drained        chan struct{}
+	closers        []io.Closer
 }
 
 type IncogniaClientConfig struct {
-	ClientID          string
-	ClientSecret      string
-	TokenProvider     TokenProvider
-	Timeout           time.Duration
-	TokenRouteTimeout time.Duration
-	HTTPClient        httpClient
+	ClientID               string
+	ClientSecret           string
+	CredentialsProvider    CredentialsProvider
+	CredentialsGracePeriod time.Duration
+	TokenProvider          TokenProvider
+	Timeout                time.Duration
+	TokenRouteTimeout      time.Duration
+	HTTPClient             httpClient
+	// BaseURL replaces the Incognia API address, e.g. for a local emulator.
+	BaseURL string
+	// MaxRetries is how many times a call is retried when the API cannot
+	// have processed it: after a 429 response, or a network error before the
+	// request was written. Other failures, such as timeouts and 5xx
+	// responses, are not retried, since retrying a payment, login or
+	// feedback the API already recorded would record it twice. Retries are
+	// off by default.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on each
+	// following one.
+	RetryBackoff time.Duration
+	// RateLimit caps the requests per second sent by the client, with bursts
+	// of up to RateLimitBurst requests. Zero means no limit.
+	RateLimit      float64
+	RateLimitBurst int
+	Logger         Logger
+	LogLevel       LogLevel
+	// AuditSink receives a record of every call. Sinks other than
+	// AsyncAuditSink are wrapped in one, so audit writes never block calls.
+	AuditSink AuditSink
+	// DeduplicateRequests collapses identical concurrent signup and
+	// transaction calls into one API call whose result all callers share.
+	// With a positive DeduplicationTTL, successful results are also reused
+	// by identical calls made within the TTL.
+	DeduplicateRequests bool
+	DeduplicationTTL    time.Duration
+	// DryRun validates and serializes every call without sending it, and
+	// returns a synthetic assessment. OnDryRun receives what would have been
+	// sent. A single call can be dry run with WithDryRun.
+	DryRun   bool
+	OnDryRun func(*DryRunResult)
+	// OnPanic is called with every panic recovered by the client, for
+	// instance to report it to an error tracker.
+	OnPanic func(*PanicError)
+	// StatsWindow is how far back Stats looks, 5 minutes by default. Stats
+	// keep at most the latest 10000 calls of each operation, whatever the
+	// window.
+	StatsWindow time.Duration
+	// LatencyHeader sets what the X-Incognia-Latency header carries, the
+	// last latency by default.
+	LatencyHeader LatencyHeaderMode
+	// Clock stamps and expires tokens and measures latencies, the system
+	// clock by default.
+	Clock Clock
+	// TokenExpiryMargin is how long before the expiry given by the API
+	// tokens are refreshed, 10 seconds by default.
+	TokenExpiryMargin time.Duration
+	// CompressRequests gzips request bodies of at least
+	// CompressionThreshold bytes, 1024 by default, and asks for gzipped
+	// responses.
+	CompressRequests     bool
+	CompressionThreshold int
+	// MaxResponseSize caps the size of decoded response bodies, 1 MiB by
+	// default. Larger responses fail with ErrResponseTooLarge.
+	MaxResponseSize int64
+	// Codec encodes requests and decodes responses, a StandardCodec by
+	// default.
+	Codec Codec
 }
 
 type Payment struct {
@@ -189,19 +314,29 @@ func New(config *IncogniaClientConfig) (*Client, error) {
 		return nil, ErrConfigIsNil
 	}
 
-	if config.ClientID == "" || config.ClientSecret == "" {
+	if config.CredentialsProvider == nil && (config.ClientID == "" || config.ClientSecret == "") {
 		return nil, ErrMissingClientIDOrClientSecret
 	}
 
+	if !config.LatencyHeader.valid() {
+		return nil, ErrUnknownLatencyHeaderMode
+	}
+
 	timeout := config.Timeout
 	if timeout == 0 {
 		timeout = defaultNetClientTimeout
 	}
+	// Without an HTTPClient, the client gets a transport of its own, so that
+	// Close can close its idle connections without affecting anyone else.
+	var ownedTransport *http.Transport
 	netClient := config.HTTPClient
 	if netClient == nil {
-		netClient = &http.Client{
-			Timeout: timeout,
+		client := &http.Client{Timeout: timeout}
+		if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+			ownedTransport = transport.Clone()
+			client.Transport = ownedTransport
 		}
+		netClient = client
 	}
 
 	tokenRouteTimeout := config.TokenRouteTimeout
@@ -210,10 +345,18 @@ func New(config *IncogniaClientConfig) (*Client, error) {
 	}
 
 	tokenClient := NewTokenClient(&TokenClientConfig{
-		ClientID:     config.ClientID,
-		ClientSecret: config.ClientSecret,
-		Timeout:      tokenRouteTimeout,
+		ClientID:               config.ClientID,
+		ClientSecret:           config.ClientSecret,
+		CredentialsProvider:    config.CredentialsProvider,
+		CredentialsGracePeriod: config.CredentialsGracePeriod,
+		Timeout:                tokenRouteTimeout,
+		BaseURL:                config.BaseURL,
+		Clock:                  config.Clock,
+		ExpiryMargin:           config.TokenExpiryMargin,
 	})
+	if ownedTransport != nil {
+		tokenClient.netClient.Transport = ownedTransport
+	}
 
 	userAgent := buildUserAgent(libraryVersion())
 
@@ -222,20 +365,76 @@ func New(config *IncogniaClientConfig) (*Client, error) {
 		tokenProvider = NewAutoRefreshTokenProvider(tokenClient)
 	}
 
-	endpoints := getEndpoints()
+	endpoints := getEndpoints(config.BaseURL)
 
-	return &Client{clientID: config.ClientID, clientSecret: config.ClientSecret, tokenProvider: tokenProvider, netClient: netClient, endpoints: &endpoints, UserAgent: userAgent}, nil
+	clock := clockOrSystem(config.Clock)
+
+	compressionThreshold := config.CompressionThreshold
+	if compressionThreshold == 0 {
+		compressionThreshold = defaultCompressionThreshold
+	}
+
+	maxResponseSize := config.MaxResponseSize
+	if maxResponseSize == 0 {
+		maxResponseSize = defaultMaxResponseSize
+	}
+
+	baseHeader := http.Header{
+		"Content-Type": []string{"application/json"},
+		"User-Agent":   []string{userAgent},
+	}
+	if config.CompressRequests {
+		baseHeader.Set("Accept-Encoding", "gzip")
+	}
+
+	retryBackoff := config.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = defaultRetryBackoff
+	}
+
+	auditSink, ownedAuditSink := asyncAuditSink(config.AuditSink)
+
+	return &Client{
+		clientID:      config.ClientID,
+		clientSecret:  config.ClientSecret,
+		tokenProvider: tokenProvider,
+		netClient:     netClient,
+		endpoints:     &endpoints,
+		UserAgent:     userAgent,
+		maxRetries:    config.MaxRetries,
+		retryBackoff:  retryBackoff,
+		rateLimiter:   ratelimit.New(config.RateLimit, config.RateLimitBurst),
+		logger:        newClientLogger(config.Logger, config.LogLevel),
+		auditSink:     auditSink,
+		deduplicator:  newDeduplicator(config.DeduplicateRequests, config.DeduplicationTTL, clock),
+		dryRunAll:     config.DryRun,
+		onDryRun:      config.OnDryRun,
+		onPanic:       config.OnPanic,
+		stats:         newLatencyStats(config.StatsWindow, clock),
+		latencyHeader: config.LatencyHeader,
+		tokenClient:   tokenClient,
+		clock:         clock,
+
+		compressRequests:     config.CompressRequests,
+		compressionThreshold: compressionThreshold,
+		maxResponseSize:      maxResponseSize,
+		baseHeader:           baseHeader,
+		baseUserAgent:        userAgent,
+		codec:                codecOrStandard(config.Codec),
+		ownedAuditSink:       ownedAuditSink,
+		ownedTransport:       ownedTransport,
+	}, nil
 }
 
 func (c *Client) RegisterSignup(installationID string, address *Address) (ret *SignupAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterSignup, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerSignup(&Signup{
+	return c.registerSignup(context.Background(), &Signup{
 		InstallationID: installationID,
 		Address:        address,
 	})
@@ -244,26 +443,52 @@ func (c *Client) RegisterSignup(installationID string, address *Address) (ret *S
 func (c *Client) RegisterSignupWithParams(params *Signup) (ret *SignupAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterSignup, r)
+			ret = nil
+		}
+	}()
+
+	return c.registerSignup(context.Background(), params)
+}
+
+// RegisterSignupWithContext is like RegisterSignupWithParams, but the call is
+// cancelled when ctx is done.
+func (c *Client) RegisterSignupWithContext(ctx context.Context, params *Signup) (ret *SignupAssessment, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterSignup, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerSignup(params)
+	return c.registerSignup(ctx, params)
 }
 
 func (c *Client) RegisterWebSignup(params *WebSignup) (ret *SignupAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterWebSignup, r)
+			ret = nil
+		}
+	}()
+
+	return c.registerWebSignup(context.Background(), params)
+}
+
+// RegisterWebSignupWithContext is like RegisterWebSignup, but the call is cancelled when ctx is
+// done.
+func (c *Client) RegisterWebSignupWithContext(ctx context.Context, params *WebSignup) (ret *SignupAssessment, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterWebSignup, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerWebSignup(params)
+	return c.registerWebSignup(ctx, params)
 }
 
-func (c *Client) registerSignup(params *Signup) (ret *SignupAssessment, err error) {
+func (c *Client) registerSignup(ctx context.Context, params *Signup) (ret *SignupAssessment, err error) {
 	if params == nil {
 		return nil, ErrMissingSignup
 	}
@@ -288,19 +513,9 @@ func (c *Client) registerSignup(params *Signup) (ret *SignupAssessment, err erro
 		requestBody.Coordinates = params.Address.Coordinates
 	}
 
-	requestBodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", c.endpoints.Signups, bytes.NewBuffer(requestBodyBytes))
-	if err != nil {
-		return nil, err
-	}
-
 	var signupAssessment SignupAssessment
 
-	err = c.doRequest(req, &signupAssessment)
+	err = c.post(ctx, OperationRegisterSignup, c.endpoints.Signups, nil, requestBody, &signupAssessment)
 	if err != nil {
 		return nil, err
 	}
@@ -308,7 +523,7 @@ func (c *Client) registerSignup(params *Signup) (ret *SignupAssessment, err erro
 	return &signupAssessment, nil
 }
 
-func (c *Client) registerWebSignup(params *WebSignup) (ret *SignupAssessment, err error) {
+func (c *Client) registerWebSignup(ctx context.Context, params *WebSignup) (ret *SignupAssessment, err error) {
 	if params == nil {
 		return nil, ErrMissingSignup
 	}
@@ -322,19 +537,9 @@ func (c *Client) registerWebSignup(params *WebSignup) (ret *SignupAssessment, er
 		TenantID:         params.TenantID,
 	}
 
-	requestBodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", c.endpoints.Signups, bytes.NewBuffer(requestBodyBytes))
-	if err != nil {
-		return nil, err
-	}
-
 	var signupAssessment SignupAssessment
 
-	err = c.doRequest(req, &signupAssessment)
+	err = c.post(ctx, OperationRegisterWebSignup, c.endpoints.Signups, nil, requestBody, &signupAssessment)
 	if err != nil {
 		return nil, err
 	}
@@ -345,24 +550,47 @@ func (c *Client) registerWebSignup(params *WebSignup) (ret *SignupAssessment, er
 func (c *Client) RegisterFeedback(feedbackEvent FeedbackType, occurredAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterFeedback, r)
 		}
 	}()
 
-	return c.registerFeedback(feedbackEvent, occurredAt, nil, feedbackIdentifiers)
+	return c.registerFeedback(context.Background(), feedbackEvent, occurredAt, nil, feedbackIdentifiers)
 }
 
 func (c *Client) RegisterFeedbackWithExpiration(feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterFeedback, r)
 		}
 	}()
 
-	return c.registerFeedback(feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
+	return c.registerFeedback(context.Background(), feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
 }
 
-func (c *Client) registerFeedback(feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) (err error) {
+// RegisterFeedbackWithContext is like RegisterFeedbackWithExpiration, but the
+// call is cancelled when ctx is done.
+func (c *Client) RegisterFeedbackWithContext(ctx context.Context, feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterFeedback, r)
+		}
+	}()
+
+	return c.registerFeedback(ctx, feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
+}
+
+func (c *Client) registerFeedback(ctx context.Context, feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) (err error) {
+	requestBody := newFeedbackRequestBody(feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
+
+	err = c.post(ctx, OperationRegisterFeedback, c.endpoints.Feedback, nil, requestBody, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newFeedbackRequestBody(feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) postFeedbackRequestBody {
 	requestBody := postFeedbackRequestBody{
 		Event:      feedbackEvent,
 		OccurredAt: occurredAt,
@@ -379,36 +607,35 @@ func (c *Client) registerFeedback(feedbackEvent FeedbackType, occurredAt *time.T
 		requestBody.ExternalID = feedbackIdentifiers.ExternalID
 		requestBody.PersonID = feedbackIdentifiers.PersonID
 	}
-	requestBodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return err
-	}
 
-	req, err := http.NewRequest("POST", c.endpoints.Feedback, bytes.NewBuffer(requestBodyBytes))
-	if err != nil {
-		return err
-	}
+	return requestBody
+}
 
-	err = c.doRequest(req, nil)
-	if err != nil {
-		return err
-	}
+func (c *Client) RegisterPayment(payment *Payment) (ret *TransactionAssessment, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterPayment, r)
+			ret = nil
+		}
+	}()
 
-	return nil
+	return c.registerPayment(context.Background(), payment)
 }
 
-func (c *Client) RegisterPayment(payment *Payment) (ret *TransactionAssessment, err error) {
+// RegisterPaymentWithContext is like RegisterPayment, but the call is cancelled when ctx is
+// done.
+func (c *Client) RegisterPaymentWithContext(ctx context.Context, payment *Payment) (ret *TransactionAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterPayment, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerPayment(payment)
+	return c.registerPayment(ctx, payment)
 }
 
-func (c *Client) registerPayment(payment *Payment) (ret *TransactionAssessment, err error) {
+func (c *Client) registerPayment(ctx context.Context, payment *Payment) (ret *TransactionAssessment, err error) {
 
 	if payment == nil {
 		return nil, ErrMissingPayment
@@ -423,7 +650,7 @@ func (c *Client) registerPayment(payment *Payment) (ret *TransactionAssessment,
 		return nil, locationError
 	}
 
-	requestBody, err := json.Marshal(postTransactionRequestBody{
+	requestBody := postTransactionRequestBody{
 		InstallationID:         payment.InstallationID,
 		RelatedWebRequestToken: payment.RelatedWebRequestToken,
 		TenantID:               payment.TenantID,
@@ -445,25 +672,11 @@ func (c *Client) registerPayment(payment *Payment) (ret *TransactionAssessment,
 		PersonID:               payment.PersonID,
 		DebtorAccount:          payment.DebtorAccount,
 		CreditorAccount:        payment.CreditorAccount,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", c.endpoints.Transactions, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
-	}
-
-	if payment.Eval != nil {
-		q := req.URL.Query()
-		q.Add("eval", fmt.Sprintf("%t", *payment.Eval))
-		req.URL.RawQuery = q.Encode()
 	}
 
 	var paymentAssesment TransactionAssessment
 
-	err = c.doRequest(req, &paymentAssesment)
+	err = c.post(ctx, OperationRegisterPayment, c.endpoints.Transactions, evalQuery(payment.Eval), requestBody, &paymentAssesment)
 	if err != nil {
 		return nil, err
 	}
@@ -474,15 +687,28 @@ func (c *Client) registerPayment(payment *Payment) (ret *TransactionAssessment,
 func (c *Client) RegisterLogin(login *Login) (ret *TransactionAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterLogin, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerLogin(login)
+	return c.registerLogin(context.Background(), login)
 }
 
-func (c *Client) registerLogin(login *Login) (*TransactionAssessment, error) {
+// RegisterLoginWithContext is like RegisterLogin, but the call is cancelled when ctx is
+// done.
+func (c *Client) RegisterLoginWithContext(ctx context.Context, login *Login) (ret *TransactionAssessment, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterLogin, r)
+			ret = nil
+		}
+	}()
+
+	return c.registerLogin(ctx, login)
+}
+
+func (c *Client) registerLogin(ctx context.Context, login *Login) (*TransactionAssessment, error) {
 
 	if login == nil {
 		return nil, ErrMissingLogin
@@ -497,7 +723,7 @@ func (c *Client) registerLogin(login *Login) (*TransactionAssessment, error) {
 		return nil, locationError
 	}
 
-	requestBody, err := json.Marshal(postTransactionRequestBody{
+	requestBody := postTransactionRequestBody{
 		InstallationID:          login.InstallationID,
 		Type:                    loginType,
 		AccountID:               login.AccountID,
@@ -514,25 +740,11 @@ func (c *Client) registerLogin(login *Login) (*TransactionAssessment, error) {
 		CustomProperties:        login.CustomProperties,
 		PersonID:                login.PersonID,
 		Countries:               login.Countries,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", c.endpoints.Transactions, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
-	}
-
-	if login.Eval != nil {
-		q := req.URL.Query()
-		q.Add("eval", fmt.Sprintf("%t", *login.Eval))
-		req.URL.RawQuery = q.Encode()
 	}
 
 	var loginAssessment TransactionAssessment
 
-	err = c.doRequest(req, &loginAssessment)
+	err := c.post(ctx, OperationRegisterLogin, c.endpoints.Transactions, evalQuery(login.Eval), requestBody, &loginAssessment)
 	if err != nil {
 		return nil, err
 	}
@@ -543,15 +755,28 @@ func (c *Client) registerLogin(login *Login) (*TransactionAssessment, error) {
 func (c *Client) RegisterWebLogin(webLogin *WebLogin) (ret *TransactionAssessment, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v", r)
+			err = c.panicError(OperationRegisterWebLogin, r)
+			ret = nil
+		}
+	}()
+
+	return c.registerWebLogin(context.Background(), webLogin)
+}
+
+// RegisterWebLoginWithContext is like RegisterWebLogin, but the call is cancelled when ctx is
+// done.
+func (c *Client) RegisterWebLoginWithContext(ctx context.Context, webLogin *WebLogin) (ret *TransactionAssessment, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.panicError(OperationRegisterWebLogin, r)
 			ret = nil
 		}
 	}()
 
-	return c.registerWebLogin(webLogin)
+	return c.registerWebLogin(ctx, webLogin)
 }
 
-func (c *Client) registerWebLogin(webLogin *WebLogin) (*TransactionAssessment, error) {
+func (c *Client) registerWebLogin(ctx context.Context, webLogin *WebLogin) (*TransactionAssessment, error) {
 
 	if webLogin == nil {
 		return nil, ErrMissingLogin
@@ -561,7 +786,7 @@ func (c *Client) registerWebLogin(webLogin *WebLogin) (*TransactionAssessment, e
 		return nil, ErrMissingAccountID
 	}
 
-	requestBody, err := json.Marshal(postTransactionRequestBody{
+	requestBody := postTransactionRequestBody{
 		Type:             loginType,
 		AccountID:        webLogin.AccountID,
 		PolicyID:         webLogin.PolicyID,
@@ -571,30 +796,114 @@ func (c *Client) registerWebLogin(webLogin *WebLogin) (*TransactionAssessment, e
 		PersonID:         webLogin.PersonID,
 		Countries:        webLogin.Countries,
 		TenantID:         webLogin.TenantID,
-	})
+	}
+
+	var webLoginAssessment TransactionAssessment
+
+	err := c.post(ctx, OperationRegisterWebLogin, c.endpoints.Transactions, evalQuery(webLogin.Eval), requestBody, &webLoginAssessment)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.endpoints.Transactions, bytes.NewBuffer(requestBody))
+	return &webLoginAssessment, nil
+}
+
+func evalQuery(eval *bool) url.Values {
+	if eval == nil {
+		return nil
+	}
+
+	return url.Values{"eval": []string{fmt.Sprintf("%t", *eval)}}
+}
+
+func (c *Client) post(ctx context.Context, operation Operation, endpoint string, query url.Values, requestBody interface{}, response interface{}) error {
+	if err := c.begin(); err != nil {
+		return err
+	}
+	defer c.end()
+
+	body, err := encodeRequestBody(c.codec, requestBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer body.release()
+
+	ctx, info := withCallInfo(ctx)
 
-	if webLogin.Eval != nil {
-		q := req.URL.Query()
-		q.Add("eval", fmt.Sprintf("%t", *webLogin.Eval))
-		req.URL.RawQuery = q.Encode()
+	if result := c.dryRunResult(ctx); result != nil {
+		return c.dryRun(ctx, operation, endpoint, query, body.bytes(), response, result)
 	}
 
-	var webLoginAssessment TransactionAssessment
+	if c.deduplicator == nil || operation == OperationRegisterFeedback {
+		err = c.send(ctx, operation, endpoint, query, requestBody, body, response)
+	} else {
+		key := dedupKey(operation, endpoint, query, body.bytes())
+		var shared sharedResponse
+		shared, err = c.deduplicator.do(ctx, key, func() (sharedResponse, error) {
+			var raw json.RawMessage
+			err := c.send(ctx, operation, endpoint, query, requestBody, body, &raw)
+			return sharedResponse{body: raw, requestID: info.getRequestID()}, err
+		})
+		if err == nil {
+			info.setRequestID(shared.requestID)
+			err = c.decodeRawResponse(shared.body, response)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if setter, ok := response.(requestIDSetter); ok {
+		setter.setRequestID(info.getRequestID())
+	}
+
+	return nil
+}
+
+// decodeRawResponse decodes a response shared between calls, so that each
+// caller gets its own copy.
+func (c *Client) decodeRawResponse(raw json.RawMessage, response interface{}) error {
+	if response == nil || len(raw) == 0 {
+		return nil
+	}
+
+	return c.codec.Unmarshal(raw, response)
+}
 
-	err = c.doRequest(req, &webLoginAssessment)
+// send makes the request of a call. body may be released once it returns,
+// as the request bodies keep their own references to it.
+func (c *Client) send(ctx context.Context, operation Operation, endpoint string, query url.Values, requestBody interface{}, body *requestBuffer, response interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &webLoginAssessment, nil
+	compressed, err := c.compressRequestBody(body)
+	if err != nil {
+		return err
+	}
+	if compressed != nil {
+		defer compressed.release()
+		body = compressed
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	req.Body = body.body()
+	req.ContentLength = int64(len(body.bytes()))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return body.body(), nil
+	}
+
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+
+	startedAt := c.clock.Now()
+	err = c.doRequest(req, response)
+	c.stats.record(operation, c.clock.Now().Sub(startedAt), err)
+	c.audit(operation, req, requestBody, response, startedAt, err)
+
+	return err
 }
 
 func (c *Client) getLastLatency() *int64 {
@@ -609,50 +918,183 @@ func (c *Client) setLastLatency(ms int64) {
 	c.lastLatency = &ms
 }
 
-func (c *Client) doRequest(request *http.Request, response interface{}) error {
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("User-Agent", c.UserAgent)
+// setHeaders adds the headers shared by every request, keeping those already
+// set on request. As in Header.Clone, the values are copied into a single
+// slice, so changing them on a request never changes baseHeader.
+func (c *Client) setHeaders(request *http.Request) {
+	if request.Header == nil {
+		request.Header = make(http.Header, len(c.baseHeader))
+	}
+
+	count := 0
+	for _, values := range c.baseHeader {
+		count += len(values)
+	}
+	copied := make([]string, 0, count)
+	for key, values := range c.baseHeader {
+		if _, ok := request.Header[key]; !ok {
+			n := len(copied)
+			copied = append(copied, values...)
+			request.Header[key] = copied[n:len(copied):len(copied)]
+		}
+	}
 
-	if lt := c.getLastLatency(); lt != nil {
-		request.Header.Add(metricsHeader, fmt.Sprintf("%d", *lt))
+	if c.UserAgent != c.baseUserAgent {
+		request.Header.Set("User-Agent", c.UserAgent)
+	}
+	if id := callInfoFrom(request.Context()).correlationID; id != "" {
+		request.Header.Set(correlationIDHeader, id)
+	}
+
+	switch c.latencyHeader {
+	case LatencyHeaderOff:
+	case LatencyHeaderAggregate:
+		if aggregate := c.stats.aggregateHeader(); aggregate != "" {
+			request.Header.Add(metricsHeader, aggregate)
+		}
+	default:
+		if lt := c.getLastLatency(); lt != nil {
+			request.Header.Set(metricsHeader, strconv.FormatInt(*lt, 10))
+		}
 	}
+}
+
+func (c *Client) doRequest(request *http.Request, response interface{}) error {
+	c.setHeaders(request)
 
 	err := c.authorizeRequest(request)
 	if err != nil {
 		return err
 	}
 
-	start := time.Now()
+	correlationID := callInfoFrom(request.Context()).correlationID
+	for attempt := 0; ; attempt++ {
+		retryable, err := c.sendRequest(request, response)
+		if err == nil {
+			return nil
+		}
+
+		if !retryable || attempt >= c.maxRetries || request.GetBody == nil {
+			c.logger.errorf("%s %s failed (correlation ID %s): %v", request.Method, request.URL.Path, correlationID, err)
+			return err
+		}
+
+		backoff := c.retryBackoff << uint(attempt)
+		c.logger.errorf("%s %s failed (correlation ID %s), retrying in %s: %v", request.Method, request.URL.Path, correlationID, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		body, bodyErr := request.GetBody()
+		if bodyErr != nil {
+			return err
+		}
+		request.Body = body
+	}
+}
+
+// sendRequest makes a single attempt, reporting whether a failure is safe to
+// retry, that is whether the API cannot have processed the request.
+func (c *Client) sendRequest(request *http.Request, response interface{}) (bool, error) {
+	if err := c.rateLimiter.Wait(request.Context()); err != nil {
+		return false, err
+	}
+
+	// Tracing costs a few allocations, so it is only done when the result
+	// is used.
+	var trace *writeTrace
+	if c.maxRetries > 0 {
+		trace = &writeTrace{}
+		request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace.clientTrace()))
+	}
+
+	start := c.clock.Now()
 	res, err := c.netClient.Do(request)
 	if err != nil {
-		return err
+		return trace.unwritten() && request.Context().Err() == nil, err
 	}
 
 	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	reader, closeReader, err := responseReader(res)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer closeReader()
+
+	info := callInfoFrom(request.Context())
+	requestID := res.Header.Get(requestIDHeader)
+	c.logger.debugf("%s %s %d %dms correlation_id=%s request_id=%s", request.Method, request.URL.Path, res.StatusCode, c.clock.Now().Sub(start).Milliseconds(), info.correlationID, requestID)
 
 	if res.StatusCode != http.StatusOK {
-		if len(body) > 0 {
-			return fmt.Errorf("%s %s", res.Status, string(body))
+		body, err := readResponse(reader, c.maxResponseSize)
+		defer body.release()
+		if err != nil {
+			return false, err
 		}
+		retryable := res.StatusCode == http.StatusTooManyRequests
+		errorBody := append([]byte(nil), body.Bytes()...)
+		return retryable, &APIError{StatusCode: res.StatusCode, Status: res.Status, Body: errorBody, RequestID: requestID}
+	}
 
-		return errors.New(res.Status)
+	if err := c.decodeResponse(reader, response); err != nil {
+		return false, err
 	}
 
-	if len(body) > 0 {
-		err = json.Unmarshal(body, &response)
-		if err != nil {
+	c.setLastLatency(c.clock.Now().Sub(start).Milliseconds())
+	if requestID != "" {
+		info.setRequestID(requestID)
+	}
+
+	return false, nil
+}
+
+// decodeResponse decodes a response body of at most maxResponseSize bytes.
+// The StandardCodec decodes it as it is read; other codecs decode it from a
+// pooled buffer. The rest of the body is read, so the connection can be
+// reused.
+func (c *Client) decodeResponse(reader io.Reader, response interface{}) error {
+	codec, ok := c.codec.(StandardCodec)
+	if !ok {
+		body, err := readResponse(reader, c.maxResponseSize)
+		defer body.release()
+		if err != nil || response == nil || body.Len() == 0 {
 			return err
 		}
+		return c.codec.Unmarshal(body.Bytes(), response)
 	}
 
-	c.setLastLatency(time.Since(start).Milliseconds())
+	limited := &limitedReader{reader: reader, remaining: c.maxResponseSize}
+	if response != nil {
+		if err := codec.decode(limited, response); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	_, err := io.Copy(ioutil.Discard, limited)
+	return err
+}
 
-	return nil
+// writeTrace tells whether a request failed before being written. Requests
+// sent by HTTP clients that do not report it count as written.
+type writeTrace struct {
+	connecting int32
+	written    int32
+}
+
+func (t *writeTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:      func(string) { atomic.StoreInt32(&t.connecting, 1) },
+		WroteRequest: func(httptrace.WroteRequestInfo) { atomic.StoreInt32(&t.written, 1) },
+	}
+}
+
+func (t *writeTrace) unwritten() bool {
+	return t != nil && atomic.LoadInt32(&t.connecting) == 1 && atomic.LoadInt32(&t.written) == 0
 }
 
 func (c *Client) authorizeRequest(request *http.Request) error {
@@ -1,10 +1,17 @@
 package incognia
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"reflect"
 	"regexp"
 	"runtime"
@@ -92,7 +99,7 @@ var (
 		RequestID:      "some-request-id",
 		RiskAssessment: LowRisk,
 		Reasons:        []Reason{{Code: "mpos_fraud", Source: "global"}, {Code: "mpos_fraud", Source: "local"}},
-		Actions:        []string{"allow"},
+		Actions:        []Action{"allow"},
 		Evidence: Evidence{
 			"device_model":                 "Moto Z2 Play",
 			"geocode_quality":              "good",
@@ -118,7 +125,7 @@ var (
 		RequestID:      "some-request-id",
 		RiskAssessment: HighRisk,
 		Reasons:        []Reason{{Code: "mpos_fraud", Source: "global"}, {Code: "mpos_fraud", Source: "local"}},
-		Actions:        []string{"warn-user", "block"},
+		Actions:        []Action{"warn-user", "block"},
 		Evidence: Evidence{
 			"device_model":                 "Moto Z2 Play",
 			"geocode_quality":              "good",
@@ -295,7 +302,7 @@ var (
 		DeviceID:       "some-device-id",
 		RiskAssessment: LowRisk,
 		Reasons:        []Reason{{Code: "mpos_fraud", Source: "global"}, {Code: "mpos_fraud", Source: "local"}},
-		Actions:        []string{"allow"},
+		Actions:        []Action{"allow"},
 		Evidence: Evidence{
 			"device_model":                 "Moto Z2 Play",
 			"geocode_quality":              "good",
@@ -320,7 +327,7 @@ var (
 		DeviceID:       "some-device-id",
 		RiskAssessment: HighRisk,
 		Reasons:        []Reason{{Code: "mpos_fraud", Source: "global"}, {Code: "mpos_fraud", Source: "local"}},
-		Actions:        []string{"warn-user", "block"},
+		Actions:        []Action{"warn-user", "block"},
 		Evidence: Evidence{
 			"device_model":                 "Moto Z2 Play",
 			"geocode_quality":              "good",
@@ -989,6 +996,26 @@ func (suite *IncogniaTestSuite) TearDownTest() {
 	defer suite.tokenServer.Close()
 }
 
+func (suite *IncogniaTestSuite) TestSetHeadersKeepsBaseHeader() {
+	request, err := http.NewRequest(http.MethodPost, "https://api.incognia.com", nil)
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "text/plain")
+
+	suite.client.setHeaders(request)
+	request.Header.Add("User-Agent", "extra")
+
+	suite.Equal("text/plain", request.Header.Get("Content-Type"))
+	suite.Equal([]string{suite.client.baseUserAgent, "extra"}, request.Header["User-Agent"])
+	suite.Equal([]string{"application/json"}, suite.client.baseHeader["Content-Type"])
+	suite.Equal([]string{suite.client.baseUserAgent}, suite.client.baseHeader["User-Agent"])
+
+	other, err := http.NewRequest(http.MethodPost, "https://api.incognia.com", nil)
+	suite.Require().NoError(err)
+	suite.client.setHeaders(other)
+	other.Header["Content-Type"][0] = "text/plain"
+	suite.Equal([]string{"application/json"}, suite.client.baseHeader["Content-Type"], "values are copied")
+}
+
 func (suite *IncogniaTestSuite) TestManualRefreshTokenProviderErrorTokenNotFound() {
 	tokenProvider := NewManualRefreshTokenProvider(NewTokenClient(&TokenClientConfig{ClientID: clientID, ClientSecret: clientSecret}))
 	client, _ := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, TokenProvider: tokenProvider})
@@ -1085,7 +1112,7 @@ func (suite *IncogniaTestSuite) TestSuccessRegisterSignupActionsLowRisk() {
 
 	response, err := suite.client.RegisterSignup(postSignupRequestBodyFixture.InstallationID, addressFixture)
 	suite.NoError(err)
-	suite.Equal([]string{"allow"}, response.Actions)
+	suite.Equal([]Action{"allow"}, response.Actions)
 }
 
 func (suite *IncogniaTestSuite) TestSuccessRegisterSignupActionsHighRisk() {
@@ -1094,7 +1121,7 @@ func (suite *IncogniaTestSuite) TestSuccessRegisterSignupActionsHighRisk() {
 
 	response, err := suite.client.RegisterSignup(postSignupRequestBodyFixture.InstallationID, addressFixture)
 	suite.NoError(err)
-	suite.Equal([]string{"warn-user", "block"}, response.Actions)
+	suite.Equal([]Action{"warn-user", "block"}, response.Actions)
 }
 
 func (suite *IncogniaTestSuite) TestSuccessRegisterSignupNilOptional() {
@@ -1288,7 +1315,7 @@ func (suite *IncogniaTestSuite) TestSuccessRegisterPaymentActionsHighRisk() {
 
 	response, err := suite.client.RegisterPayment(paymentFixture)
 	suite.NoError(err)
-	suite.Equal([]string{"warn-user", "block"}, response.Actions)
+	suite.Equal([]Action{"warn-user", "block"}, response.Actions)
 }
 
 func (suite *IncogniaTestSuite) TestSuccessRegisterPaymentWeb() {
@@ -1515,7 +1542,7 @@ func (suite *IncogniaTestSuite) TestSuccessRegisterLoginWeb() {
 	transactionServer := suite.mockPostTransactionsEndpoint(token, postLoginWebRequestBodyFixture, transactionAssessmentFixture, emptyQueryString)
 	defer transactionServer.Close()
 
-	response, err := suite.client.registerWebLogin(loginWebFixture)
+	response, err := suite.client.registerWebLogin(context.Background(), loginWebFixture)
 	suite.NoError(err)
 	suite.Equal(transactionAssessmentFixture, response)
 }
@@ -1556,7 +1583,7 @@ func (suite *IncogniaTestSuite) TestSuccessRegisterLoginWebWithCountries() {
 	transactionServer := suite.mockPostTransactionsEndpoint(token, postLoginWebRequestBodyWithCountriesFixture, transactionAssessmentFixture, emptyQueryString)
 	defer transactionServer.Close()
 
-	response, err := suite.client.registerWebLogin(loginWebWithCountriesFixture)
+	response, err := suite.client.registerWebLogin(context.Background(), loginWebWithCountriesFixture)
 	suite.NoError(err)
 	suite.Equal(transactionAssessmentFixture, response)
 }
@@ -1684,6 +1711,24 @@ func (suite *IncogniaTestSuite) TestPanic() {
 	suite.Equal(err.Error(), panicString)
 }
 
+func (suite *IncogniaTestSuite) TestPanicError() {
+	var reported []*PanicError
+	suite.client.onPanic = func(err *PanicError) { reported = append(reported, err) }
+	suite.client.tokenProvider = &PanickingTokenProvider{panicString: "error getting token"}
+
+	_, err := suite.client.RegisterWebLogin(&WebLogin{AccountID: "account-id"})
+
+	var panicErr *PanicError
+	suite.Require().True(errors.As(err, &panicErr))
+	suite.Equal(OperationRegisterWebLogin, panicErr.Operation)
+	suite.Equal("error getting token", panicErr.Value)
+	suite.Contains(string(panicErr.Stack), "PanickingTokenProvider")
+	suite.Equal([]*PanicError{panicErr}, reported)
+
+	cause := errors.New("cause")
+	suite.True(errors.Is(&PanicError{Value: cause}, cause))
+}
+
 func (suite *IncogniaTestSuite) TestLbmtIsAbsentOnFirstCall() {
 	var capturedHeader string
 
@@ -1797,10 +1842,261 @@ func (suite *IncogniaTestSuite) TestLbmtIsSentOnSignupAfterFeedback() {
 	suite.GreaterOrEqual(lt, int64(0))
 }
 
+func (suite *IncogniaTestSuite) TestRetryOnTooManyRequests() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var requestBody postTransactionRequestBody
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		suite.Equal(postPaymentRequestBodyFixture, &requestBody)
+
+		res, _ := json.Marshal(transactionAssessmentFixture)
+		w.Write(res)
+	}))
+	defer server.Close()
+
+	suite.client.endpoints.Transactions = server.URL
+	suite.client.maxRetries = 2
+	suite.client.retryBackoff = time.Millisecond
+
+	response, err := suite.client.RegisterPayment(paymentFixture)
+	suite.NoError(err)
+	suite.Equal(transactionAssessmentFixture, response)
+	suite.Equal(3, attempts)
+}
+
+func (suite *IncogniaTestSuite) TestNoRetryOnServerError() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	suite.client.endpoints.Transactions = server.URL
+	suite.client.maxRetries = 2
+	suite.client.retryBackoff = time.Millisecond
+
+	_, err := suite.client.RegisterPayment(paymentFixture)
+	suite.EqualError(err, "503 Service Unavailable")
+	suite.Equal(1, attempts, "the API may have recorded the payment")
+}
+
+type failingTransport struct {
+	failures int
+	written  bool
+}
+
+func (t *failingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if t.failures == 0 {
+		return http.DefaultTransport.RoundTrip(request)
+	}
+	t.failures--
+
+	trace := httptrace.ContextClientTrace(request.Context())
+	trace.GetConn(request.URL.Host)
+	if t.written {
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+	return nil, errors.New("connection reset")
+}
+
+func (suite *IncogniaTestSuite) TestRetryOnlyBeforeRequestIsWritten() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		res, _ := json.Marshal(transactionAssessmentFixture)
+		w.Write(res)
+	}))
+	defer server.Close()
+
+	transport := &failingTransport{failures: 2}
+	suite.client.netClient = &http.Client{Transport: transport}
+	suite.client.endpoints.Transactions = server.URL
+	suite.client.maxRetries = 2
+	suite.client.retryBackoff = time.Millisecond
+
+	response, err := suite.client.RegisterPayment(paymentFixture)
+	suite.NoError(err)
+	suite.Equal(transactionAssessmentFixture, response)
+	suite.Equal(1, attempts)
+
+	transport.failures = 1
+	transport.written = true
+	_, err = suite.client.RegisterPayment(paymentFixture)
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "connection reset")
+	suite.Equal(0, transport.failures)
+	suite.Equal(1, attempts, "requests written are not retried")
+}
+
+func (suite *IncogniaTestSuite) TestNoRetryOnClientError() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"bad request"}`))
+	}))
+	defer server.Close()
+
+	suite.client.endpoints.Transactions = server.URL
+	suite.client.maxRetries = 2
+	suite.client.retryBackoff = time.Millisecond
+
+	_, err := suite.client.RegisterPayment(paymentFixture)
+	suite.Equal(1, attempts)
+
+	var apiErr *APIError
+	suite.Require().True(errors.As(err, &apiErr))
+	suite.Equal(http.StatusBadRequest, apiErr.StatusCode)
+	suite.Equal(`{"message":"bad request"}`, string(apiErr.Body))
+	suite.EqualError(err, `400 Bad Request {"message":"bad request"}`)
+}
+
+func (suite *IncogniaTestSuite) TestRetriesExhausted() {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	suite.client.endpoints.Feedback = server.URL
+	suite.client.maxRetries = 1
+	suite.client.retryBackoff = time.Millisecond
+
+	err := suite.client.RegisterFeedback(postFeedbackRequestBodyFixture.Event, postFeedbackRequestBodyFixture.OccurredAt, feedbackIdentifiersFixture)
+	suite.EqualError(err, "429 Too Many Requests")
+	suite.Equal(2, attempts)
+}
+
+func (suite *IncogniaTestSuite) TestLoggerAndBaseURL() {
+	var logs strings.Builder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == tokenEndpoint {
+			res, _ := json.Marshal(map[string]string{"access_token": token, "expires_in": tokenExpiresIn, "token_type": "Bearer"})
+			w.Write(res)
+			return
+		}
+		suite.Equal(signupsEndpoint, r.URL.Path)
+		res, _ := json.Marshal(signupAssessmentFixture)
+		w.Write(res)
+	}))
+	defer server.Close()
+
+	client, err := New(&IncogniaClientConfig{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		BaseURL:      server.URL + "/",
+		Logger:       log.New(&logs, "", 0),
+		LogLevel:     LogLevelDebug,
+	})
+	suite.Require().NoError(err)
+
+	_, err = client.RegisterSignup(installationId, addressFixture)
+	suite.NoError(err)
+	suite.Contains(logs.String(), "POST /v2/onboarding/signups 200")
+}
+
 func TestIncogniaTestSuite(t *testing.T) {
 	suite.Run(t, new(IncogniaTestSuite))
 }
 
+// benchmarkHTTPClient answers every request with response, reading and
+// closing the request body as a transport does.
+type benchmarkHTTPClient struct {
+	response []byte
+}
+
+func (c *benchmarkHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	io.Copy(ioutil.Discard, req.Body)
+	req.Body.Close()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(c.response)),
+	}, nil
+}
+
+func newBenchmarkClient(b *testing.B, response interface{}) *Client {
+	responseBytes, _ := json.Marshal(response)
+	client, err := New(&IncogniaClientConfig{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		LogLevel:     LogLevelOff,
+		HTTPClient:   &benchmarkHTTPClient{response: responseBytes},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	client.tokenProvider.(*AutoRefreshTokenProvider).token = accessTokenFixture
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	return client
+}
+
+func BenchmarkRegisterSignup(b *testing.B) {
+	client := newBenchmarkClient(b, signupAssessmentFixture)
+	for i := 0; i < b.N; i++ {
+		if _, err := client.RegisterSignup(installationId, addressFixture); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterWebSignup(b *testing.B) {
+	client := newBenchmarkClient(b, signupAssessmentFixture)
+	for i := 0; i < b.N; i++ {
+		if _, err := client.RegisterWebSignup(&WebSignup{RequestToken: requestToken, CustomProperties: customPropertiesFixture}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterPayment(b *testing.B) {
+	client := newBenchmarkClient(b, transactionAssessmentFixture)
+	for i := 0; i < b.N; i++ {
+		if _, err := client.RegisterPayment(paymentFixture); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterLogin(b *testing.B) {
+	client := newBenchmarkClient(b, transactionAssessmentFixture)
+	for i := 0; i < b.N; i++ {
+		if _, err := client.RegisterLogin(loginFixture); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterWebLogin(b *testing.B) {
+	client := newBenchmarkClient(b, transactionAssessmentFixture)
+	for i := 0; i < b.N; i++ {
+		if _, err := client.RegisterWebLogin(loginWebFixture); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterFeedback(b *testing.B) {
+	client := newBenchmarkClient(b, nil)
+	for i := 0; i < b.N; i++ {
+		if err := client.RegisterFeedback(AccountTakeover, &now, feedbackIdentifiersFixture); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func (suite *IncogniaTestSuite) mockFeedbackEndpoint(expectedToken string, expectedBody *postFeedbackRequestBody) *httptest.Server {
 	feedbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-type", "application/json")
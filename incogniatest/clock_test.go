@@ -0,0 +1,28 @@
+package incogniatest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClockTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ClockTestSuite) TestClock() {
+	start := time.Date(2024, 7, 22, 15, 20, 0, 0, time.UTC)
+	clock := NewClock(start)
+	suite.Equal(start, clock.Now())
+
+	clock.Advance(90 * time.Second)
+	suite.Equal(start.Add(90*time.Second), clock.Now())
+
+	clock.Set(start)
+	suite.Equal(start, clock.Now())
+}
+
+func TestClockTestSuite(t *testing.T) {
+	suite.Run(t, new(ClockTestSuite))
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNilLimiterNeverBlocks(t *testing.T) {
+	limiter := New(0, 10)
+	if limiter != nil {
+		t.Fatalf("expected nil limiter, got %v", limiter)
+	}
+
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBurstThenRate(t *testing.T) {
+	limiter := New(100, 2)
+	start := time.Now()
+
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("expected the limiter to wait after the burst, took %s", elapsed)
+	}
+}
+
+func TestWaitHonorsContext(t *testing.T) {
+	limiter := New(0.001, 1)
+	limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package incognia
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsWindow = 5 * time.Minute
+	// maxLatencySamples bounds the memory of each operation's stats, and cuts
+	// the window short when more calls than that complete within it.
+	maxLatencySamples         = 10000
+	aggregateHeaderRefreshGap = time.Second
+)
+
+var (
+	ErrUnknownLatencyHeaderMode = errors.New("unknown latency header mode")
+)
+
+// LatencyHeaderMode sets what the X-Incognia-Latency header carries.
+type LatencyHeaderMode string
+
+const (
+	// LatencyHeaderLast sends the latency of the last successful request, in
+	// milliseconds. It is the default.
+	LatencyHeaderLast LatencyHeaderMode = "last"
+	// LatencyHeaderAggregate sends the percentiles of the successful calls in
+	// the stats window, as "p50=12,p95=40,p99=85" in milliseconds.
+	LatencyHeaderAggregate LatencyHeaderMode = "aggregate"
+	LatencyHeaderOff       LatencyHeaderMode = "off"
+)
+
+func (m LatencyHeaderMode) valid() bool {
+	return m == "" || m == LatencyHeaderLast || m == LatencyHeaderAggregate || m == LatencyHeaderOff
+}
+
+// OperationStats summarizes the calls of an operation completed within the
+// stats window. Latencies include retries, and percentiles only count
+// successful calls. Only the latest 10000 calls of each operation are kept,
+// so under heavier load the stats cover less time than the window.
+type OperationStats struct {
+	Calls     int
+	Errors    int
+	Timeouts  int
+	ErrorRate float64
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+}
+
+type Stats struct {
+	Window     time.Duration
+	Operations map[Operation]OperationStats
+}
+
+type latencySample struct {
+	at       time.Time
+	latency  time.Duration
+	failed   bool
+	timedOut bool
+}
+
+// latencyStats keeps the calls of the last window per operation.
+type latencyStats struct {
+	window time.Duration
+	clock  Clock
+
+	mutex    sync.Mutex
+	samples  map[Operation][]latencySample
+	header   string
+	headerAt time.Time
+}
+
+func newLatencyStats(window time.Duration, clock Clock) *latencyStats {
+	if window <= 0 {
+		window = defaultStatsWindow
+	}
+
+	return &latencyStats{window: window, clock: clock, samples: map[Operation][]latencySample{}}
+}
+
+func (s *latencyStats) record(operation Operation, latency time.Duration, err error) {
+	sample := latencySample{at: s.clock.Now(), latency: latency, failed: err != nil, timedOut: isTimeout(err)}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	samples := s.expire(append(s.samples[operation], sample), sample.at)
+	if len(samples) > maxLatencySamples {
+		samples = samples[len(samples)-maxLatencySamples:]
+	}
+	s.samples[operation] = samples
+}
+
+// expire drops the samples older than the window, which are at the start.
+func (s *latencyStats) expire(samples []latencySample, now time.Time) []latencySample {
+	start := 0
+	for start < len(samples) && now.Sub(samples[start].at) > s.window {
+		start++
+	}
+
+	return samples[start:]
+}
+
+func (s *latencyStats) snapshot() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	stats := Stats{Window: s.window, Operations: map[Operation]OperationStats{}}
+	for operation, samples := range s.samples {
+		samples = s.expire(samples, now)
+		s.samples[operation] = samples
+		if len(samples) == 0 {
+			continue
+		}
+
+		opStats := OperationStats{Calls: len(samples)}
+		var latencies []time.Duration
+		for _, sample := range samples {
+			if sample.failed {
+				opStats.Errors++
+			} else {
+				latencies = append(latencies, sample.latency)
+			}
+			if sample.timedOut {
+				opStats.Timeouts++
+			}
+		}
+		opStats.ErrorRate = float64(opStats.Errors) / float64(opStats.Calls)
+		opStats.P50, opStats.P95, opStats.P99 = percentiles(latencies)
+
+		stats.Operations[operation] = opStats
+	}
+
+	return stats
+}
+
+// aggregateHeader returns the percentiles of the successful calls of every
+// operation, recomputed at most once a second once there are any.
+func (s *latencyStats) aggregateHeader() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if s.header != "" && now.Sub(s.headerAt) < aggregateHeaderRefreshGap {
+		return s.header
+	}
+
+	var latencies []time.Duration
+	for operation, samples := range s.samples {
+		samples = s.expire(samples, now)
+		s.samples[operation] = samples
+		for _, sample := range samples {
+			if !sample.failed {
+				latencies = append(latencies, sample.latency)
+			}
+		}
+	}
+
+	s.header = ""
+	if len(latencies) > 0 {
+		p50, p95, p99 := percentiles(latencies)
+		s.header = fmt.Sprintf("p50=%d,p95=%d,p99=%d", p50.Milliseconds(), p95.Milliseconds(), p99.Milliseconds())
+	}
+	s.headerAt = now
+
+	return s.header
+}
+
+// percentiles returns the nearest-rank p50, p95 and p99, sorting latencies.
+func percentiles(latencies []time.Duration) (time.Duration, time.Duration, time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0, 0
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rank := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+	}
+
+	return rank(0.50), rank(0.95), rank(0.99)
+}
+
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// Stats returns the latency, error and timeout stats of each operation over
+// the stats window.
+func (c *Client) Stats() Stats {
+	return c.stats.snapshot()
+}
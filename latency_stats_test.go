@@ -0,0 +1,174 @@
+package incognia
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia/incogniatest"
+)
+
+type LatencyStatsTestSuite struct {
+	suite.Suite
+
+	tokenServer *httptest.Server
+	server      *httptest.Server
+
+	mutex   sync.Mutex
+	headers []string
+}
+
+func (suite *LatencyStatsTestSuite) SetupTest() {
+	suite.headers = nil
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mutex.Lock()
+		suite.headers = append(suite.headers, r.Header.Get(metricsHeader))
+		suite.mutex.Unlock()
+
+		switch r.URL.Query().Get("mode") {
+		case "fail":
+			w.WriteHeader(http.StatusBadRequest)
+		case "slow":
+			time.Sleep(100 * time.Millisecond)
+		default:
+			w.Write([]byte(`{"id":"id","risk_assessment":"low_risk"}`))
+		}
+	}))
+}
+
+func (suite *LatencyStatsTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *LatencyStatsTestSuite) newClient(mode LatencyHeaderMode) *Client {
+	client, err := New(&IncogniaClientConfig{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		LogLevel:      LogLevelOff,
+		Timeout:       30 * time.Millisecond,
+		LatencyHeader: mode,
+	})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Signups = suite.server.URL
+	client.endpoints.Transactions = suite.server.URL
+	return client
+}
+
+func (suite *LatencyStatsTestSuite) call(client *Client, mode string) error {
+	client.endpoints.Transactions = suite.server.URL + "?mode=" + mode
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	return err
+}
+
+func (suite *LatencyStatsTestSuite) TestStatsPerOperation() {
+	client := suite.newClient("")
+
+	for i := 0; i < 6; i++ {
+		suite.NoError(suite.call(client, "ok"))
+	}
+	suite.Error(suite.call(client, "fail"))
+	suite.Error(suite.call(client, "slow"))
+	_, err := client.RegisterSignup("installation-id", nil)
+	suite.NoError(err)
+
+	stats := client.Stats()
+	suite.Equal(defaultStatsWindow, stats.Window)
+	suite.Len(stats.Operations, 2)
+
+	login := stats.Operations[OperationRegisterLogin]
+	suite.Equal(8, login.Calls)
+	suite.Equal(2, login.Errors)
+	suite.Equal(1, login.Timeouts)
+	suite.Equal(0.25, login.ErrorRate)
+	suite.True(login.P50 > 0 && login.P50 <= login.P95 && login.P95 <= login.P99)
+	suite.True(login.P99 < 30*time.Millisecond, "percentiles count only successful calls")
+
+	suite.Equal(1, stats.Operations[OperationRegisterSignup].Calls)
+}
+
+func (suite *LatencyStatsTestSuite) TestSamplesExpire() {
+	clock := incogniatest.NewClock(time.Now())
+	stats := newLatencyStats(time.Minute, clock)
+	stats.record(OperationRegisterPayment, time.Millisecond, nil)
+	suite.Equal(1, stats.snapshot().Operations[OperationRegisterPayment].Calls)
+
+	clock.Advance(time.Minute)
+	suite.Equal(1, stats.snapshot().Operations[OperationRegisterPayment].Calls)
+
+	clock.Advance(time.Second)
+	suite.Empty(stats.snapshot().Operations)
+}
+
+func (suite *LatencyStatsTestSuite) TestLatenciesUseClock() {
+	clock := incogniatest.NewClock(time.Now())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mutex.Lock()
+		suite.headers = append(suite.headers, r.Header.Get(metricsHeader))
+		suite.mutex.Unlock()
+
+		clock.Advance(250 * time.Millisecond)
+		w.Write([]byte(`{"id":"id","risk_assessment":"low_risk"}`))
+	}))
+	defer server.Close()
+
+	client, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, LogLevel: LogLevelOff, Clock: clock})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = server.URL
+
+	for i := 0; i < 2; i++ {
+		_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+		suite.Require().NoError(err)
+	}
+
+	suite.Equal([]string{"", "250"}, suite.headers)
+	login := client.Stats().Operations[OperationRegisterLogin]
+	suite.Equal(250*time.Millisecond, login.P50)
+	suite.Equal(250*time.Millisecond, login.P99)
+}
+
+func (suite *LatencyStatsTestSuite) TestPercentiles() {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	p50, p95, p99 := percentiles(latencies)
+	suite.Equal(50*time.Millisecond, p50)
+	suite.Equal(95*time.Millisecond, p95)
+	suite.Equal(99*time.Millisecond, p99)
+}
+
+func (suite *LatencyStatsTestSuite) TestAggregateHeader() {
+	client := suite.newClient(LatencyHeaderAggregate)
+
+	suite.NoError(suite.call(client, "ok"))
+	suite.NoError(suite.call(client, "ok"))
+
+	suite.Empty(suite.headers[0])
+	suite.Regexp(regexp.MustCompile(`^p50=\d+,p95=\d+,p99=\d+$`), suite.headers[1])
+}
+
+func (suite *LatencyStatsTestSuite) TestHeaderOff() {
+	client := suite.newClient(LatencyHeaderOff)
+
+	suite.NoError(suite.call(client, "ok"))
+	suite.NoError(suite.call(client, "ok"))
+
+	suite.Equal([]string{"", ""}, suite.headers)
+
+	_, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, LatencyHeader: "median"})
+	suite.Equal(ErrUnknownLatencyHeaderMode, err)
+}
+
+func TestLatencyStatsTestSuite(t *testing.T) {
+	suite.Run(t, new(LatencyStatsTestSuite))
+}
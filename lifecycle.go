@@ -0,0 +1,145 @@
+package incognia
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+const operationPing Operation = "ping"
+
+var (
+	ErrClientClosed = errors.New("incognia client is closed")
+)
+
+// begin registers a call in flight, failing once the client is closed.
+func (c *Client) begin() error {
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	c.inFlight++
+	return nil
+}
+
+func (c *Client) end() {
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+
+	c.inFlight--
+	if c.inFlight == 0 && c.drained != nil {
+		close(c.drained)
+		c.drained = nil
+	}
+}
+
+// addCloser registers work started on top of the client, such as a feedback
+// outbox, to be closed with it.
+func (c *Client) addCloser(closer io.Closer) error {
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	c.closers = append(c.closers, closer)
+	return nil
+}
+
+// Close shuts the client down. New calls fail with ErrClientClosed, feedback
+// outboxes opened on the client are closed, calls in flight are waited for,
+// records buffered for the audit sink are written, and idle connections of
+// the transport the client created are closed. The audit sink and the
+// HTTPClient given in the config are left open, since they may be shared.
+// When ctx is done first, Close stops waiting and returns ctx.Err(). Closing
+// a closed client does nothing.
+func (c *Client) Close(ctx context.Context) error {
+	c.lifecycleMutex.Lock()
+	if c.closed {
+		c.lifecycleMutex.Unlock()
+		return nil
+	}
+	c.closed = true
+
+	drained := make(chan struct{})
+	if c.inFlight == 0 {
+		close(drained)
+	} else {
+		c.drained = drained
+	}
+
+	closers := c.closers
+	c.closers = nil
+	c.lifecycleMutex.Unlock()
+
+	var firstErr error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if c.ownedAuditSink != nil {
+		auditStopped := make(chan struct{})
+		go func() {
+			c.ownedAuditSink.stop()
+			close(auditStopped)
+		}()
+		select {
+		case <-auditStopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if c.ownedTransport != nil {
+		c.ownedTransport.CloseIdleConnections()
+	}
+
+	return firstErr
+}
+
+// TokenProvider returns the provider of the client tokens. The default one
+// implements TokenProviderStats.
+func (c *Client) TokenProvider() TokenProvider {
+	return c.tokenProvider
+}
+
+// Ping fetches a token through the TokenProvider, to check credentials and
+// connectivity, for instance in readiness probes. Providers caching tokens
+// may answer without reaching the API.
+func (c *Client) Ping(ctx context.Context) (err error) {
+	if err := c.begin(); err != nil {
+		return err
+	}
+	defer c.end()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- c.panicError(operationPing, r)
+			}
+		}()
+
+		_, err := c.tokenProvider.GetToken()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
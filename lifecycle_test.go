@@ -0,0 +1,152 @@
+package incognia
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LifecycleTestSuite struct {
+	suite.Suite
+
+	client      *Client
+	tokenServer *httptest.Server
+	server      *httptest.Server
+	release     chan struct{}
+	started     chan struct{}
+}
+
+func (suite *LifecycleTestSuite) SetupTest() {
+	suite.release = make(chan struct{})
+	suite.started = make(chan struct{}, 10)
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.started <- struct{}{}
+		<-suite.release
+		w.Write([]byte(`{"id":"id","risk_assessment":"low_risk"}`))
+	}))
+
+	suite.client = suite.newClient(nil)
+}
+
+func (suite *LifecycleTestSuite) TearDownTest() {
+	select {
+	case <-suite.release:
+	default:
+		close(suite.release)
+	}
+	suite.tokenServer.Close()
+	suite.server.Close()
+}
+
+func (suite *LifecycleTestSuite) newClient(auditSink AuditSink) *Client {
+	client, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, LogLevel: LogLevelOff, AuditSink: auditSink})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.server.URL
+	client.endpoints.Feedback = suite.server.URL
+	return client
+}
+
+func (suite *LifecycleTestSuite) TestCloseDrainsInFlightCalls() {
+	sink := &recordingAuditSink{}
+	client := suite.newClient(sink)
+
+	callDone := make(chan error, 1)
+	go func() {
+		_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+		callDone <- err
+	}()
+	<-suite.started
+
+	closeDone := make(chan error, 1)
+	go func() { closeDone <- client.Close(context.Background()) }()
+
+	select {
+	case <-closeDone:
+		suite.Fail("Close returned before the call in flight finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Equal(ErrClientClosed, err)
+
+	close(suite.release)
+	suite.NoError(<-callDone)
+	suite.NoError(<-closeDone)
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	suite.Len(sink.records, 1, "the audit sink is flushed")
+	suite.False(sink.closed, "the caller closes its own sink")
+
+	suite.NoError(client.Close(context.Background()))
+	suite.Equal(ErrClientClosed, client.Ping(context.Background()))
+}
+
+func (suite *LifecycleTestSuite) TestClosesOnlyTransportsItCreated() {
+	client := suite.newClient(nil)
+	suite.Require().NotNil(client.ownedTransport)
+	suite.Same(client.ownedTransport, client.netClient.(*http.Client).Transport)
+	suite.Same(client.ownedTransport, client.tokenClient.netClient.Transport)
+
+	shared, err := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret, HTTPClient: &http.Client{}})
+	suite.Require().NoError(err)
+	suite.Nil(shared.ownedTransport, "an HTTPClient given in the config is left open")
+}
+
+func (suite *LifecycleTestSuite) TestCloseStopsWaitingWhenContextIsDone() {
+	go suite.client.RegisterLogin(&Login{AccountID: "account-id"})
+	<-suite.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	suite.Equal(context.DeadlineExceeded, suite.client.Close(ctx))
+}
+
+func (suite *LifecycleTestSuite) TestCloseClosesOutboxes() {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	outbox, err := NewFeedbackOutbox(suite.client, &FeedbackOutboxConfig{Path: filepath.Join(dir, "outbox")})
+	suite.Require().NoError(err)
+	suite.NoError(outbox.RegisterFeedback(AccountTakeover, &now, &FeedbackIdentifiers{AccountID: "account-id"}))
+	<-suite.started
+
+	suite.NoError(suite.client.Close(context.Background()))
+	suite.Equal(ErrFeedbackOutboxClosed, outbox.RegisterFeedback(AccountTakeover, &now, nil))
+	suite.Equal(1, outbox.Pending())
+
+	_, err = NewFeedbackOutbox(suite.client, &FeedbackOutboxConfig{Path: filepath.Join(dir, "other")})
+	suite.Equal(ErrClientClosed, err)
+}
+
+func (suite *LifecycleTestSuite) TestPing() {
+	suite.NoError(suite.client.Ping(context.Background()))
+
+	client, _ := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: "wrong-secret"})
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = unauthorized.URL
+	suite.Equal(ErrInvalidCredentials, client.Ping(context.Background()))
+
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.server.URL
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	suite.Equal(context.DeadlineExceeded, client.Ping(ctx))
+}
+
+func TestLifecycleTestSuite(t *testing.T) {
+	suite.Run(t, new(LifecycleTestSuite))
+}
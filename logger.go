@@ -0,0 +1,60 @@
+package incognia
+
+import (
+	"log"
+	"os"
+)
+
+// Logger is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type LogLevel string
+
+const (
+	LogLevelOff   LogLevel = "off"
+	LogLevelError LogLevel = "error"
+	LogLevelDebug LogLevel = "debug"
+)
+
+func (l LogLevel) valid() bool {
+	return l == "" || l == LogLevelOff || l == LogLevelError || l == LogLevelDebug
+}
+
+type clientLogger struct {
+	logger Logger
+	level  LogLevel
+}
+
+// newClientLogger logs to stderr when only a level is given, and at the error
+// level when only a logger is given.
+func newClientLogger(logger Logger, level LogLevel) *clientLogger {
+	if level == LogLevelOff || (logger == nil && level == "") {
+		return nil
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr, "incognia: ", log.LstdFlags)
+	}
+
+	if level == "" {
+		level = LogLevelError
+	}
+
+	return &clientLogger{logger: logger, level: level}
+}
+
+func (l *clientLogger) errorf(format string, v ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.logger.Printf(format, v...)
+}
+
+func (l *clientLogger) debugf(format string, v ...interface{}) {
+	if l == nil || l.level != LogLevelDebug {
+		return
+	}
+	l.logger.Printf(format, v...)
+}
@@ -0,0 +1,268 @@
+package incognia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMultiClientConfigIsNil     = errors.New("multi client config is required")
+	ErrTenantConfigIsNil          = errors.New("tenant config is required")
+	ErrMissingTenant              = errors.New("missing tenant")
+	ErrTenantNotFound             = errors.New("tenant not found")
+	ErrTenantAlreadyExists        = errors.New("tenant already exists")
+	ErrTenantConcurrencyLimit     = errors.New("tenant concurrency limit reached")
+	ErrTenantHTTPClientNotAllowed = errors.New("tenants share the multi client transport, HTTPClient must not be set")
+)
+
+type TenantConfig struct {
+	// ClientConfig holds the tenant credentials, token provider and timeouts.
+	// HTTPClient must be left empty, since tenants share one transport.
+	ClientConfig *IncogniaClientConfig
+	// MaxConcurrentRequests limits in-flight calls for the tenant. Calls over
+	// the limit fail with ErrTenantConcurrencyLimit. Zero means no limit.
+	MaxConcurrentRequests int
+}
+
+type MultiClientConfig struct {
+	Tenants map[string]*TenantConfig
+	// Transport is shared by every tenant client, for token requests too. A
+	// clone of http.DefaultTransport is used when it is nil.
+	Transport http.RoundTripper
+}
+
+// MultiClient routes each call to the Client of its tenant, creating tenant
+// clients lazily on first use.
+type MultiClient struct {
+	transport http.RoundTripper
+	mutex     sync.RWMutex
+	tenants   map[string]*tenant
+}
+
+type tenant struct {
+	config    TenantConfig
+	once      sync.Once
+	client    *Client
+	err       error
+	semaphore chan struct{}
+}
+
+func NewMultiClient(config *MultiClientConfig) (*MultiClient, error) {
+	if config == nil {
+		return nil, ErrMultiClientConfigIsNil
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	m := &MultiClient{transport: transport, tenants: map[string]*tenant{}}
+	for key, tenantConfig := range config.Tenants {
+		if err := m.AddTenant(key, tenantConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *MultiClient) AddTenant(key string, config *TenantConfig) error {
+	if key == "" {
+		return ErrMissingTenant
+	}
+
+	if config == nil || config.ClientConfig == nil {
+		return fmt.Errorf("%s: %w", key, ErrTenantConfigIsNil)
+	}
+
+	if config.ClientConfig.CredentialsProvider == nil && (config.ClientConfig.ClientID == "" || config.ClientConfig.ClientSecret == "") {
+		return fmt.Errorf("%s: %w", key, ErrMissingClientIDOrClientSecret)
+	}
+
+	if config.ClientConfig.HTTPClient != nil {
+		return fmt.Errorf("%s: %w", key, ErrTenantHTTPClientNotAllowed)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.tenants[key]; ok {
+		return fmt.Errorf("%s: %w", key, ErrTenantAlreadyExists)
+	}
+
+	t := &tenant{config: *config}
+	if config.MaxConcurrentRequests > 0 {
+		t.semaphore = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+	m.tenants[key] = t
+
+	return nil
+}
+
+// RemoveTenant stops routing calls to the tenant and closes its client, as
+// Client.Close does: calls already in flight are allowed to finish, unless
+// ctx is done first.
+func (m *MultiClient) RemoveTenant(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	t, ok := m.tenants[key]
+	if !ok {
+		m.mutex.Unlock()
+		return fmt.Errorf("%s: %w", key, ErrTenantNotFound)
+	}
+	delete(m.tenants, key)
+	m.mutex.Unlock()
+
+	t.once.Do(func() { t.err = fmt.Errorf("%s: %w", key, ErrTenantNotFound) })
+	if t.client == nil {
+		return nil
+	}
+
+	return t.client.Close(ctx)
+}
+
+func (m *MultiClient) Tenants() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	keys := make([]string, 0, len(m.tenants))
+	for key := range m.tenants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Client returns the tenant client, creating it on first use.
+func (m *MultiClient) Client(key string) (*Client, error) {
+	t, err := m.tenant(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.clientFor(t)
+}
+
+func (m *MultiClient) tenant(key string) (*tenant, error) {
+	if key == "" {
+		return nil, ErrMissingTenant
+	}
+
+	m.mutex.RLock()
+	t, ok := m.tenants[key]
+	m.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrTenantNotFound)
+	}
+
+	return t, nil
+}
+
+func (m *MultiClient) clientFor(t *tenant) (*Client, error) {
+	t.once.Do(func() {
+		clientConfig := *t.config.ClientConfig
+
+		timeout := clientConfig.Timeout
+		if timeout == 0 {
+			timeout = defaultNetClientTimeout
+		}
+		clientConfig.HTTPClient = &http.Client{Timeout: timeout, Transport: m.transport}
+
+		t.client, t.err = New(&clientConfig)
+		if t.err == nil {
+			t.client.tokenClient.netClient.Transport = m.transport
+		}
+	})
+
+	return t.client, t.err
+}
+
+func (m *MultiClient) do(key string, call func(*Client) error) error {
+	t, err := m.tenant(key)
+	if err != nil {
+		return err
+	}
+
+	client, err := m.clientFor(t)
+	if err != nil {
+		return err
+	}
+
+	if t.semaphore != nil {
+		select {
+		case t.semaphore <- struct{}{}:
+			defer func() { <-t.semaphore }()
+		default:
+			return fmt.Errorf("%s: %w", key, ErrTenantConcurrencyLimit)
+		}
+	}
+
+	return call(client)
+}
+
+func (m *MultiClient) RegisterSignup(tenant string, installationID string, address *Address) (ret *SignupAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterSignup(installationID, address)
+		return err
+	})
+	return ret, err
+}
+
+func (m *MultiClient) RegisterSignupWithParams(tenant string, params *Signup) (ret *SignupAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterSignupWithParams(params)
+		return err
+	})
+	return ret, err
+}
+
+func (m *MultiClient) RegisterWebSignup(tenant string, params *WebSignup) (ret *SignupAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterWebSignup(params)
+		return err
+	})
+	return ret, err
+}
+
+func (m *MultiClient) RegisterFeedback(tenant string, feedbackEvent FeedbackType, occurredAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) error {
+	return m.do(tenant, func(c *Client) error {
+		return c.RegisterFeedback(feedbackEvent, occurredAt, feedbackIdentifiers)
+	})
+}
+
+func (m *MultiClient) RegisterFeedbackWithExpiration(tenant string, feedbackEvent FeedbackType, occurredAt *time.Time, expiresAt *time.Time, feedbackIdentifiers *FeedbackIdentifiers) error {
+	return m.do(tenant, func(c *Client) error {
+		return c.RegisterFeedbackWithExpiration(feedbackEvent, occurredAt, expiresAt, feedbackIdentifiers)
+	})
+}
+
+func (m *MultiClient) RegisterPayment(tenant string, payment *Payment) (ret *TransactionAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterPayment(payment)
+		return err
+	})
+	return ret, err
+}
+
+func (m *MultiClient) RegisterLogin(tenant string, login *Login) (ret *TransactionAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterLogin(login)
+		return err
+	})
+	return ret, err
+}
+
+func (m *MultiClient) RegisterWebLogin(tenant string, webLogin *WebLogin) (ret *TransactionAssessment, err error) {
+	err = m.do(tenant, func(c *Client) error {
+		ret, err = c.RegisterWebLogin(webLogin)
+		return err
+	})
+	return ret, err
+}
@@ -0,0 +1,201 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MultiClientTestSuite struct {
+	suite.Suite
+
+	server           *httptest.Server
+	originalEndpoint string
+	tokenRequests    map[string]int
+	mutex            sync.Mutex
+	release          chan struct{}
+}
+
+func (suite *MultiClientTestSuite) SetupTest() {
+	suite.tokenRequests = map[string]int{}
+	suite.release = nil
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(tokenEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		username, _, _ := r.BasicAuth()
+
+		suite.mutex.Lock()
+		suite.tokenRequests[username]++
+		suite.mutex.Unlock()
+
+		res, _ := json.Marshal(map[string]string{
+			"access_token": "token-" + username,
+			"expires_in":   tokenExpiresIn,
+			"token_type":   "Bearer",
+		})
+		w.Write(res)
+	})
+	mux.HandleFunc(transactionsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		if suite.release != nil {
+			<-suite.release
+		}
+		_, accessToken := readAuthorizationHeader(r)
+		res, _ := json.Marshal(TransactionAssessment{ID: accessToken, RiskAssessment: LowRisk})
+		w.Write(res)
+	})
+	suite.server = httptest.NewServer(mux)
+
+	suite.originalEndpoint = baseEndpoint
+	baseEndpoint = suite.server.URL
+}
+
+func (suite *MultiClientTestSuite) TearDownTest() {
+	baseEndpoint = suite.originalEndpoint
+	suite.server.Close()
+}
+
+func tenantConfig(id string) *TenantConfig {
+	return &TenantConfig{ClientConfig: &IncogniaClientConfig{ClientID: id, ClientSecret: id + "-secret"}}
+}
+
+func (suite *MultiClientTestSuite) TestRoutesByTenant() {
+	multiClient, err := NewMultiClient(&MultiClientConfig{Tenants: map[string]*TenantConfig{
+		"brand-a": tenantConfig("client-a"),
+		"brand-b": tenantConfig("client-b"),
+	}})
+	suite.Require().NoError(err)
+
+	assessment, err := multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+	suite.NoError(err)
+	suite.Equal("token-client-a", assessment.ID)
+
+	assessment, err = multiClient.RegisterLogin("brand-b", &Login{AccountID: "account-id"})
+	suite.NoError(err)
+	suite.Equal("token-client-b", assessment.ID)
+
+	_, err = multiClient.RegisterLogin("brand-c", &Login{AccountID: "account-id"})
+	suite.True(errors.Is(err, ErrTenantNotFound))
+}
+
+func (suite *MultiClientTestSuite) TestClientsAreCreatedLazilyAndShareTransport() {
+	multiClient, _ := NewMultiClient(&MultiClientConfig{Tenants: map[string]*TenantConfig{
+		"brand-a": tenantConfig("client-a"),
+		"brand-b": tenantConfig("client-b"),
+	}})
+
+	suite.Nil(multiClient.tenants["brand-a"].client)
+
+	first, err := multiClient.Client("brand-a")
+	suite.NoError(err)
+	second, _ := multiClient.Client("brand-a")
+	suite.Same(first, second)
+
+	other, _ := multiClient.Client("brand-b")
+	suite.Same(first.netClient.(*http.Client).Transport, other.netClient.(*http.Client).Transport)
+	suite.Same(first.netClient.(*http.Client).Transport, first.tokenClient.netClient.Transport)
+	suite.Same(first.netClient.(*http.Client).Transport, other.tokenClient.netClient.Transport)
+}
+
+func (suite *MultiClientTestSuite) TestAddAndRemoveTenantsAtRuntime() {
+	multiClient, _ := NewMultiClient(&MultiClientConfig{})
+	suite.Empty(multiClient.Tenants())
+
+	suite.NoError(multiClient.AddTenant("brand-a", tenantConfig("client-a")))
+	suite.True(errors.Is(multiClient.AddTenant("brand-a", tenantConfig("client-a")), ErrTenantAlreadyExists))
+	suite.Equal([]string{"brand-a"}, multiClient.Tenants())
+
+	_, err := multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+	suite.NoError(err)
+
+	client, _ := multiClient.Client("brand-a")
+	suite.NoError(multiClient.RemoveTenant(context.Background(), "brand-a"))
+	suite.True(errors.Is(multiClient.RemoveTenant(context.Background(), "brand-a"), ErrTenantNotFound))
+	suite.Equal(ErrClientClosed, client.Ping(context.Background()), "the tenant client is closed")
+
+	_, err = multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+	suite.True(errors.Is(err, ErrTenantNotFound))
+
+	suite.NoError(multiClient.AddTenant("brand-b", tenantConfig("client-b")))
+	suite.NoError(multiClient.RemoveTenant(context.Background(), "brand-b"), "tenants never used have no client to close")
+}
+
+func (suite *MultiClientTestSuite) TestRemovingTenantKeepsSharedAuditSinkOpen() {
+	for _, shared := range []bool{false, true} {
+		sink := &recordingAuditSink{}
+		var auditSink AuditSink = sink
+		if shared {
+			auditSink = NewAsyncAuditSink(sink, 0)
+		}
+
+		multiClient, _ := NewMultiClient(&MultiClientConfig{})
+		for _, key := range []string{"brand-a", "brand-b"} {
+			config := tenantConfig("client-" + key)
+			config.ClientConfig.AuditSink = auditSink
+			suite.Require().NoError(multiClient.AddTenant(key, config))
+		}
+
+		_, err := multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+		suite.NoError(err)
+		suite.NoError(multiClient.RemoveTenant(context.Background(), "brand-a"))
+
+		_, err = multiClient.RegisterPayment("brand-b", &Payment{AccountID: "account-id"})
+		suite.NoError(err)
+		_, err = multiClient.RegisterLogin("brand-b", &Login{AccountID: "account-id"})
+		suite.NoError(err)
+		suite.NoError(multiClient.RemoveTenant(context.Background(), "brand-b"))
+
+		if async, ok := auditSink.(*AsyncAuditSink); ok {
+			suite.NoError(async.Close())
+			suite.Zero(async.Failed())
+		}
+		suite.Len(sink.recorded(), 3, "the other tenant keeps auditing")
+		suite.Equal(shared, sink.closed, "only the caller closes its sink")
+	}
+}
+
+func (suite *MultiClientTestSuite) TestInvalidTenantConfig() {
+	multiClient, _ := NewMultiClient(&MultiClientConfig{})
+
+	suite.Equal(ErrMissingTenant, multiClient.AddTenant("", tenantConfig("client-a")))
+	suite.True(errors.Is(multiClient.AddTenant("brand-a", nil), ErrTenantConfigIsNil))
+	suite.True(errors.Is(multiClient.AddTenant("brand-a", &TenantConfig{ClientConfig: &IncogniaClientConfig{ClientID: "id"}}), ErrMissingClientIDOrClientSecret))
+	suite.True(errors.Is(multiClient.AddTenant("brand-a", &TenantConfig{ClientConfig: &IncogniaClientConfig{ClientID: "id", ClientSecret: "secret", HTTPClient: &http.Client{}}}), ErrTenantHTTPClientNotAllowed))
+
+	_, err := NewMultiClient(nil)
+	suite.Equal(ErrMultiClientConfigIsNil, err)
+}
+
+func (suite *MultiClientTestSuite) TestConcurrencyLimit() {
+	config := tenantConfig("client-a")
+	config.MaxConcurrentRequests = 1
+	multiClient, _ := NewMultiClient(&MultiClientConfig{Tenants: map[string]*TenantConfig{"brand-a": config}})
+
+	_, err := multiClient.Client("brand-a")
+	suite.Require().NoError(err)
+
+	suite.release = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+		done <- err
+	}()
+
+	suite.Eventually(func() bool { return len(multiClient.tenants["brand-a"].semaphore) == 1 }, time.Second, time.Millisecond)
+
+	_, err = multiClient.RegisterPayment("brand-a", &Payment{AccountID: "account-id"})
+	suite.True(errors.Is(err, ErrTenantConcurrencyLimit))
+
+	close(suite.release)
+	suite.NoError(<-done)
+}
+
+func TestMultiClientTestSuite(t *testing.T) {
+	suite.Run(t, new(MultiClientTestSuite))
+}
@@ -0,0 +1,48 @@
+package incognia
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned by a call that panicked, for instance inside a
+// custom TokenProvider. Its message is the panic value, and Stack is the
+// stack of the panicking goroutine.
+type PanicError struct {
+	Operation Operation
+	Value     interface{}
+	Stack     []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v", e.Value)
+}
+
+// Unwrap returns the panic value when it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func newPanicError(operation Operation, value interface{}) *PanicError {
+	return &PanicError{Operation: operation, Value: value, Stack: debug.Stack()}
+}
+
+// panicError turns a recovered panic into a PanicError, logging it and
+// reporting it to the OnPanic hook.
+func (c *Client) panicError(operation Operation, value interface{}) error {
+	err := newPanicError(operation, value)
+	if c == nil {
+		return err
+	}
+
+	c.logger.errorf("%s panicked: %v\n%s", operation, value, err.Stack)
+	if c.onPanic != nil {
+		func() {
+			defer func() { recover() }()
+			c.onPanic(err)
+		}()
+	}
+
+	return err
+}
@@ -0,0 +1,201 @@
+package incognia
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultMaxResponseSize = 1 << 20
+	// maxPooledBufferSize keeps buffers grown by unusually large requests out
+	// of the pool.
+	maxPooledBufferSize    = 64 << 10
+	maxDrainedResponseSize = 4 << 10
+)
+
+var (
+	ErrResponseTooLarge = errors.New("incognia response exceeds the maximum size")
+)
+
+var (
+	requestBufferPool = sync.Pool{New: func() interface{} { return &requestBuffer{} }}
+	gzipWriterPool    = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	gzipReaderPool    sync.Pool
+)
+
+// requestBuffer holds an encoded request body. It goes back to the pool
+// once its owner and every request body reading it released it, since the
+// transport may still read a body after the response is returned.
+type requestBuffer struct {
+	buffer bytes.Buffer
+	refs   int32
+}
+
+func newRequestBuffer() *requestBuffer {
+	buffer := requestBufferPool.Get().(*requestBuffer)
+	buffer.refs = 1
+	return buffer
+}
+
+// encodeRequestBody encodes requestBody with codec. The StandardCodec
+// encodes straight into the buffer, as json.Marshal does but without the
+// trailing newline of json.Encoder.
+func encodeRequestBody(codec Codec, requestBody interface{}) (*requestBuffer, error) {
+	buffer := newRequestBuffer()
+
+	if _, ok := codec.(StandardCodec); ok {
+		if err := json.NewEncoder(&buffer.buffer).Encode(requestBody); err != nil {
+			buffer.release()
+			return nil, err
+		}
+		buffer.buffer.Truncate(buffer.buffer.Len() - 1)
+		return buffer, nil
+	}
+
+	encoded, err := codec.Marshal(requestBody)
+	if err != nil {
+		buffer.release()
+		return nil, err
+	}
+	buffer.buffer.Write(encoded)
+
+	return buffer, nil
+}
+
+// gzipRequestBody returns a new buffer with body gzipped.
+func gzipRequestBody(body []byte) (*requestBuffer, error) {
+	buffer := newRequestBuffer()
+	writer := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(writer)
+
+	writer.Reset(&buffer.buffer)
+	if _, err := writer.Write(body); err != nil {
+		buffer.release()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		buffer.release()
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+func (b *requestBuffer) bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+func (b *requestBuffer) release() {
+	if atomic.AddInt32(&b.refs, -1) != 0 {
+		return
+	}
+
+	if b.buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.buffer.Reset()
+	requestBufferPool.Put(b)
+}
+
+// body returns a request body reading the buffer, which releases it when
+// closed.
+func (b *requestBuffer) body() io.ReadCloser {
+	atomic.AddInt32(&b.refs, 1)
+	body := &requestBody{buffer: b}
+	body.reader.Reset(b.bytes())
+	return body
+}
+
+type requestBody struct {
+	reader bytes.Reader
+	buffer *requestBuffer
+	closed int32
+}
+
+func (r *requestBody) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// WriteTo lets the transport copy the body without a buffer.
+func (r *requestBody) WriteTo(w io.Writer) (int64, error) {
+	return r.reader.WriteTo(w)
+}
+
+func (r *requestBody) Close() error {
+	if atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		r.buffer.release()
+	}
+	return nil
+}
+
+// limitedReader fails with ErrResponseTooLarge once more than remaining
+// bytes are read.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrResponseTooLarge
+	}
+
+	return n, err
+}
+
+// responseBuffer reads response bodies up to a maximum size.
+type responseBuffer struct {
+	bytes.Buffer
+	limited limitedReader
+}
+
+var responseBufferPool = sync.Pool{New: func() interface{} { return &responseBuffer{} }}
+
+func readResponse(reader io.Reader, maxSize int64) (*responseBuffer, error) {
+	buffer := responseBufferPool.Get().(*responseBuffer)
+	buffer.limited = limitedReader{reader: reader, remaining: maxSize}
+
+	_, err := buffer.ReadFrom(&buffer.limited)
+	buffer.limited.reader = nil
+
+	return buffer, err
+}
+
+func (b *responseBuffer) release() {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	responseBufferPool.Put(b)
+}
+
+func getGzipReader(reader io.Reader) (*gzip.Reader, error) {
+	if pooled, ok := gzipReaderPool.Get().(*gzip.Reader); ok {
+		if err := pooled.Reset(reader); err != nil {
+			gzipReaderPool.Put(pooled)
+			return nil, err
+		}
+		return pooled, nil
+	}
+
+	return gzip.NewReader(reader)
+}
+
+func putGzipReader(reader *gzip.Reader) {
+	reader.Close()
+	gzipReaderPool.Put(reader)
+}
@@ -0,0 +1,133 @@
+package incognia
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PoolTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PoolTestSuite) TestEncodeRequestBodyMatchesMarshal() {
+	for _, requestBody := range []interface{}{
+		postPaymentRequestBodyFixture,
+		postLoginRequestBodyFixture,
+		map[string]string{"html": "<a href=\"x\">&</a>"},
+		nil,
+	} {
+		expected, err := json.Marshal(requestBody)
+		suite.Require().NoError(err)
+
+		body, err := encodeRequestBody(StandardCodec{}, requestBody)
+		suite.Require().NoError(err)
+		suite.Equal(string(expected), string(body.bytes()))
+		body.release()
+	}
+
+	_, err := encodeRequestBody(StandardCodec{}, map[string]interface{}{"channel": make(chan int)})
+	suite.Error(err)
+}
+
+func (suite *PoolTestSuite) TestRequestBufferIsReleasedByItsLastReader() {
+	buffer, err := encodeRequestBody(StandardCodec{}, map[string]string{"id": "id"})
+	suite.Require().NoError(err)
+
+	first := buffer.body()
+	second := buffer.body()
+	buffer.release()
+	suite.Equal(int32(2), atomic.LoadInt32(&buffer.refs))
+
+	read, err := ioutil.ReadAll(first)
+	suite.NoError(err)
+	suite.Equal(`{"id":"id"}`, string(read))
+	suite.NoError(first.Close())
+	suite.NoError(first.Close())
+	suite.Equal(int32(1), atomic.LoadInt32(&buffer.refs), "closing twice releases once")
+
+	read, err = ioutil.ReadAll(second)
+	suite.NoError(err)
+	suite.Equal(`{"id":"id"}`, string(read), "every body reads from the start")
+	suite.NoError(second.Close())
+	suite.Equal(int32(0), atomic.LoadInt32(&buffer.refs))
+}
+
+func (suite *PoolTestSuite) TestReadResponse() {
+	body, err := readResponse(strings.NewReader("0123456789"), 10)
+	suite.NoError(err)
+	suite.Equal("0123456789", body.String())
+	body.release()
+
+	body, err = readResponse(strings.NewReader("0123456789"), 9)
+	suite.True(errors.Is(err, ErrResponseTooLarge))
+	body.release()
+}
+
+func (suite *PoolTestSuite) TestLargeResponsesFail() {
+	var calls int32
+	tokenServer := mockTokenEndpoint(token, tokenExpiresIn)
+	defer tokenServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"id":"id","risk_assessment":"low_risk","evidence":{"padding":"` + strings.Repeat("x", 2048) + `"}}`))
+	}))
+	defer server.Close()
+
+	client, err := New(&IncogniaClientConfig{
+		ClientID:        clientID,
+		ClientSecret:    clientSecret,
+		LogLevel:        LogLevelOff,
+		MaxResponseSize: 1024,
+		MaxRetries:      1,
+		RetryBackoff:    time.Millisecond,
+	})
+	suite.Require().NoError(err)
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = tokenServer.URL
+	client.endpoints.Transactions = server.URL
+
+	_, err = client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.True(errors.Is(err, ErrResponseTooLarge))
+	suite.Equal(int32(1), atomic.LoadInt32(&calls), "too large responses are not retried")
+
+	client.maxResponseSize = defaultMaxResponseSize
+	assessment, err := client.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.Require().NoError(err)
+	suite.Equal(strings.Repeat("x", 2048), assessment.Evidence["padding"])
+}
+
+func (suite *PoolTestSuite) TestDecodeResponse() {
+	for _, codec := range []Codec{StandardCodec{}, &countingCodec{}} {
+		client := &Client{codec: codec, maxResponseSize: 10}
+
+		var decoded map[string]string
+		suite.NoError(client.decodeResponse(strings.NewReader(`{"a":"bc"}`), &decoded))
+		suite.Equal(map[string]string{"a": "bc"}, decoded)
+
+		suite.NoError(client.decodeResponse(strings.NewReader(""), &decoded), "empty bodies decode nothing")
+		suite.NoError(client.decodeResponse(strings.NewReader(`{"a":"bc"}`), nil))
+
+		err := client.decodeResponse(strings.NewReader(`{"a":"bcd"}`), &decoded)
+		suite.True(errors.Is(err, ErrResponseTooLarge))
+
+		err = client.decodeResponse(strings.NewReader(`{"a":"bc"} `), nil)
+		suite.True(errors.Is(err, ErrResponseTooLarge), "the whole body counts, even after the value")
+
+		client.maxResponseSize = defaultMaxResponseSize
+		suite.NoError(client.decodeResponse(strings.NewReader("{\"a\":\"bc\"}\n\t "), &decoded))
+		suite.Error(client.decodeResponse(strings.NewReader(`{"a":"bc"} x`), &decoded))
+		suite.Error(client.decodeResponse(strings.NewReader(`{"a":"bc"}{}`), &decoded))
+	}
+}
+
+func TestPoolTestSuite(t *testing.T) {
+	suite.Run(t, new(PoolTestSuite))
+}
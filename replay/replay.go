@@ -0,0 +1,418 @@
+// Package replay re-sends requests recorded in an audit log and reports how
+// their assessments changed.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+
+	"repo.incognia.com/go/incognia"
+	"repo.incognia.com/go/incognia/internal/ratelimit"
+)
+
+const maxRecordSize = 10 << 20
+
+var (
+	ErrConfigIsNil   = errors.New("replay config is required")
+	ErrMissingClient = errors.New("missing client")
+)
+
+type Config struct {
+	Client *incognia.Client
+	// Workers is how many requests are in flight at once. Defaults to 1.
+	Workers int
+	// RateLimit is the maximum requests per second. Zero means no limit.
+	RateLimit float64
+	// PolicyID, when set, replaces the policy of every replayed request.
+	PolicyID string
+	// Eval, when set, replaces the eval parameter of replayed payments and
+	// logins.
+	Eval *bool
+}
+
+type Outcome struct {
+	RiskAssessment incognia.Assessment `json:"risk_assessment"`
+	Reasons        []incognia.Reason   `json:"reasons,omitempty"`
+	Actions        []incognia.Action   `json:"actions,omitempty"`
+}
+
+type Result struct {
+	// Index is the position of the record in the log, starting at 1.
+	Index     int                `json:"index"`
+	Operation incognia.Operation `json:"operation"`
+	Original  *Outcome           `json:"original,omitempty"`
+	Replayed  *Outcome           `json:"replayed,omitempty"`
+	// Changes lists the fields that differ: risk_assessment, reasons and
+	// actions.
+	Changes []string `json:"changes,omitempty"`
+	// SkipReason is set for records that were not replayed.
+	SkipReason string `json:"skip_reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (r Result) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+type Report struct {
+	Total    int `json:"total"`
+	Replayed int `json:"replayed"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+	Changed  int `json:"changed"`
+	// Transitions counts replayed records by original and then replayed risk
+	// assessment.
+	Transitions map[incognia.Assessment]map[incognia.Assessment]int `json:"transitions"`
+	Results     []Result                                            `json:"results"`
+}
+
+// ReadRecords reads an audit log written by incognia.JSONLinesFileSink.
+func ReadRecords(r io.Reader) ([]*incognia.AuditRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	var records []*incognia.AuditRecord
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := &incognia.AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Replay re-sends the assessment requests among records and compares the new
+// assessments with the recorded ones. Feedbacks, records of failed calls and
+// records with redacted values are skipped. A failed request is reported in its result and does not stop
+// the others. When ctx is done, the remaining records are reported as failed
+// and ctx.Err() is returned along with the report.
+func Replay(ctx context.Context, config *Config, records []*incognia.AuditRecord) (*Report, error) {
+	if config == nil {
+		return nil, ErrConfigIsNil
+	}
+
+	if config.Client == nil {
+		return nil, ErrMissingClient
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	limiter := ratelimit.New(config.RateLimit, 1)
+	results := make([]Result, len(records))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = replayRecord(ctx, config, limiter, index, records[index])
+			}
+		}()
+	}
+
+	for index := range records {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return newReport(results), ctx.Err()
+}
+
+func replayRecord(ctx context.Context, config *Config, limiter *ratelimit.Limiter, index int, record *incognia.AuditRecord) Result {
+	result := Result{Index: index + 1, Operation: record.Operation}
+
+	if reason := skipReason(record); reason != "" {
+		result.SkipReason = reason
+		return result
+	}
+
+	original, err := decodeOutcome(record.Response)
+	if err != nil {
+		result.Error = fmt.Sprintf("recorded response: %v", err)
+		return result
+	}
+	result.Original = original
+
+	replayed, err := override(record, config)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	response, err := config.Client.Resend(ctx, replayed)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Replayed, err = decodeOutcome(response)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Changes = changes(result.Original, result.Replayed)
+
+	return result
+}
+
+func skipReason(record *incognia.AuditRecord) string {
+	switch {
+	case record.Operation == incognia.OperationRegisterFeedback:
+		return "feedbacks are not replayed"
+	case record.Error != "":
+		return "recorded call failed"
+	case len(record.Response) == 0:
+		return "no recorded response"
+	case record.Redacted:
+		return "recorded request has redacted values"
+	default:
+		return ""
+	}
+}
+
+// override returns a copy of record with the configured policy and eval.
+func override(record *incognia.AuditRecord, config *Config) (*incognia.AuditRecord, error) {
+	replayed := *record
+
+	if config.PolicyID != "" {
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(record.Request, &body); err != nil {
+			return nil, fmt.Errorf("recorded request: %w", err)
+		}
+
+		policyID, _ := json.Marshal(config.PolicyID)
+		body["policy_id"] = policyID
+
+		request, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		replayed.Request = request
+	}
+
+	isTransaction := record.Operation == incognia.OperationRegisterPayment ||
+		record.Operation == incognia.OperationRegisterLogin ||
+		record.Operation == incognia.OperationRegisterWebLogin
+
+	if config.Eval != nil && isTransaction {
+		recordURL, err := url.Parse(record.URL)
+		if err != nil {
+			return nil, fmt.Errorf("recorded url: %w", err)
+		}
+
+		query := recordURL.Query()
+		query.Set("eval", fmt.Sprintf("%t", *config.Eval))
+		recordURL.RawQuery = query.Encode()
+		replayed.URL = recordURL.String()
+	}
+
+	return &replayed, nil
+}
+
+// decodeOutcome reads the fields shared by signup and transaction
+// assessments.
+func decodeOutcome(response json.RawMessage) (*Outcome, error) {
+	outcome := &Outcome{}
+	if len(response) == 0 {
+		return outcome, nil
+	}
+
+	if err := json.Unmarshal(response, outcome); err != nil {
+		return nil, err
+	}
+
+	return outcome, nil
+}
+
+func changes(original, replayed *Outcome) []string {
+	var changed []string
+
+	if original.RiskAssessment != replayed.RiskAssessment {
+		changed = append(changed, "risk_assessment")
+	}
+
+	if !sameReasons(original.Reasons, replayed.Reasons) {
+		changed = append(changed, "reasons")
+	}
+
+	if !sameActions(original.Actions, replayed.Actions) {
+		changed = append(changed, "actions")
+	}
+
+	return changed
+}
+
+func sameReasons(a, b []incognia.Reason) bool {
+	keys := func(reasons []incognia.Reason) []string {
+		out := make([]string, len(reasons))
+		for i, reason := range reasons {
+			out[i] = string(reason.Source) + "/" + string(reason.Code)
+		}
+		return out
+	}
+
+	return sameStrings(keys(a), keys(b))
+}
+
+func sameActions(a, b []incognia.Action) bool {
+	keys := func(actions []incognia.Action) []string {
+		out := make([]string, len(actions))
+		for i, action := range actions {
+			out[i] = string(action)
+		}
+		return out
+	}
+
+	return sameStrings(keys(a), keys(b))
+}
+
+// sameStrings compares a and b ignoring order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newReport(results []Result) *Report {
+	report := &Report{
+		Total:       len(results),
+		Transitions: map[incognia.Assessment]map[incognia.Assessment]int{},
+		Results:     results,
+	}
+
+	for _, result := range results {
+		switch {
+		case result.SkipReason != "":
+			report.Skipped++
+		case result.Error != "":
+			report.Failed++
+		default:
+			report.Replayed++
+
+			from, to := result.Original.RiskAssessment, result.Replayed.RiskAssessment
+			if report.Transitions[from] == nil {
+				report.Transitions[from] = map[incognia.Assessment]int{}
+			}
+			report.Transitions[from][to]++
+
+			if result.Changed() {
+				report.Changed++
+			}
+		}
+	}
+
+	return report
+}
+
+// WriteText writes a summary followed by one line per changed or failed
+// record.
+func (r *Report) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "total: %d, replayed: %d, changed: %d, skipped: %d, failed: %d\n",
+		r.Total, r.Replayed, r.Changed, r.Skipped, r.Failed)
+
+	var origins []incognia.Assessment
+	for from := range r.Transitions {
+		origins = append(origins, from)
+	}
+
+	for _, from := range sortedAssessments(origins) {
+		var targets []incognia.Assessment
+		for to := range r.Transitions[from] {
+			targets = append(targets, to)
+		}
+
+		for _, to := range sortedAssessments(targets) {
+			fmt.Fprintf(bw, "%s -> %s: %d\n", assessmentName(from), assessmentName(to), r.Transitions[from][to])
+		}
+	}
+
+	for _, result := range r.Results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(bw, "#%d %s: error: %s\n", result.Index, result.Operation, result.Error)
+		case result.Changed():
+			fmt.Fprintf(bw, "#%d %s: risk %s -> %s, reasons %s -> %s, actions %s -> %s\n",
+				result.Index, result.Operation,
+				assessmentName(result.Original.RiskAssessment), assessmentName(result.Replayed.RiskAssessment),
+				reasonList(result.Original.Reasons), reasonList(result.Replayed.Reasons),
+				actionList(result.Original.Actions), actionList(result.Replayed.Actions))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func sortedAssessments(assessments []incognia.Assessment) []incognia.Assessment {
+	sort.Slice(assessments, func(i, j int) bool { return assessments[i] < assessments[j] })
+	return assessments
+}
+
+func assessmentName(assessment incognia.Assessment) string {
+	if assessment == "" {
+		return "none"
+	}
+	return string(assessment)
+}
+
+func reasonList(reasons []incognia.Reason) string {
+	list := "["
+	for i, reason := range reasons {
+		if i > 0 {
+			list += " "
+		}
+		list += string(reason.Source) + "/" + string(reason.Code)
+	}
+	return list + "]"
+}
+
+func actionList(actions []incognia.Action) string {
+	list := "["
+	for i, action := range actions {
+		if i > 0 {
+			list += " "
+		}
+		list += string(action)
+	}
+	return list + "]"
+}
@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia"
+)
+
+const auditLog = `{"operation":"register_payment","method":"POST","url":"https://api.incognia.com/api/v2/authentication/transactions?eval=true","request":{"type":"payment","account_id":"account-1","policy_id":"live-policy"},"response":{"id":"1","risk_assessment":"low_risk","reasons":[{"code":"trusted_device","source":"local"}],"actions":["warn-user"]},"status_code":200}
+
+{"operation":"register_login","method":"POST","url":"https://api.incognia.com/api/v2/authentication/transactions","request":{"type":"login","account_id":"account-2"},"status_code":500,"error":"500 Internal Server Error"}
+{"operation":"register_feedback","method":"POST","url":"https://api.incognia.com/api/v2/feedbacks","request":{"event":"verified"},"status_code":200}
+{"operation":"register_signup","method":"POST","url":"https://api.incognia.com/api/v2/onboarding/signups","request":{"installation_id":"installation-id","policy_id":"live-policy"},"response":{"id":"4","risk_assessment":"low_risk","reasons":[{"code":"trusted_device","source":"local"}],"actions":["warn-user"]},"status_code":200}
+`
+
+type ReplayTestSuite struct {
+	suite.Suite
+
+	server *httptest.Server
+	client *incognia.Client
+
+	mutex    sync.Mutex
+	policies []string
+	evals    []string
+	paths    []string
+}
+
+func (suite *ReplayTestSuite) SetupTest() {
+	suite.policies, suite.evals, suite.paths = nil, nil, nil
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			w.Write([]byte(`{"access_token":"token","expires_in":"900","token_type":"Bearer"}`))
+			return
+		}
+
+		var body struct {
+			PolicyID string `json:"policy_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		suite.mutex.Lock()
+		suite.policies = append(suite.policies, body.PolicyID)
+		suite.evals = append(suite.evals, r.URL.Query().Get("eval"))
+		suite.paths = append(suite.paths, r.URL.Path)
+		suite.mutex.Unlock()
+
+		if body.PolicyID == "candidate-policy" {
+			w.Write([]byte(`{"id":"x","risk_assessment":"high_risk","reasons":[{"code":"device_integrity","source":"global"}],"actions":["block"]}`))
+			return
+		}
+		w.Write([]byte(`{"id":"x","risk_assessment":"low_risk","reasons":[{"code":"trusted_device","source":"local"}],"actions":["warn-user"]}`))
+	}))
+
+	var err error
+	suite.client, err = incognia.New(&incognia.IncogniaClientConfig{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		BaseURL:      suite.server.URL,
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *ReplayTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *ReplayTestSuite) records() []*incognia.AuditRecord {
+	records, err := ReadRecords(strings.NewReader(auditLog))
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 4)
+	return records
+}
+
+func (suite *ReplayTestSuite) TestReplayWithoutOverridesReportsNoChanges() {
+	report, err := Replay(context.Background(), &Config{Client: suite.client, Workers: 2}, suite.records())
+	suite.Require().NoError(err)
+
+	suite.Equal(4, report.Total)
+	suite.Equal(2, report.Replayed)
+	suite.Equal(2, report.Skipped)
+	suite.Equal(0, report.Failed)
+	suite.Equal(0, report.Changed)
+	suite.Equal(2, report.Transitions[incognia.LowRisk][incognia.LowRisk])
+
+	suite.Equal(1, report.Results[0].Index)
+	suite.Equal("recorded call failed", report.Results[1].SkipReason)
+	suite.Equal("feedbacks are not replayed", report.Results[2].SkipReason)
+	suite.Equal(incognia.OperationRegisterSignup, report.Results[3].Operation)
+
+	suite.ElementsMatch([]string{"/v2/authentication/transactions", "/v2/onboarding/signups"}, suite.paths)
+	suite.ElementsMatch([]string{"live-policy", "live-policy"}, suite.policies)
+	suite.ElementsMatch([]string{"true", ""}, suite.evals)
+}
+
+func (suite *ReplayTestSuite) TestReplayWithPolicyAndEvalOverride() {
+	shouldNotEval := false
+	report, err := Replay(context.Background(), &Config{
+		Client:    suite.client,
+		RateLimit: 1000,
+		PolicyID:  "candidate-policy",
+		Eval:      &shouldNotEval,
+	}, suite.records())
+	suite.Require().NoError(err)
+
+	suite.Equal(2, report.Changed)
+	suite.Equal(2, report.Transitions[incognia.LowRisk][incognia.HighRisk])
+
+	payment := report.Results[0]
+	suite.Equal([]string{"risk_assessment", "reasons", "actions"}, payment.Changes)
+	suite.Equal(incognia.HighRisk, payment.Replayed.RiskAssessment)
+	suite.Equal([]incognia.Action{incognia.ActionBlock}, payment.Replayed.Actions)
+
+	suite.Equal([]string{"candidate-policy", "candidate-policy"}, suite.policies)
+	suite.Equal([]string{"false", ""}, suite.evals)
+
+	var text bytes.Buffer
+	suite.NoError(report.WriteText(&text))
+	suite.Contains(text.String(), "total: 4, replayed: 2, changed: 2, skipped: 2, failed: 0")
+	suite.Contains(text.String(), "low_risk -> high_risk: 2")
+	suite.Contains(text.String(), "#1 register_payment: risk low_risk -> high_risk, reasons [local/trusted_device] -> [global/device_integrity], actions [warn-user] -> [block]")
+}
+
+func (suite *ReplayTestSuite) TestReplaySkipsRedactedRecords() {
+	records := suite.records()
+	records[0].Redacted = true
+
+	report, err := Replay(context.Background(), &Config{Client: suite.client}, records)
+	suite.Require().NoError(err)
+	suite.Equal(1, report.Replayed)
+	suite.Equal(3, report.Skipped)
+	suite.Equal("recorded request has redacted values", report.Results[0].SkipReason)
+	suite.Equal([]string{"/v2/onboarding/signups"}, suite.paths)
+}
+
+func (suite *ReplayTestSuite) TestReplayReportsFailures() {
+	suite.server.Close()
+
+	report, err := Replay(context.Background(), &Config{Client: suite.client}, suite.records())
+	suite.Require().NoError(err)
+	suite.Equal(2, report.Failed)
+	suite.NotEmpty(report.Results[0].Error)
+}
+
+func (suite *ReplayTestSuite) TestReplayStopsWhenContextIsDone() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := Replay(ctx, &Config{Client: suite.client}, suite.records())
+	suite.Equal(context.Canceled, err)
+	suite.Equal(2, report.Failed)
+	suite.Empty(suite.paths)
+}
+
+func (suite *ReplayTestSuite) TestReadRecordsReportsLine() {
+	_, err := ReadRecords(strings.NewReader("{}\nnot json\n"))
+	suite.EqualError(err, "line 2: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func (suite *ReplayTestSuite) TestMissingClient() {
+	_, err := Replay(context.Background(), &Config{}, nil)
+	suite.Equal(ErrMissingClient, err)
+}
+
+func TestReplayTestSuite(t *testing.T) {
+	suite.Run(t, new(ReplayTestSuite))
+}
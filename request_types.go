@@ -78,6 +78,39 @@ const (
 	SignupDeclined                    FeedbackType = "signup_declined"
 )
 
+var knownFeedbackTypes = map[FeedbackType]bool{
+	AccountAllowed:                    true,
+	DeviceAllowed:                     true,
+	Verified:                          true,
+	Reset:                             true,
+	AccountTakeover:                   true,
+	IdentityFraud:                     true,
+	Chargeback:                        true,
+	ChargebackNotification:            true,
+	PromotionAbuse:                    true,
+	LoginAccepted:                     true,
+	LoginAcceptedByDeviceVerification: true,
+	LoginAcceptedByFacialBiometrics:   true,
+	LoginAcceptedByManualReview:       true,
+	LoginDeclined:                     true,
+	LoginDeclinedByFacialBiometrics:   true,
+	LoginDeclinedByManualReview:       true,
+	PaymentAccepted:                   true,
+	PaymentAcceptedByControlGroup:     true,
+	PaymentAcceptedByThirdParty:       true,
+	PaymentDeclined:                   true,
+	PaymentDeclinedByAcquirer:         true,
+	PaymentDeclinedByBusiness:         true,
+	PaymentDeclinedByManualReview:     true,
+	PaymentDeclinedByRiskAnalysis:     true,
+	SignupAccepted:                    true,
+	SignupDeclined:                    true,
+}
+
+func (f FeedbackType) IsKnown() bool {
+	return knownFeedbackTypes[f]
+}
+
 type postFeedbackRequestBody struct {
 	Event          FeedbackType `json:"event"`
 	OccurredAt     *time.Time   `json:"occurred_at,omitempty"`
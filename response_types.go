@@ -1,6 +1,7 @@
 package incognia
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -10,22 +11,26 @@ import (
 	"time"
 )
 
+// accessToken is created when its request starts, so network delay never
+// extends its validity, and expires margin before the API says it does.
 type accessToken struct {
-	CreatedAt   int64
+	CreatedAt   time.Time
 	ExpiresIn   int64  `json:"expires_in,string"`
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
+
+	margin        time.Duration
+	clock         Clock
+	authorization string
 }
 
 func (token accessToken) IsExpired() bool {
 	expiresAt := token.GetExpiresAt()
-	return time.Now().After(expiresAt)
+	return clockOrSystem(token.clock).Now().After(expiresAt)
 }
 
 func (token accessToken) GetExpiresAt() time.Time {
-	createdAt := token.CreatedAt
-	expiresIn := token.ExpiresIn
-	return time.Unix(createdAt+expiresIn, 0)
+	return token.CreatedAt.Add(time.Duration(token.ExpiresIn)*time.Second - token.margin)
 }
 
 func (token accessToken) Type() string {
@@ -33,7 +38,11 @@ func (token accessToken) Type() string {
 }
 
 func (token accessToken) SetAuthHeader(request *http.Request) {
-	request.Header.Add("Authorization", fmt.Sprintf("%s %s", token.Type(), token.AccessToken))
+	authorization := token.authorization
+	if authorization == "" {
+		authorization = token.Type() + " " + token.AccessToken
+	}
+	request.Header["Authorization"] = append(request.Header["Authorization"], authorization)
 }
 
 type Assessment string
@@ -53,9 +62,79 @@ type Evidence map[string]interface{}
 
 type Signals map[string]interface{}
 
+type ReasonCode string
+
+const (
+	ReasonTrustedLocation    ReasonCode = "trusted_location"
+	ReasonTrustedDevice      ReasonCode = "trusted_device"
+	ReasonTaggedDevice       ReasonCode = "tagged_device"
+	ReasonTaggedAccount      ReasonCode = "tagged_account"
+	ReasonDeviceIntegrity    ReasonCode = "device_integrity"
+	ReasonLocationSpoofing   ReasonCode = "location_spoofing"
+	ReasonEmulator           ReasonCode = "emulator"
+	ReasonAppTampering       ReasonCode = "app_tampering"
+	ReasonRemoteAccess       ReasonCode = "remote_access"
+	ReasonNewDevice          ReasonCode = "new_device"
+	ReasonSuspiciousLocation ReasonCode = "suspicious_location"
+	ReasonHighRiskAddress    ReasonCode = "high_risk_address"
+	ReasonAccountTakeover    ReasonCode = "account_takeover"
+	ReasonIdentityFraud      ReasonCode = "identity_fraud"
+	ReasonPromotionAbuse     ReasonCode = "promotion_abuse"
+	ReasonChargeback         ReasonCode = "chargeback"
+	ReasonMposFraud          ReasonCode = "mpos_fraud"
+)
+
+var knownReasonCodes = map[ReasonCode]bool{
+	ReasonTrustedLocation:    true,
+	ReasonTrustedDevice:      true,
+	ReasonTaggedDevice:       true,
+	ReasonTaggedAccount:      true,
+	ReasonDeviceIntegrity:    true,
+	ReasonLocationSpoofing:   true,
+	ReasonEmulator:           true,
+	ReasonAppTampering:       true,
+	ReasonRemoteAccess:       true,
+	ReasonNewDevice:          true,
+	ReasonSuspiciousLocation: true,
+	ReasonHighRiskAddress:    true,
+	ReasonAccountTakeover:    true,
+	ReasonIdentityFraud:      true,
+	ReasonPromotionAbuse:     true,
+	ReasonChargeback:         true,
+	ReasonMposFraud:          true,
+}
+
+// IsKnown reports whether the code is part of the catalog shipped with this
+// library. Codes added to the API later still decode, they are just unknown.
+func (c ReasonCode) IsKnown() bool {
+	return knownReasonCodes[c]
+}
+
+type ReasonSource string
+
+const (
+	ReasonSourceLocal  ReasonSource = "local"
+	ReasonSourceGlobal ReasonSource = "global"
+)
+
+func (s ReasonSource) IsKnown() bool {
+	return s == ReasonSourceLocal || s == ReasonSourceGlobal
+}
+
 type Reason struct {
-	Code   string
-	Source string
+	Code   ReasonCode
+	Source ReasonSource
+}
+
+type Action string
+
+const (
+	ActionWarnUser Action = "warn-user"
+	ActionBlock    Action = "block"
+)
+
+func (a Action) IsKnown() bool {
+	return a == ActionWarnUser || a == ActionBlock
 }
 
 type jsonMap map[string]interface{}
@@ -72,16 +151,20 @@ func (a Evidence) GetEvidenceAsInt64(evidenceName string) (int64, error) {
 		return 0, ErrEvidenceNotFound
 	}
 
-	var outValue float64
-	if err := a.GetEvidence(evidenceName, &outValue); err != nil {
+	value, err := valueWithPath(jsonMap(a), evidenceName)
+	if err != nil {
 		return 0, err
 	}
+	if number, ok := value.(json.Number); ok {
+		return numberAsInt64(number)
+	}
 
-	for math.Mod(outValue, 1) != 0 {
-		outValue *= 10
+	var outValue float64
+	if err := a.GetEvidence(evidenceName, &outValue); err != nil {
+		return 0, err
 	}
 
-	return int64(outValue), nil
+	return floatAsInt64(outValue), nil
 }
 
 func (s Signals) GetSignal(signalName string, outValue interface{}) error {
@@ -96,22 +179,60 @@ func (s Signals) GetSignalAsInt64(signalName string) (int64, error) {
 		return 0, ErrSignalNotFound
 	}
 
+	value, err := valueWithPath(jsonMap(s), signalName)
+	if err != nil {
+		return 0, err
+	}
+	if number, ok := value.(json.Number); ok {
+		return numberAsInt64(number)
+	}
+
 	var outValue float64
 	if err := s.GetSignal(signalName, &outValue); err != nil {
 		return 0, err
 	}
 
-	for math.Mod(outValue, 1) != 0 {
-		outValue *= 10
+	return floatAsInt64(outValue), nil
+}
+
+// numberAsInt64 reads integers exactly, and other numbers as floatAsInt64.
+func numberAsInt64(number json.Number) (int64, error) {
+	if value, err := number.Int64(); err == nil {
+		return value, nil
+	}
+
+	value, err := number.Float64()
+	if err != nil {
+		return 0, err
+	}
+
+	return floatAsInt64(value), nil
+}
+
+func floatAsInt64(value float64) int64 {
+	for math.Mod(value, 1) != 0 {
+		value *= 10
 	}
 
-	return int64(outValue), nil
+	return int64(value)
 }
 
 func getValueWithPath(root jsonMap, path string, outValue interface{}) error {
+	v, err := valueWithPath(root, path)
+	if err != nil {
+		return err
+	}
+
+	if slice, ok := v.([]interface{}); ok {
+		return setToSlice(slice, outValue)
+	}
+	return setToPointer(v, outValue)
+}
+
+func valueWithPath(root jsonMap, path string) (interface{}, error) {
 	parts := strings.Split(path, ".")
 	if len(parts) == 0 {
-		return ErrEvidenceNotFound
+		return nil, ErrEvidenceNotFound
 	}
 
 	curr := map[string]interface{}(root)
@@ -121,12 +242,12 @@ func getValueWithPath(root jsonMap, path string, outValue interface{}) error {
 
 		v, ok := curr[key]
 		if !ok || v == nil {
-			return ErrEvidenceNotFound
+			return nil, ErrEvidenceNotFound
 		}
 
 		next, ok := v.(map[string]interface{})
 		if !ok || next == nil {
-			return ErrEvidenceNotFound
+			return nil, ErrEvidenceNotFound
 		}
 		curr = next
 	}
@@ -134,13 +255,10 @@ func getValueWithPath(root jsonMap, path string, outValue interface{}) error {
 	lastKey := parts[0]
 	v, ok := curr[lastKey]
 	if !ok || v == nil {
-		return ErrEvidenceNotFound
+		return nil, ErrEvidenceNotFound
 	}
 
-	if slice, ok := v.([]interface{}); ok {
-		return setToSlice(slice, outValue)
-	}
-	return setToPointer(v, outValue)
+	return v, nil
 }
 
 func setToPointer(value interface{}, outValue interface{}) error {
@@ -148,12 +266,16 @@ func setToPointer(value interface{}, outValue interface{}) error {
 	if outputReflectValue.Kind() != reflect.Ptr {
 		return errors.New("expecting outValue to be a pointer")
 	}
+
+	if number, ok := value.(json.Number); ok {
+		return setNumber(number, outputReflectValue.Elem())
+	}
 	indirectOutputValueKind := reflect.Indirect(outputReflectValue).Kind()
 
 	valueReflectValue := reflect.ValueOf(value)
 	valueReflectKind := valueReflectValue.Kind()
 
-	if indirectOutputValueKind != valueReflectKind {
+	if indirectOutputValueKind != valueReflectKind || !valueReflectValue.Type().AssignableTo(outputReflectValue.Elem().Type()) {
 		return fmt.Errorf("expecting outValue to be a pointer to %s", valueReflectKind.String())
 	}
 
@@ -177,27 +299,69 @@ func setToSlice(slice []interface{}, outValue interface{}) error {
 		return nil
 	}
 
-	sliceElemKind := reflect.ValueOf(slice[0]).Kind()
+	sliceElemKind := elementKind(slice[0])
 	for _, e := range slice[1:] {
-		if reflect.ValueOf(e).Kind() != sliceElemKind {
+		if elementKind(e) != sliceElemKind {
 			sliceElemKind = reflect.Interface
 			break
 		}
 	}
 
+	if _, isNumber := slice[0].(json.Number); isNumber && sliceElemKind == reflect.Float64 {
+		return setNumbers(slice, indirectOutputValue)
+	}
+
 	if indirectValueElementKind != sliceElemKind {
 		return fmt.Errorf("expecting outValue to be a pointer to slice of %s", sliceElemKind.String())
 	}
 
+	elemType := indirectOutputValue.Type().Elem()
 	sliceReflectValue := reflect.MakeSlice(indirectOutputValue.Type(), 0, len(slice))
 	for _, e := range slice {
-		sliceReflectValue = reflect.Append(sliceReflectValue, reflect.ValueOf(e))
+		elem := reflect.ValueOf(e)
+		if !elem.Type().AssignableTo(elemType) {
+			return fmt.Errorf("expecting outValue to be a pointer to slice of %s", sliceElemKind.String())
+		}
+		sliceReflectValue = reflect.Append(sliceReflectValue, elem)
 	}
 
 	indirectOutputValue.Set(sliceReflectValue)
 	return nil
 }
 
+// elementKind returns the kind of a decoded value. A json.Number counts as a
+// float64, as it would be decoded without UseNumber.
+func elementKind(value interface{}) reflect.Kind {
+	if _, ok := value.(json.Number); ok {
+		return reflect.Float64
+	}
+
+	return reflect.ValueOf(value).Kind()
+}
+
+// setNumbers sets a slice of json.Number to out, a slice of a numeric kind or
+// of json.Number.
+func setNumbers(slice []interface{}, out reflect.Value) error {
+	elemType := out.Type().Elem()
+	switch elemType.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+	default:
+		if elemType != jsonNumberType {
+			return fmt.Errorf("expecting outValue to be a pointer to slice of %s", reflect.Float64.String())
+		}
+	}
+
+	sliceReflectValue := reflect.MakeSlice(out.Type(), len(slice), len(slice))
+	for i, e := range slice {
+		if err := setNumber(e.(json.Number), sliceReflectValue.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	out.Set(sliceReflectValue)
+	return nil
+}
+
 type SignupAssessment struct {
 	ID             string     `json:"id"`
 	DeviceID       string     `json:"device_id"`
@@ -205,16 +369,69 @@ type SignupAssessment struct {
 	RiskAssessment Assessment `json:"risk_assessment"`
 	Evidence       Evidence   `json:"evidence,omitempty"`
 	Reasons        []Reason   `json:"reasons"`
-	Actions        []string   `json:"actions,omitempty"`
+	Actions        []Action   `json:"actions,omitempty"`
 	Signals        Signals    `json:"signals,omitempty"`
 }
 
 type TransactionAssessment struct {
 	ID             string     `json:"id"`
+	RequestID      string     `json:"request_id"`
 	RiskAssessment Assessment `json:"risk_assessment"`
 	DeviceID       string     `json:"device_id"`
 	Evidence       Evidence   `json:"evidence,omitempty"`
 	Reasons        []Reason   `json:"reasons"`
-	Actions        []string   `json:"actions,omitempty"`
+	Actions        []Action   `json:"actions,omitempty"`
 	Signals        Signals    `json:"signals,omitempty"`
 }
+
+func (a *SignupAssessment) HasReason(code ReasonCode) bool {
+	return hasReason(a.Reasons, code)
+}
+
+func (a *SignupAssessment) ReasonsBySource(source ReasonSource) []Reason {
+	return reasonsBySource(a.Reasons, source)
+}
+
+func (a *SignupAssessment) HasAction(action Action) bool {
+	return hasAction(a.Actions, action)
+}
+
+func (a *TransactionAssessment) HasReason(code ReasonCode) bool {
+	return hasReason(a.Reasons, code)
+}
+
+func (a *TransactionAssessment) ReasonsBySource(source ReasonSource) []Reason {
+	return reasonsBySource(a.Reasons, source)
+}
+
+func (a *TransactionAssessment) HasAction(action Action) bool {
+	return hasAction(a.Actions, action)
+}
+
+func hasReason(reasons []Reason, code ReasonCode) bool {
+	for _, reason := range reasons {
+		if reason.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func reasonsBySource(reasons []Reason, source ReasonSource) []Reason {
+	var filtered []Reason
+	for _, reason := range reasons {
+		if reason.Source == source {
+			filtered = append(filtered, reason)
+		}
+	}
+	return filtered
+}
+
+func hasAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
@@ -111,7 +111,7 @@ func (suite *EvidenceTestSuite) TestUnmarshalAssessmentWithActionsDoesNotFail()
 		"id":"1",
 		"risk_assessment":"low_risk",
 		"device_id":"device-1",
-		"actions":["allow"],
+		"actions":["warn-user"],
 		"reasons":[]
 	}`)
 
@@ -119,7 +119,7 @@ func (suite *EvidenceTestSuite) TestUnmarshalAssessmentWithActionsDoesNotFail()
 	err := json.Unmarshal(payload, &a)
 
 	suite.NoError(err)
-	suite.Equal([]string{"allow"}, a.Actions)
+	suite.Equal([]Action{ActionWarnUser}, a.Actions)
 }
 
 func (suite *EvidenceTestSuite) TestGetEvidenceAsInt64_WhenEvidenceNil_ReturnsNotFound() {
@@ -161,6 +161,26 @@ func (suite *EvidenceTestSuite) TestGetEvidenceAsInt64_WhenEvidenceHasDecimal_Mu
 	suite.Equal(int64(15), result)
 }
 
+func (suite *EvidenceTestSuite) TestGetEvidenceAsInt64_WhenEvidenceIsNotNumber_ReturnsError() {
+	evidence := Evidence{"string_value": "abc", "bool_value": true}
+
+	_, err := evidence.GetEvidenceAsInt64("string_value")
+	suite.EqualError(err, "expecting outValue to be a pointer to string")
+
+	_, err = evidence.GetEvidenceAsInt64("bool_value")
+	suite.EqualError(err, "expecting outValue to be a pointer to bool")
+}
+
+func (suite *EvidenceTestSuite) TestGetEvidence_WhenStringIntoNumber_ReturnsError() {
+	evidence := Evidence{"string_value": "abc", "string_slice": []interface{}{"abc"}}
+
+	var number json.Number
+	suite.EqualError(evidence.GetEvidence("string_value", &number), "expecting outValue to be a pointer to string")
+
+	var numbers []json.Number
+	suite.EqualError(evidence.GetEvidence("string_slice", &numbers), "expecting outValue to be a pointer to slice of string")
+}
+
 func (suite *EvidenceTestSuite) TestGetEvidence_SliceOutNotPointer_ReturnsError() {
 	e := Evidence{"arr": []interface{}{"a", "b"}}
 
@@ -264,3 +284,73 @@ func (suite *SignalsTestSuite) TestGetSignalAsInt64_WhenSignalHasDecimal_Multipl
 	suite.NoError(err)
 	suite.Equal(int64(15), result)
 }
+
+func (suite *SignalsTestSuite) TestGetSignalAsInt64_WhenSignalIsNotNumber_ReturnsError() {
+	s := Signals{"string_value": "abc", "bool_value": true}
+
+	_, err := s.GetSignalAsInt64("string_value")
+	suite.EqualError(err, "expecting outValue to be a pointer to string")
+
+	_, err = s.GetSignalAsInt64("bool_value")
+	suite.EqualError(err, "expecting outValue to be a pointer to bool")
+}
+
+const assessmentWithReasonsJSON = `{
+	"id": "1",
+	"risk_assessment": "high_risk",
+	"device_id": "device-1",
+	"reasons": [
+		{"code": "tagged_device", "source": "local"},
+		{"code": "location_spoofing", "source": "global"},
+		{"code": "brand_new_reason", "source": "global"}
+	],
+	"actions": ["block", "escalate"]
+}`
+
+type ReasonsTestSuite struct {
+	suite.Suite
+
+	transaction TransactionAssessment
+	signup      SignupAssessment
+}
+
+func (suite *ReasonsTestSuite) SetupTest() {
+	suite.NoError(json.Unmarshal([]byte(assessmentWithReasonsJSON), &suite.transaction))
+	suite.NoError(json.Unmarshal([]byte(assessmentWithReasonsJSON), &suite.signup))
+}
+
+func (suite *ReasonsTestSuite) TestHasReason() {
+	suite.True(suite.transaction.HasReason(ReasonTaggedDevice))
+	suite.True(suite.transaction.HasReason(ReasonLocationSpoofing))
+	suite.False(suite.transaction.HasReason(ReasonTrustedLocation))
+
+	suite.True(suite.signup.HasReason(ReasonTaggedDevice))
+	suite.False(suite.signup.HasReason(ReasonEmulator))
+}
+
+func (suite *ReasonsTestSuite) TestUnknownCodesAreKept() {
+	suite.True(suite.transaction.HasReason("brand_new_reason"))
+	suite.False(ReasonCode("brand_new_reason").IsKnown())
+	suite.True(ReasonTaggedDevice.IsKnown())
+}
+
+func (suite *ReasonsTestSuite) TestReasonsBySource() {
+	suite.Equal([]Reason{{Code: ReasonTaggedDevice, Source: ReasonSourceLocal}}, suite.transaction.ReasonsBySource(ReasonSourceLocal))
+	suite.Equal([]Reason{
+		{Code: ReasonLocationSpoofing, Source: ReasonSourceGlobal},
+		{Code: "brand_new_reason", Source: ReasonSourceGlobal},
+	}, suite.signup.ReasonsBySource(ReasonSourceGlobal))
+	suite.Empty(suite.transaction.ReasonsBySource("other"))
+}
+
+func (suite *ReasonsTestSuite) TestHasAction() {
+	suite.True(suite.transaction.HasAction(ActionBlock))
+	suite.True(suite.signup.HasAction("escalate"))
+	suite.False(suite.transaction.HasAction(ActionWarnUser))
+	suite.True(ActionBlock.IsKnown())
+	suite.False(Action("escalate").IsKnown())
+}
+
+func TestReasonsTestSuite(t *testing.T) {
+	suite.Run(t, new(ReasonsTestSuite))
+}
@@ -0,0 +1,383 @@
+package incognia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissingPrimaryClient = errors.New("primary client is required")
+	ErrShadowConfigIsNil    = errors.New("shadow config is required")
+	ErrMissingShadowPolicy  = errors.New("shadow policy id is required")
+)
+
+type ShadowConfig struct {
+	// PolicyID is the candidate policy sent on the shadow copy. It is required.
+	PolicyID string
+	// Eval overrides Eval on the shadow copy when set.
+	Eval *bool
+	// Client sends the shadow copy, which allows using different credentials.
+	// The primary client is used when it is nil.
+	Client  *Client
+	Timeout time.Duration
+	// OnComparison receives both assessments once the shadow copy finishes.
+	// It is called from a separate goroutine, and its panics are reported to
+	// the OnPanic hook of the primary client.
+	OnComparison func(*ShadowComparison)
+}
+
+// ShadowComparison holds the shadow copy of the payment or login, taken
+// before the call returns, so later changes by the caller do not reach it.
+type ShadowComparison struct {
+	Payment          *Payment
+	Login            *Login
+	Primary          *TransactionAssessment
+	PrimaryErr       error
+	PrimaryPolicyID  string
+	Shadow           *TransactionAssessment
+	ShadowErr        error
+	ShadowPolicyID   string
+	ShadowLatency    time.Duration
+	ShadowFinishedAt time.Time
+}
+
+// ShadowClient forwards payments and logins to the primary client and sends a
+// copy of each one under a candidate policy, without affecting the result
+// returned to the caller.
+type ShadowClient struct {
+	primary *Client
+	shadow  *Client
+	config  ShadowConfig
+	wg      sync.WaitGroup
+}
+
+type shadowResult struct {
+	assessment *TransactionAssessment
+	err        error
+	latency    time.Duration
+}
+
+func NewShadowClient(primary *Client, config *ShadowConfig) (*ShadowClient, error) {
+	if primary == nil {
+		return nil, ErrMissingPrimaryClient
+	}
+
+	if config == nil {
+		return nil, ErrShadowConfigIsNil
+	}
+
+	if config.PolicyID == "" {
+		return nil, ErrMissingShadowPolicy
+	}
+
+	shadow := config.Client
+	if shadow == nil {
+		shadow = primary
+	}
+
+	shadowConfig := *config
+	if shadowConfig.Timeout == 0 {
+		shadowConfig.Timeout = defaultNetClientTimeout
+	}
+
+	return &ShadowClient{primary: primary, shadow: shadow, config: shadowConfig}, nil
+}
+
+func (s *ShadowClient) RegisterPayment(payment *Payment) (*TransactionAssessment, error) {
+	if payment == nil {
+		return s.primary.RegisterPayment(payment)
+	}
+
+	shadowPayment := copyPayment(payment)
+	shadowPayment.PolicyID = s.config.PolicyID
+	if s.config.Eval != nil {
+		shadowPayment.Eval = s.config.Eval
+	}
+
+	shadowDone := s.fire(OperationRegisterPayment, func(ctx context.Context) (*TransactionAssessment, error) {
+		return s.shadow.registerPayment(ctx, shadowPayment)
+	})
+
+	assessment, err := s.primary.RegisterPayment(payment)
+
+	s.report(OperationRegisterPayment, shadowDone, ShadowComparison{
+		Payment:         shadowPayment,
+		Primary:         assessment,
+		PrimaryErr:      err,
+		PrimaryPolicyID: payment.PolicyID,
+		ShadowPolicyID:  shadowPayment.PolicyID,
+	})
+
+	return assessment, err
+}
+
+func (s *ShadowClient) RegisterLogin(login *Login) (*TransactionAssessment, error) {
+	if login == nil {
+		return s.primary.RegisterLogin(login)
+	}
+
+	shadowLogin := copyLogin(login)
+	shadowLogin.PolicyID = s.config.PolicyID
+	if s.config.Eval != nil {
+		shadowLogin.Eval = s.config.Eval
+	}
+
+	shadowDone := s.fire(OperationRegisterLogin, func(ctx context.Context) (*TransactionAssessment, error) {
+		return s.shadow.registerLogin(ctx, shadowLogin)
+	})
+
+	assessment, err := s.primary.RegisterLogin(login)
+
+	s.report(OperationRegisterLogin, shadowDone, ShadowComparison{
+		Login:           shadowLogin,
+		Primary:         assessment,
+		PrimaryErr:      err,
+		PrimaryPolicyID: login.PolicyID,
+		ShadowPolicyID:  shadowLogin.PolicyID,
+	})
+
+	return assessment, err
+}
+
+// Wait blocks until every pending shadow request has been reported.
+func (s *ShadowClient) Wait() {
+	s.wg.Wait()
+}
+
+func (s *ShadowClient) fire(operation Operation, call func(ctx context.Context) (*TransactionAssessment, error)) <-chan shadowResult {
+	done := make(chan shadowResult, 1)
+	s.wg.Add(1)
+
+	go func() {
+		start := s.shadow.clock.Now()
+		var result shadowResult
+
+		defer func() {
+			if r := recover(); r != nil {
+				result.assessment = nil
+				result.err = s.shadow.panicError(operation, r)
+			}
+			result.latency = s.shadow.clock.Now().Sub(start)
+			done <- result
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		defer cancel()
+
+		result.assessment, result.err = call(ctx)
+	}()
+
+	return done
+}
+
+func (s *ShadowClient) report(operation Operation, shadowDone <-chan shadowResult, comparison ShadowComparison) {
+	go func() {
+		defer s.wg.Done()
+
+		result := <-shadowDone
+		if s.config.OnComparison == nil {
+			return
+		}
+
+		comparison.Shadow = result.assessment
+		comparison.ShadowErr = result.err
+		comparison.ShadowLatency = result.latency
+		comparison.ShadowFinishedAt = s.shadow.clock.Now()
+
+		defer func() {
+			if r := recover(); r != nil {
+				s.primary.panicError(operation, r)
+			}
+		}()
+		s.config.OnComparison(&comparison)
+	}()
+}
+
+// copyPayment copies payment deeply enough for the shadow goroutine to never
+// read memory shared with the caller.
+func copyPayment(payment *Payment) *Payment {
+	copied := *payment
+	copied.InstallationID = copyString(payment.InstallationID)
+	copied.SessionToken = copyString(payment.SessionToken)
+	copied.Location = copyLocation(payment.Location)
+	copied.Eval = copyBool(payment.Eval)
+	copied.CustomProperties = copyProperties(payment.CustomProperties)
+	copied.PersonID = copyPersonID(payment.PersonID)
+	copied.DebtorAccount = copyBankAccount(payment.DebtorAccount)
+	copied.CreditorAccount = copyBankAccount(payment.CreditorAccount)
+
+	if payment.Coupon != nil {
+		coupon := *payment.Coupon
+		copied.Coupon = &coupon
+	}
+
+	if payment.Value != nil {
+		value := *payment.Value
+		copied.Value = &value
+	}
+
+	if payment.Addresses != nil {
+		copied.Addresses = make([]*TransactionAddress, len(payment.Addresses))
+		for i, address := range payment.Addresses {
+			if address == nil {
+				continue
+			}
+			copiedAddress := *address
+			if address.Coordinates != nil {
+				coordinates := *address.Coordinates
+				copiedAddress.Coordinates = &coordinates
+			}
+			if address.StructuredAddress != nil {
+				structured := *address.StructuredAddress
+				copiedAddress.StructuredAddress = &structured
+			}
+			copied.Addresses[i] = &copiedAddress
+		}
+	}
+
+	if payment.Methods != nil {
+		copied.Methods = make([]*PaymentMethod, len(payment.Methods))
+		for i, method := range payment.Methods {
+			if method == nil {
+				continue
+			}
+			copiedMethod := *method
+			if method.CreditCard != nil {
+				card := *method.CreditCard
+				copiedMethod.CreditCard = &card
+			}
+			if method.DebitCard != nil {
+				card := *method.DebitCard
+				copiedMethod.DebitCard = &card
+			}
+			copied.Methods[i] = &copiedMethod
+		}
+	}
+
+	return &copied
+}
+
+func copyLogin(login *Login) *Login {
+	copied := *login
+	copied.InstallationID = copyString(login.InstallationID)
+	copied.SessionToken = copyString(login.SessionToken)
+	copied.Location = copyLocation(login.Location)
+	copied.Eval = copyBool(login.Eval)
+	copied.CustomProperties = copyProperties(login.CustomProperties)
+	copied.PersonID = copyPersonID(login.PersonID)
+
+	if login.Countries != nil {
+		copied.Countries = append([]string(nil), login.Countries...)
+	}
+
+	return &copied
+}
+
+func copyString(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func copyBool(value *bool) *bool {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func copyLocation(location *Location) *Location {
+	if location == nil {
+		return nil
+	}
+
+	copied := Location{}
+	if location.Latitude != nil {
+		latitude := *location.Latitude
+		copied.Latitude = &latitude
+	}
+	if location.Longitude != nil {
+		longitude := *location.Longitude
+		copied.Longitude = &longitude
+	}
+	if location.CollectedAt != nil {
+		collectedAt := *location.CollectedAt
+		copied.CollectedAt = &collectedAt
+	}
+
+	return &copied
+}
+
+func copyPersonID(personID *PersonID) *PersonID {
+	if personID == nil {
+		return nil
+	}
+	copied := *personID
+	return &copied
+}
+
+func copyBankAccount(account *BankAccountInfo) *BankAccountInfo {
+	if account == nil {
+		return nil
+	}
+
+	copied := *account
+	copied.HolderTaxID = copyPersonID(account.HolderTaxID)
+	if account.PixKeys != nil {
+		copied.PixKeys = make([]*PixKey, len(account.PixKeys))
+		for i, key := range account.PixKeys {
+			if key != nil {
+				copiedKey := *key
+				copied.PixKeys[i] = &copiedKey
+			}
+		}
+	}
+
+	return &copied
+}
+
+func copyProperties(properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		return nil
+	}
+
+	copied := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		copied[key] = copyProperty(value)
+	}
+
+	return copied
+}
+
+// copyProperty copies maps and slices of custom properties. Values of other
+// types than those encoding/json decodes into are encoded right away, and
+// kept as json.RawMessage.
+func copyProperty(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil, string, bool, json.Number,
+		float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return value
+	case map[string]interface{}:
+		return copyProperties(value)
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, element := range value {
+			copied[i] = copyProperty(element)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), value...)
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		return json.RawMessage(encoded)
+	}
+}
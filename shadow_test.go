@@ -0,0 +1,209 @@
+package incognia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ShadowClientTestSuite struct {
+	suite.Suite
+
+	client            *Client
+	tokenServer       *httptest.Server
+	transactionServer *httptest.Server
+
+	mutex        sync.Mutex
+	receivedEval map[string]string
+	delay        map[string]time.Duration
+}
+
+func (suite *ShadowClientTestSuite) SetupTest() {
+	suite.receivedEval = map[string]string{}
+	suite.delay = map[string]time.Duration{}
+
+	suite.tokenServer = mockTokenEndpoint(token, tokenExpiresIn)
+	suite.transactionServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requestBody postTransactionRequestBody
+		json.NewDecoder(r.Body).Decode(&requestBody)
+
+		suite.mutex.Lock()
+		suite.receivedEval[requestBody.PolicyID] = r.URL.Query().Get("eval")
+		delay := suite.delay[requestBody.PolicyID]
+		suite.mutex.Unlock()
+
+		time.Sleep(delay)
+
+		assessment := TransactionAssessment{ID: requestBody.PolicyID, RiskAssessment: LowRisk}
+		if requestBody.PolicyID == "candidate-policy" {
+			assessment.RiskAssessment = HighRisk
+		}
+		res, _ := json.Marshal(assessment)
+		w.Write(res)
+	}))
+
+	suite.client = suite.newClient()
+}
+
+func (suite *ShadowClientTestSuite) TearDownTest() {
+	suite.tokenServer.Close()
+	suite.transactionServer.Close()
+}
+
+func (suite *ShadowClientTestSuite) newClient() *Client {
+	client, _ := New(&IncogniaClientConfig{ClientID: clientID, ClientSecret: clientSecret})
+	client.tokenProvider.(*AutoRefreshTokenProvider).tokenClient.tokenEndpoint = suite.tokenServer.URL
+	client.endpoints.Transactions = suite.transactionServer.URL
+	return client
+}
+
+func (suite *ShadowClientTestSuite) TestPaymentIsShadowedWithCandidatePolicy() {
+	comparisons := make(chan *ShadowComparison, 1)
+	shadowClient, err := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		OnComparison: func(c *ShadowComparison) { comparisons <- c },
+	})
+	suite.Require().NoError(err)
+
+	payment := &Payment{AccountID: "account-id", PolicyID: "live-policy"}
+	assessment, err := shadowClient.RegisterPayment(payment)
+	suite.NoError(err)
+	suite.Equal("live-policy", assessment.ID)
+	suite.Equal(LowRisk, assessment.RiskAssessment)
+	suite.Equal("live-policy", payment.PolicyID)
+
+	comparison := <-comparisons
+	suite.Equal(assessment, comparison.Primary)
+	suite.NoError(comparison.ShadowErr)
+	suite.Equal(HighRisk, comparison.Shadow.RiskAssessment)
+	suite.Equal("live-policy", comparison.PrimaryPolicyID)
+	suite.Equal("candidate-policy", comparison.ShadowPolicyID)
+	suite.Equal("candidate-policy", comparison.Payment.PolicyID)
+}
+
+func (suite *ShadowClientTestSuite) TestLoginIsShadowedWithEvalOverrideAndOwnClient() {
+	comparisons := make(chan *ShadowComparison, 1)
+	shadowClient, err := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		Eval:         &shouldNotEval,
+		Client:       suite.newClient(),
+		OnComparison: func(c *ShadowComparison) { comparisons <- c },
+	})
+	suite.Require().NoError(err)
+
+	_, err = shadowClient.RegisterLogin(&Login{AccountID: "account-id", PolicyID: "live-policy"})
+	suite.NoError(err)
+
+	comparison := <-comparisons
+	suite.NotNil(comparison.Login)
+	suite.NoError(comparison.ShadowErr)
+
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.Equal("", suite.receivedEval["live-policy"])
+	suite.Equal("false", suite.receivedEval["candidate-policy"])
+}
+
+func (suite *ShadowClientTestSuite) TestShadowTimeoutDoesNotAffectPrimary() {
+	suite.delay["candidate-policy"] = 200 * time.Millisecond
+
+	var comparison *ShadowComparison
+	shadowClient, _ := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		Timeout:      20 * time.Millisecond,
+		OnComparison: func(c *ShadowComparison) { comparison = c },
+	})
+
+	assessment, err := shadowClient.RegisterPayment(&Payment{AccountID: "account-id", PolicyID: "live-policy"})
+	suite.NoError(err)
+	suite.Equal("live-policy", assessment.ID)
+
+	shadowClient.Wait()
+	suite.Error(comparison.ShadowErr)
+	suite.Nil(comparison.Shadow)
+}
+
+func (suite *ShadowClientTestSuite) TestShadowCopyIsNotSharedWithCaller() {
+	suite.delay["candidate-policy"] = 20 * time.Millisecond
+
+	comparisons := make(chan *ShadowComparison, 1)
+	shadowClient, _ := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		OnComparison: func(c *ShadowComparison) { comparisons <- c },
+	})
+
+	payment := &Payment{
+		AccountID:        "account-id",
+		PolicyID:         "live-policy",
+		Addresses:        []*TransactionAddress{{Type: Billing, AddressLine: "address"}},
+		Methods:          []*PaymentMethod{{Type: CreditCard, CreditCard: &CardInfo{Bin: "123456"}}},
+		CustomProperties: map[string]interface{}{"nested": map[string]interface{}{"key": "value"}, "address": Coordinates{Lat: 1}},
+	}
+	_, err := shadowClient.RegisterPayment(payment)
+	suite.NoError(err)
+
+	payment.Addresses[0].AddressLine = "changed"
+	payment.Methods[0].CreditCard.Bin = "654321"
+	payment.CustomProperties["nested"].(map[string]interface{})["key"] = "changed"
+	payment.CustomProperties["added"] = true
+
+	comparison := <-comparisons
+	suite.NoError(comparison.ShadowErr)
+	suite.Equal("address", comparison.Payment.Addresses[0].AddressLine)
+	suite.Equal("123456", comparison.Payment.Methods[0].CreditCard.Bin)
+	suite.Equal("value", comparison.Payment.CustomProperties["nested"].(map[string]interface{})["key"])
+	suite.Equal(json.RawMessage(`{"lat":1,"lng":0}`), comparison.Payment.CustomProperties["address"])
+	suite.NotContains(comparison.Payment.CustomProperties, "added")
+}
+
+func (suite *ShadowClientTestSuite) TestOnComparisonPanicsAreReported() {
+	panics := make(chan *PanicError, 1)
+	suite.client.onPanic = func(err *PanicError) { panics <- err }
+
+	shadowClient, _ := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		OnComparison: func(c *ShadowComparison) { panic("comparison failed") },
+	})
+
+	_, err := shadowClient.RegisterLogin(&Login{AccountID: "account-id"})
+	suite.NoError(err)
+	shadowClient.Wait()
+
+	err = <-panics
+	suite.Equal(OperationRegisterLogin, err.(*PanicError).Operation)
+	suite.EqualError(err, "comparison failed")
+}
+
+func (suite *ShadowClientTestSuite) TestNilPaymentIsNotShadowed() {
+	called := false
+	shadowClient, _ := NewShadowClient(suite.client, &ShadowConfig{
+		PolicyID:     "candidate-policy",
+		OnComparison: func(c *ShadowComparison) { called = true },
+	})
+
+	_, err := shadowClient.RegisterPayment(nil)
+	suite.EqualError(err, ErrMissingPayment.Error())
+
+	shadowClient.Wait()
+	suite.False(called)
+}
+
+func (suite *ShadowClientTestSuite) TestConfigErrors() {
+	_, err := NewShadowClient(nil, &ShadowConfig{})
+	suite.EqualError(err, ErrMissingPrimaryClient.Error())
+
+	_, err = NewShadowClient(suite.client, nil)
+	suite.EqualError(err, ErrShadowConfigIsNil.Error())
+
+	_, err = NewShadowClient(suite.client, &ShadowConfig{})
+	suite.EqualError(err, ErrMissingShadowPolicy.Error())
+}
+
+func TestShadowClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ShadowClientTestSuite))
+}
@@ -5,11 +5,16 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	tokenNetClientTimeout = 5 * time.Second
+	tokenNetClientTimeout    = 5 * time.Second
+	defaultTokenExpiryMargin = 10 * time.Second
+	// dateHeaderResolution is how far the Date header can be from the server
+	// time without any skew, since it has whole seconds.
+	dateHeaderResolution = time.Second
 )
 
 var (
@@ -17,21 +22,46 @@ var (
 )
 
 type TokenClient struct {
-	ClientID      string
-	ClientSecret  string
-	netClient     *http.Client
-	tokenEndpoint string
-	UserAgent     string
+	ClientID            string
+	ClientSecret        string
+	CredentialsProvider CredentialsProvider
+	netClient           *http.Client
+	tokenEndpoint       string
+	UserAgent           string
+
+	gracePeriod         time.Duration
+	credentialsMutex    sync.Mutex
+	currentCredentials  Credentials
+	previousCredentials Credentials
+	rotatedAt           time.Time
+	clock               Clock
+
+	expiryMargin time.Duration
+	skewMutex    sync.Mutex
+	skew         time.Duration
 }
 
 type TokenClientConfig struct {
 	ClientID     string
 	ClientSecret string
-	Timeout      time.Duration
+	// CredentialsProvider, when set, is called on every token refresh and
+	// takes precedence over ClientID and ClientSecret.
+	CredentialsProvider CredentialsProvider
+	// CredentialsGracePeriod is how long the previous credentials are still
+	// tried after a rotation, when the new ones are rejected.
+	CredentialsGracePeriod time.Duration
+	Timeout                time.Duration
+	BaseURL                string
+	// Clock stamps the tokens and tells when they expire, the system clock
+	// by default.
+	Clock Clock
+	// ExpiryMargin is how long before the expiry given by the API tokens are
+	// considered expired, 10 seconds by default.
+	ExpiryMargin time.Duration
 }
 
 func NewTokenClient(config *TokenClientConfig) *TokenClient {
-	incogniaEndpoints := getEndpoints()
+	incogniaEndpoints := getEndpoints(config.BaseURL)
 
 	timeout := config.Timeout
 	if timeout == 0 {
@@ -40,30 +70,85 @@ func NewTokenClient(config *TokenClientConfig) *TokenClient {
 
 	userAgent := buildUserAgent(libraryVersion())
 
+	expiryMargin := config.ExpiryMargin
+	if expiryMargin == 0 {
+		expiryMargin = defaultTokenExpiryMargin
+	}
+
 	return &TokenClient{
-		ClientID:      config.ClientID,
-		ClientSecret:  config.ClientSecret,
-		netClient:     &http.Client{Timeout: timeout},
-		tokenEndpoint: incogniaEndpoints.Token,
-		UserAgent:     userAgent,
+		ClientID:            config.ClientID,
+		ClientSecret:        config.ClientSecret,
+		CredentialsProvider: config.CredentialsProvider,
+		netClient:           &http.Client{Timeout: timeout},
+		tokenEndpoint:       incogniaEndpoints.Token,
+		UserAgent:           userAgent,
+		gracePeriod:         config.CredentialsGracePeriod,
+		clock:               clockOrSystem(config.Clock),
+		expiryMargin:        expiryMargin,
+	}
+}
+
+func (tm *TokenClient) requestToken() (Token, error) {
+	current, previous, err := tm.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := tm.requestTokenWithCredentials(current)
+	if err == ErrInvalidCredentials && previous.valid() {
+		return tm.requestTokenWithCredentials(previous)
+	}
+
+	return token, err
+}
+
+// credentials returns the credentials to use and, within the grace period
+// after a rotation, the ones they replaced.
+func (tm *TokenClient) credentials() (Credentials, Credentials, error) {
+	current := Credentials{ClientID: tm.ClientID, ClientSecret: tm.ClientSecret}
+	if tm.CredentialsProvider != nil {
+		var err error
+		current, err = tm.CredentialsProvider.GetCredentials()
+		if err != nil {
+			return Credentials{}, Credentials{}, err
+		}
+	}
+
+	tm.credentialsMutex.Lock()
+	defer tm.credentialsMutex.Unlock()
+
+	if current != tm.currentCredentials {
+		if tm.currentCredentials.valid() {
+			tm.previousCredentials = tm.currentCredentials
+			tm.rotatedAt = tm.clock.Now()
+		}
+		tm.currentCredentials = current
+	}
+
+	if tm.previousCredentials.valid() && tm.clock.Now().Sub(tm.rotatedAt) < tm.gracePeriod {
+		return current, tm.previousCredentials, nil
 	}
+
+	return current, Credentials{}, nil
 }
 
-func (tm TokenClient) requestToken() (Token, error) {
+func (tm *TokenClient) requestTokenWithCredentials(credentials Credentials) (Token, error) {
 	req, err := http.NewRequest("POST", tm.tokenEndpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.SetBasicAuth(tm.ClientID, tm.ClientSecret)
+	req.SetBasicAuth(credentials.ClientID, credentials.ClientSecret)
 	req.Header.Add("content-type", "application/x-www-form-urlencoded")
 	req.Header.Add("User-Agent", tm.UserAgent)
 
+	startedAt := tm.clock.Now()
 	res, err := tm.netClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+	receivedAt := tm.clock.Now()
 
 	if res.StatusCode == http.StatusUnauthorized {
 		return nil, ErrInvalidCredentials
@@ -74,12 +159,65 @@ func (tm TokenClient) requestToken() (Token, error) {
 	}
 
 	result := &accessToken{
-		CreatedAt: time.Now().Unix(),
+		CreatedAt: startedAt,
+		clock:     tm.clock,
 	}
 
 	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
 		return nil, err
 	}
 
+	result.authorization = result.TokenType + " " + result.AccessToken
+
+	skew := tm.estimateSkew(res, startedAt, receivedAt)
+	result.margin = tm.margin(skew, time.Duration(result.ExpiresIn)*time.Second)
+
 	return result, nil
 }
+
+// estimateSkew compares the Date header of res with the local time halfway
+// through the request. A positive skew means the server clock is ahead.
+func (tm *TokenClient) estimateSkew(res *http.Response, startedAt, receivedAt time.Time) time.Duration {
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+
+	skew := date.Sub(startedAt.Add(receivedAt.Sub(startedAt) / 2))
+
+	tm.skewMutex.Lock()
+	tm.skew = skew
+	tm.skewMutex.Unlock()
+
+	return skew
+}
+
+// margin is the expiry margin widened by the skew beyond the resolution of
+// the Date header, since the server checks expiry with its own clock. It is
+// at most half the token lifetime, so a wrong clock does not make every
+// token expire right away.
+func (tm *TokenClient) margin(skew, lifetime time.Duration) time.Duration {
+	if skew < 0 {
+		skew = -skew
+	}
+
+	margin := tm.expiryMargin
+	if skew > dateHeaderResolution {
+		margin += skew
+	}
+
+	if margin > lifetime/2 {
+		return lifetime / 2
+	}
+
+	return margin
+}
+
+// ClockSkew returns how far ahead of the local clock the API clock was at
+// the last token request, estimated from the Date header of its response.
+func (tm *TokenClient) ClockSkew() time.Duration {
+	tm.skewMutex.Lock()
+	defer tm.skewMutex.Unlock()
+
+	return tm.skew
+}
@@ -0,0 +1,100 @@
+package incognia
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia/incogniatest"
+)
+
+type TokenClientTestSuite struct {
+	suite.Suite
+
+	clock *incogniatest.Clock
+}
+
+func (suite *TokenClientTestSuite) SetupTest() {
+	suite.clock = incogniatest.NewClock(time.Date(2024, 7, 22, 15, 20, 0, 0, time.UTC))
+}
+
+func (suite *TokenClientTestSuite) newTokenClient(endpoint string, margin time.Duration) *TokenClient {
+	tokenClient := NewTokenClient(&TokenClientConfig{ClientID: clientID, ClientSecret: clientSecret, Clock: suite.clock, ExpiryMargin: margin})
+	tokenClient.tokenEndpoint = endpoint
+	return tokenClient
+}
+
+func (suite *TokenClientTestSuite) TestExpiryCountsFromRequestStart() {
+	startedAt := suite.clock.Now()
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.clock.Advance(3 * time.Second)
+		w.Header().Set("Date", suite.clock.Now().Add(-1500*time.Millisecond).Format(http.TimeFormat))
+		w.Write([]byte(`{"access_token":"token","expires_in":"1000","token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	token, err := suite.newTokenClient(tokenServer.URL, 0).requestToken()
+	suite.Require().NoError(err)
+	suite.True(startedAt.Add(990*time.Second).Equal(token.GetExpiresAt()), "the response took 3s and the server time is halfway through")
+}
+
+func (suite *TokenClientTestSuite) TestSkewWidensMargin() {
+	tokenClient := suite.newTokenClient(mockTokenEndpointAt(suite.clock, time.Minute, "1000").URL, 0)
+
+	token, err := tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.Equal(time.Minute, tokenClient.ClockSkew())
+	suite.True(suite.clock.Now().Add(930 * time.Second).Equal(token.GetExpiresAt()))
+
+	tokenClient.tokenEndpoint = mockTokenEndpointAt(suite.clock, -time.Minute, "1000").URL
+	token, err = tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.Equal(-time.Minute, tokenClient.ClockSkew())
+	suite.True(suite.clock.Now().Add(930 * time.Second).Equal(token.GetExpiresAt()))
+
+	tokenClient.tokenEndpoint = mockTokenEndpointAt(suite.clock, time.Second, "1000").URL
+	token, err = tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.True(suite.clock.Now().Add(990*time.Second).Equal(token.GetExpiresAt()), "the Date header has whole seconds")
+}
+
+func (suite *TokenClientTestSuite) TestMarginIsAtMostHalfTheLifetime() {
+	tokenClient := suite.newTokenClient(mockTokenEndpointAt(suite.clock, 24*time.Hour, "1000").URL, 0)
+
+	token, err := tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.True(suite.clock.Now().Add(500 * time.Second).Equal(token.GetExpiresAt()))
+
+	tokenClient = suite.newTokenClient(mockTokenEndpointAt(suite.clock, 0, "1000").URL, time.Minute)
+	token, err = tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.True(suite.clock.Now().Add(940 * time.Second).Equal(token.GetExpiresAt()))
+}
+
+func (suite *TokenClientTestSuite) TestMissingDateHeader() {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+		w.Write([]byte(`{"access_token":"token","expires_in":"1000","token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	tokenClient := suite.newTokenClient(tokenServer.URL, 0)
+	token, err := tokenClient.requestToken()
+	suite.Require().NoError(err)
+	suite.Equal(time.Duration(0), tokenClient.ClockSkew())
+	suite.True(suite.clock.Now().Add(990 * time.Second).Equal(token.GetExpiresAt()))
+}
+
+func TestTokenClientTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenClientTestSuite))
+}
+
+// mockTokenEndpointAt answers with a Date header skew ahead of clock.
+func mockTokenEndpointAt(clock *incogniatest.Clock, skew time.Duration, expiresIn string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", clock.Now().Add(skew).Format(http.TimeFormat))
+		w.Write([]byte(`{"access_token":"token","expires_in":"` + expiresIn + `","token_type":"Bearer"}`))
+	}))
+}
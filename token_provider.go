@@ -24,13 +24,25 @@ type Token interface {
 }
 
 type ManualRefreshTokenProvider struct {
+	refreshStats
+
 	tokenClient *TokenClient
 	token       Token
 	tokenMutex  sync.RWMutex
+	clock       Clock
 }
 
 func NewManualRefreshTokenProvider(tokenClient *TokenClient) *ManualRefreshTokenProvider {
-	return &ManualRefreshTokenProvider{tokenClient: tokenClient}
+	return &ManualRefreshTokenProvider{tokenClient: tokenClient, clock: clockOrSystem(tokenClient.clock)}
+}
+
+// SetClock sets the clock telling when tokens expire, the one of the
+// TokenClient by default.
+func (t *ManualRefreshTokenProvider) SetClock(clock Clock) {
+	t.tokenMutex.Lock()
+	defer t.tokenMutex.Unlock()
+
+	t.clock = clockOrSystem(clock)
 }
 
 func (t *ManualRefreshTokenProvider) GetToken() (Token, error) {
@@ -41,7 +53,7 @@ func (t *ManualRefreshTokenProvider) GetToken() (Token, error) {
 		return nil, ErrTokenNotFound
 	}
 
-	if t.token.IsExpired() {
+	if tokenExpired(t.clock, t.token) {
 		return nil, ErrTokenExpired
 	}
 
@@ -50,36 +62,56 @@ func (t *ManualRefreshTokenProvider) GetToken() (Token, error) {
 
 func (t *ManualRefreshTokenProvider) Refresh() (Token, error) {
 	accessToken, err := t.tokenClient.requestToken()
+
+	t.tokenMutex.Lock()
+	if err == nil {
+		t.token = accessToken
+	}
+	refreshedAt := t.clock.Now()
+	t.tokenMutex.Unlock()
+
 	if err != nil {
+		t.recordRefresh(refreshedAt, nil, err)
 		return nil, err
 	}
 
-	t.tokenMutex.Lock()
-	defer t.tokenMutex.Unlock()
-
-	t.token = accessToken
+	t.recordRefresh(refreshedAt, accessToken, nil)
 
-	return t.token, nil
+	return accessToken, nil
 }
 
 type AutoRefreshTokenProvider struct {
+	refreshStats
+
 	tokenClient *TokenClient
 	token       Token
 	tokenMutex  sync.RWMutex
+	clock       Clock
 }
 
 func NewAutoRefreshTokenProvider(tokenClient *TokenClient) *AutoRefreshTokenProvider {
 	return &AutoRefreshTokenProvider{
 		tokenClient: tokenClient,
+		clock:       clockOrSystem(tokenClient.clock),
 	}
 }
 
+// SetClock sets the clock telling when tokens expire, the one of the
+// TokenClient by default.
+func (t *AutoRefreshTokenProvider) SetClock(clock Clock) {
+	t.tokenMutex.Lock()
+	defer t.tokenMutex.Unlock()
+
+	t.clock = clockOrSystem(clock)
+}
+
 func (t *AutoRefreshTokenProvider) GetToken() (Token, error) {
 	t.tokenMutex.RLock()
 	token := t.token
+	expired := token == nil || tokenExpired(t.clock, token)
 	t.tokenMutex.RUnlock()
 
-	if token != nil && !token.IsExpired() {
+	if !expired {
 		return token, nil
 	}
 
@@ -88,18 +120,30 @@ func (t *AutoRefreshTokenProvider) GetToken() (Token, error) {
 
 func (t *AutoRefreshTokenProvider) refresh() (Token, error) {
 	t.tokenMutex.Lock()
-	defer t.tokenMutex.Unlock()
 
-	if t.token != nil && !t.token.IsExpired() {
-		return t.token, nil
+	if t.token != nil && !tokenExpired(t.clock, t.token) {
+		token := t.token
+		t.tokenMutex.Unlock()
+		return token, nil
 	}
 
 	accessToken, err := t.tokenClient.requestToken()
+	if err == nil {
+		t.token = accessToken
+	}
+	refreshedAt := t.clock.Now()
+	t.tokenMutex.Unlock()
+
 	if err != nil {
+		t.recordRefresh(refreshedAt, nil, err)
 		return nil, err
 	}
 
-	t.token = accessToken
+	t.recordRefresh(refreshedAt, accessToken, nil)
 
-	return t.token, nil
+	return accessToken, nil
+}
+
+func tokenExpired(clock Clock, token Token) bool {
+	return clock.Now().After(token.GetExpiresAt())
 }
@@ -10,17 +10,18 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/suite"
+	"repo.incognia.com/go/incognia/incogniatest"
 )
 
 var (
 	accessTokenFixture = &accessToken{
-		CreatedAt:   time.Now().Unix(),
+		CreatedAt:   time.Now(),
 		ExpiresIn:   int64(1600),
 		AccessToken: "some-token",
 		TokenType:   "Bearer",
 	}
 	expiredTokenFixture = &accessToken{
-		CreatedAt:   time.Now().Unix(),
+		CreatedAt:   time.Now(),
 		ExpiresIn:   0,
 		AccessToken: "some-token",
 		TokenType:   "Bearer",
@@ -87,6 +88,67 @@ func (suite *ManualRefreshTokenProviderTestSuite) TestRefreshUnauthorized() {
 	suite.EqualError(err, ErrInvalidCredentials.Error())
 }
 
+func (suite *ManualRefreshTokenProviderTestSuite) TestRefreshStats() {
+	var refreshes []TokenRefresh
+	suite.tokenProvider.OnRefresh(func(refresh TokenRefresh) {
+		refreshes = append(refreshes, refresh)
+	})
+
+	suite.tokenProvider.tokenClient.tokenEndpoint = mockTokenEndpoint(accessTokenFixture.AccessToken, "1000").URL
+	token, err := suite.tokenProvider.Refresh()
+	suite.Require().NoError(err)
+
+	stats := suite.tokenProvider.TokenStats()
+	suite.Equal(int64(1), stats.Refreshes)
+	suite.Equal(int64(0), stats.Failures)
+	suite.NoError(stats.LastError)
+	suite.False(stats.LastRefreshAt.IsZero())
+	suite.Equal(token.GetExpiresAt(), stats.ExpiresAt)
+
+	suite.tokenProvider.tokenClient.tokenEndpoint = mockStatusServer(http.StatusUnauthorized).URL
+	_, err = suite.tokenProvider.Refresh()
+	suite.Equal(ErrInvalidCredentials, err)
+
+	stats = suite.tokenProvider.TokenStats()
+	suite.Equal(int64(1), stats.Refreshes)
+	suite.Equal(int64(1), stats.Failures)
+	suite.Equal(ErrInvalidCredentials, stats.LastError)
+	suite.False(stats.LastErrorAt.IsZero())
+	suite.Equal(token.GetExpiresAt(), stats.ExpiresAt, "the expiry is the one of the latest token obtained")
+
+	suite.Require().Len(refreshes, 2)
+	suite.Equal(token, refreshes[0].Token)
+	suite.NoError(refreshes[0].Err)
+	suite.Nil(refreshes[1].Token)
+	suite.Equal(ErrInvalidCredentials, refreshes[1].Err)
+}
+
+func (suite *ManualRefreshTokenProviderTestSuite) TestClockExpiresToken() {
+	clock := incogniatest.NewClock(time.Date(2024, 7, 22, 15, 20, 0, 0, time.UTC))
+	tokenClient := NewTokenClient(&TokenClientConfig{ClientID: clientID, ClientSecret: clientSecret, Clock: clock})
+	tokenClient.tokenEndpoint = mockTokenEndpointAt(clock, 0, "1000").URL
+	tokenProvider := NewManualRefreshTokenProvider(tokenClient)
+
+	token, err := tokenProvider.Refresh()
+	suite.Require().NoError(err)
+	suite.True(clock.Now().Add(990 * time.Second).Equal(token.GetExpiresAt()))
+	suite.Equal(clock.Now(), tokenProvider.TokenStats().LastRefreshAt)
+
+	clock.Advance(990 * time.Second)
+	_, err = tokenProvider.GetToken()
+	suite.NoError(err)
+	suite.False(token.IsExpired())
+
+	clock.Advance(time.Second)
+	_, err = tokenProvider.GetToken()
+	suite.Equal(ErrTokenExpired, err)
+	suite.True(token.IsExpired())
+
+	tokenProvider.SetClock(incogniatest.NewClock(clock.Now().Add(-time.Hour)))
+	_, err = tokenProvider.GetToken()
+	suite.NoError(err, "the provider clock tells when tokens expire")
+}
+
 func (suite *ManualRefreshTokenProviderTestSuite) TestManualRefreshConcurrency() {
 	tokenServer := mockTokenEndpoint(accessTokenFixture.AccessToken, "1000")
 	defer tokenServer.Close()
@@ -218,6 +280,58 @@ func (suite *AutoRefreshTokenProviderTestSuite) TestRefreshUnauthorized() {
 	suite.EqualError(err, ErrInvalidCredentials.Error())
 }
 
+func (suite *AutoRefreshTokenProviderTestSuite) TestRefreshStats() {
+	var refreshes []TokenRefresh
+	suite.tokenProvider.OnRefresh(func(refresh TokenRefresh) {
+		_, err := suite.tokenProvider.GetToken()
+		suite.NoError(err, "the callback may use the provider")
+		refreshes = append(refreshes, refresh)
+	})
+
+	suite.tokenProvider.tokenClient.tokenEndpoint = mockTokenEndpoint(accessTokenFixture.AccessToken, "1000").URL
+	token, err := suite.tokenProvider.GetToken()
+	suite.Require().NoError(err)
+	_, err = suite.tokenProvider.GetToken()
+	suite.Require().NoError(err)
+
+	stats := suite.tokenProvider.TokenStats()
+	suite.Equal(int64(1), stats.Refreshes, "cached tokens are not refreshes")
+	suite.Equal(token.GetExpiresAt(), stats.ExpiresAt)
+	suite.Require().Len(refreshes, 1)
+	suite.Equal(token, refreshes[0].Token)
+
+	suite.tokenProvider.OnRefresh(nil)
+	suite.tokenProvider.token = expiredTokenFixture
+	suite.tokenProvider.tokenClient.tokenEndpoint = mockStatusServer(http.StatusInternalServerError).URL
+	_, err = suite.tokenProvider.GetToken()
+	suite.Error(err)
+
+	stats = suite.tokenProvider.TokenStats()
+	suite.Equal(int64(1), stats.Failures)
+	suite.Equal(err, stats.LastError)
+	suite.Len(refreshes, 1)
+}
+
+func (suite *AutoRefreshTokenProviderTestSuite) TestClockExpiresToken() {
+	clock := incogniatest.NewClock(time.Date(2024, 7, 22, 15, 20, 0, 0, time.UTC))
+	tokenClient := NewTokenClient(&TokenClientConfig{ClientID: clientID, ClientSecret: clientSecret, Clock: clock})
+	tokenClient.tokenEndpoint = mockTokenEndpointAt(clock, 0, "1000").URL
+	tokenProvider := NewAutoRefreshTokenProvider(tokenClient)
+
+	_, err := tokenProvider.GetToken()
+	suite.Require().NoError(err)
+	clock.Advance(990 * time.Second)
+	_, err = tokenProvider.GetToken()
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), tokenProvider.TokenStats().Refreshes)
+
+	clock.Advance(time.Second)
+	token, err := tokenProvider.GetToken()
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), tokenProvider.TokenStats().Refreshes)
+	suite.True(clock.Now().Add(990 * time.Second).Equal(token.GetExpiresAt()))
+}
+
 func (suite *AutoRefreshTokenProviderTestSuite) TestAutoRefreshConcurrency() {
 	tokenServer := mockTokenEndpoint(accessTokenFixture.AccessToken, "1000")
 	suite.tokenProvider.tokenClient.tokenEndpoint = tokenServer.URL
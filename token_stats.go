@@ -0,0 +1,77 @@
+package incognia
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenStats reports on the refreshes of a token provider. LastError is the
+// error of the latest refresh attempt, nil when it succeeded, and ExpiresAt
+// is the expiry of the latest token obtained.
+type TokenStats struct {
+	LastRefreshAt time.Time
+	LastErrorAt   time.Time
+	LastError     error
+	Refreshes     int64
+	Failures      int64
+	ExpiresAt     time.Time
+}
+
+// TokenRefresh describes a refresh attempt. Token is nil when it failed.
+type TokenRefresh struct {
+	At    time.Time
+	Token Token
+	Err   error
+}
+
+// TokenProviderStats is implemented by AutoRefreshTokenProvider and
+// ManualRefreshTokenProvider. OnRefresh sets a callback called after every
+// refresh attempt, from the goroutine that made it.
+type TokenProviderStats interface {
+	TokenStats() TokenStats
+	OnRefresh(callback func(TokenRefresh))
+}
+
+type refreshStats struct {
+	statsMutex sync.Mutex
+	stats      TokenStats
+	onRefresh  func(TokenRefresh)
+}
+
+func (r *refreshStats) TokenStats() TokenStats {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	return r.stats
+}
+
+func (r *refreshStats) OnRefresh(callback func(TokenRefresh)) {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	r.onRefresh = callback
+}
+
+// recordRefresh must be called without holding the token lock, so that the
+// callback may use the provider.
+func (r *refreshStats) recordRefresh(at time.Time, token Token, err error) {
+	refresh := TokenRefresh{At: at, Token: token, Err: err}
+
+	r.statsMutex.Lock()
+	r.stats.LastError = err
+	if err != nil {
+		refresh.Token = nil
+		r.stats.LastErrorAt = refresh.At
+		r.stats.Failures++
+	} else {
+		r.stats.LastRefreshAt = refresh.At
+		r.stats.Refreshes++
+		r.stats.ExpiresAt = token.GetExpiresAt()
+	}
+	callback := r.onRefresh
+	r.statsMutex.Unlock()
+
+	if callback != nil {
+		callback(refresh)
+	}
+}